@@ -1,59 +1,278 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
+	"encoding/csv"
+	"errors"
 	"fmt"
-	"log"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"log/slog"
+	"math"
 	"math/rand"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
 )
 
 type Bot struct {
 	api    *tgbotapi.BotAPI
 	db     *sql.DB
 	logger *slog.Logger
+
+	// riskRemindMinStreak is the minimum streak for a user to be nudged by /riskremind
+	riskRemindMinStreak int
+	// maxStreakDays is the longest streak an admin can set, so a typo can't insert a huge history
+	maxStreakDays int
+	// markCooldown is the minimum interval between completion-state changes of one user
+	markCooldown time.Duration
+	// admins may run support and moderation commands
+	admins map[int64]bool
+	// superAdmins may run commands that expose the whole database, like /backup
+	superAdmins map[int64]bool
+
+	// quotes are the quotes of the day attached to the noon reminder
+	quotes []string
+
+	// channelID is the channel that gets a daily public scoreboard, 0 if none
+	channelID int64
+	// channelSourceChatID is the chat whose scoreboard is posted to the channel
+	channelSourceChatID int64
+	// channelPostHour is the hour of the channel post in the source chat's timezone
+	channelPostHour int
+
+	// statsAggregation turns on the daily anonymized stats across all chats
+	statsAggregation bool
+
+	// breaker pauses update handling while the database is unavailable
+	breaker dbBreaker
+
+	// sendLimiter spaces out outgoing messages to stay under Telegram's rate limit
+	sendLimiter *sendLimiter
+
+	// participantsCache keeps each chat's recently built participants list. participantsCacheGen
+	// counts a chat's invalidations so a list built before one isn't stored afterwards.
+	participantsCache    map[int64]*participantsCacheEntry
+	participantsCacheGen map[int64]uint64
+	participantsCacheMu  sync.RWMutex
+
+	// recentCallbacks remembers recently handled callback queries until they expire
+	recentCallbacks   map[string]time.Time
+	recentCallbacksMu sync.Mutex
+
+	// recentStartLists remembers when /start last sent a participant the list in a chat
+	recentStartLists   map[startListKey]time.Time
+	recentStartListsMu sync.Mutex
 }
 
 func NewBot(api *tgbotapi.BotAPI, db *sql.DB) *Bot {
 	return &Bot{
-		api:    api,
-		db:     db,
-		logger: slog.Default(),
+		api:                  api,
+		db:                   db,
+		logger:               slog.Default(),
+		riskRemindMinStreak:  getEnvInt("RISK_REMIND_MIN_STREAK", 3),
+		markCooldown:         time.Duration(getEnvInt("MARK_COOLDOWN_SECONDS", 60)) * time.Second,
+		maxStreakDays:        getEnvInt("MAX_STREAK_DAYS", 3650),
+		admins:               getEnvIDs("ADMIN_USER_IDS"),
+		superAdmins:          getEnvIDs("SUPER_ADMIN_IDS"),
+		recentCallbacks:      make(map[string]time.Time),
+		recentStartLists:     make(map[startListKey]time.Time),
+		participantsCache:    make(map[int64]*participantsCacheEntry),
+		participantsCacheGen: make(map[int64]uint64),
+		quotes:               getEnvQuotes("QUOTES_FILE"),
+		channelID:            getEnvInt64("CHANNEL_ID"),
+		channelSourceChatID:  getEnvInt64("CHANNEL_SOURCE_CHAT_ID"),
+		channelPostHour:      getEnvInt("CHANNEL_POST_HOUR", 22),
+		statsAggregation:     getEnvInt("STATS_AGGREGATION", 0) == 1,
+		sendLimiter:          newSendLimiter(getEnvInt("SEND_RATE_PER_SECOND", 25)),
+	}
+}
+
+// isAdmin reports whether the user may run admin commands. Super admins are admins too.
+func (b *Bot) isAdmin(userID int64) bool {
+	return b.admins[userID] || b.superAdmins[userID]
+}
+
+// getEnvIDs reads a comma-separated list of Telegram user IDs from the environment
+func getEnvIDs(key string) map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			slog.Warn("invalid user ID in env, skipping", "key", key, "value", part)
+			continue
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+// getEnvInt reads an integer from the environment, falling back to def if unset or invalid
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("invalid integer in env, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+	return parsed
+}
+
+// getEnvInt64 reads a 64-bit integer such as a chat ID from the environment, 0 if unset or invalid
+func getEnvInt64(key string) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		slog.Warn("invalid integer in env, ignoring", "key", key, "value", value)
+		return 0
+	}
+	return parsed
+}
+
+// getEnvQuotes reads quotes of the day, one per line, from the file named in the environment.
+// Falls back to the built-in Quotes if the variable is unset or the file is unusable.
+func getEnvQuotes(key string) []string {
+	path := os.Getenv(key)
+	if path == "" {
+		return Quotes
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("failed to read quotes file, using defaults", "key", key, "path", path, "error", err)
+		return Quotes
+	}
+
+	var quotes []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			quotes = append(quotes, line)
+		}
+	}
+	if len(quotes) == 0 {
+		slog.Warn("quotes file is empty, using defaults", "key", key, "path", path)
+		return Quotes
 	}
+	return quotes
 }
 
-func initDB() (*sql.DB, error) {
+func initDB(path string) (*sql.DB, error) {
 	// Create data directory if it doesn't exist
-	if err := os.MkdirAll("./data", 0700); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	dbPath := "./data/database.db"
-
 	// Create the database file if it doesn't exist
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		file, err := os.Create(dbPath)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		file, err := os.Create(path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create database file: %w", err)
 		}
 		file.Close()
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	_, err = db.Exec(`
+	if err := applyMigrations(db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migration is one step of the schema history, run inside a transaction
+type migration func(tx *sql.Tx) error
+
+// migrations are applied in order on startup, migrations[i] brings the schema to
+// version i+1. Applied migrations must never change: add a new one instead.
+var migrations = []migration{
+	migrateInitialSchema,
+	migrateGroupCelebrations,
+	migratePendingJoinEmptyReplies,
+	migrateBackfillMilestones,
+	migrateCompletionChangeDate,
+	migrateJoinPrompts,
+	migrateChatRecordsPerChat,
+}
+
+// applyMigrations brings the schema up to date. Every migration runs in its own
+// transaction together with its schema_version record, so a failed one leaves
+// the database at the previous version.
+func applyMigrations(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER PRIMARY KEY, applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", version+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %w", version+1, err)
+		}
+		slog.Info("applied database migration", "version", version+1)
+	}
+	return nil
+}
+
+// migrateInitialSchema is the schema as it was before versioning. Databases created back
+// then already have parts of it, so every step checks before changing anything.
+func migrateInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 		CREATE TABLE IF NOT EXISTS participants (
 			user_id INTEGER PRIMARY KEY,
 			username TEXT,
@@ -88,1063 +307,8225 @@ func initDB() (*sql.DB, error) {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			PRIMARY KEY (user_id, chat_id)
 		);
+		CREATE TABLE IF NOT EXISTS chat_records (
+			record_type TEXT PRIMARY KEY,
+			user_id INTEGER,
+			value INTEGER,
+			started_at DATE,
+			ended_at DATE,
+			achieved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS chat_settings (
+			chat_id INTEGER,
+			key TEXT,
+			value TEXT,
+			PRIMARY KEY (chat_id, key)
+		);
+		CREATE TABLE IF NOT EXISTS digest_subscriptions (
+			user_id INTEGER PRIMARY KEY,
+			weekday INTEGER,
+			hour INTEGER,
+			last_sent DATE,
+			FOREIGN KEY (user_id) REFERENCES participants(user_id)
+		);
+		CREATE TABLE IF NOT EXISTS user_settings (
+			user_id INTEGER,
+			key TEXT,
+			value TEXT,
+			PRIMARY KEY (user_id, key)
+		);
+		CREATE TABLE IF NOT EXISTS vacations (
+			user_id INTEGER,
+			started_at DATE,
+			ended_at DATE,
+			PRIMARY KEY (user_id, started_at),
+			FOREIGN KEY (user_id) REFERENCES participants(user_id)
+		);
+		CREATE TABLE IF NOT EXISTS completion_reactions (
+			user_id INTEGER,
+			completed_at DATE,
+			reactor_id INTEGER,
+			PRIMARY KEY (user_id, completed_at, reactor_id)
+		);
+		CREATE TABLE IF NOT EXISTS admin_audit (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			admin_id INTEGER,
+			action TEXT,
+			target_user_id INTEGER,
+			old_value INTEGER,
+			new_value INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS skipped_days (
+			user_id INTEGER,
+			skipped_on DATE,
+			PRIMARY KEY (user_id, skipped_on),
+			FOREIGN KEY (user_id) REFERENCES participants(user_id)
+		);
+		CREATE TABLE IF NOT EXISTS teams (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE COLLATE NOCASE
+		);
+		CREATE TABLE IF NOT EXISTS team_members (
+			chat_id INTEGER PRIMARY KEY,
+			team_id INTEGER,
+			chat_title TEXT,
+			FOREIGN KEY (team_id) REFERENCES teams(id)
+		);
+		CREATE TABLE IF NOT EXISTS completion_changes (
+			user_id INTEGER PRIMARY KEY,
+			changed_at INTEGER,
+			FOREIGN KEY (user_id) REFERENCES participants(user_id)
+		);
+		CREATE TABLE IF NOT EXISTS failed_reminders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER,
+			text TEXT,
+			disable_notification BOOLEAN,
+			attempts INTEGER DEFAULT 1,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS streak_freezes (
+			user_id INTEGER,
+			frozen_on DATE,
+			PRIMARY KEY (user_id, frozen_on),
+			FOREIGN KEY (user_id) REFERENCES participants(user_id)
+		);
+		CREATE TABLE IF NOT EXISTS stats_daily (
+			date DATE PRIMARY KEY,
+			active_users INTEGER,
+			completed_users INTEGER,
+			completion_rate REAL,
+			median_streak REAL,
+			computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS linked_users (
+			user_id INTEGER PRIMARY KEY,
+			partner_id INTEGER,
+			linked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES participants(user_id),
+			FOREIGN KEY (partner_id) REFERENCES participants(user_id)
+		);
 	`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+		return fmt.Errorf("failed to create tables: %w", err)
 	}
 
-	return db, nil
-}
-
-func getRandomCongratsMessage() string {
-	return CongratsMessages[rand.Intn(len(CongratsMessages))]
-}
-
-func (b *Bot) handleStart(message *tgbotapi.Message) error {
-	// Check if user is already a participant
-	var exists bool
-	err := b.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM participants 
-			WHERE user_id = ?
-		)
-	`, message.From.ID).Scan(&exists)
-	if err != nil {
-		return err
+	if err := migrateCompletionChatIDs(tx); err != nil {
+		return fmt.Errorf("failed to migrate completion chat IDs: %w", err)
 	}
 
-	if exists {
-		return b.sendParticipantsList(message.Chat.ID, message.From.ID)
+	if err := addColumnIfMissing(tx, "participants", "personal_goal", "INTEGER"); err != nil {
+		return fmt.Errorf("failed to add personal goal column: %w", err)
 	}
 
-	// ... existing keyboard and message code ...
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["join_challenge"], "join_challenge"),
-		),
-	)
+	if err := addColumnIfMissing(tx, "participants", "last_seen", "TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to add last seen column: %w", err)
+	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["want_to_join"])
-	msg.ReplyMarkup = keyboard
-	_, err = b.sendMessage(msg)
-	return err
-}
+	// left_at is set by /leave, the data is purged once the rejoin window is over
+	if err := addColumnIfMissing(tx, "participants", "left_at", "TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to add left_at column: %w", err)
+	}
 
-func (b *Bot) getParticipantsList() ([]struct {
-	Name      string
-	Completed bool
-	Streak    int
-}, error) {
-	today := time.Now().Format("2006-01-02")
-	rows, err := b.db.Query(`
-		SELECT 
-			COALESCE(p.display_name, p.username) as name,
-			CASE WHEN dc.completed_at IS NOT NULL THEN 1 ELSE 0 END as completed,
-			p.user_id
-		FROM participants p
-		LEFT JOIN daily_completions dc 
-			ON p.user_id = dc.user_id 
-			AND dc.completed_at = ?
-		ORDER BY p.joined_at DESC
-	`, today)
-	if err != nil {
-		return nil, err
+	// inactive_at is set when the user blocked the bot or deleted their account
+	if err := addColumnIfMissing(tx, "participants", "inactive_at", "TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to add inactive column: %w", err)
 	}
-	defer rows.Close()
 
-	var participants []struct {
-		Name      string
-		Completed bool
-		Streak    int
+	// tier is the difficulty picked for the completion in chats with tiers, NULL otherwise
+	if err := addColumnIfMissing(tx, "daily_completions", "tier", "TEXT"); err != nil {
+		return fmt.Errorf("failed to add completion tier column: %w", err)
 	}
-	for rows.Next() {
-		var p struct {
-			Name      string
-			Completed bool
-			Streak    int
-		}
-		var userID int64
-		if err := rows.Scan(&p.Name, &p.Completed, &userID); err != nil {
-			return nil, err
-		}
-		p.Streak, err = b.getIndividualStreak(userID)
-		if err != nil {
-			return nil, err
-		}
-		participants = append(participants, p)
+
+	// source tells who marked a completion: NULL for the user themselves, 'admin' for /markall
+	if err := addColumnIfMissing(tx, "daily_completions", "source", "TEXT"); err != nil {
+		return fmt.Errorf("failed to add completion source column: %w", err)
 	}
-	return participants, nil
-}
 
-func (b *Bot) getIndividualStreak(userID int64) (int, error) {
-	// Start from yesterday and go backwards to get the base streak
-	currentDate := time.Now().AddDate(0, 0, -1)
-	consecutiveDays := 0
+	return nil
+}
 
-	// Get base streak (not including today)
-	for {
-		dateStr := currentDate.Format("2006-01-02")
+// migrateGroupCelebrations adds the days on which a chat was told everyone completed
+func migrateGroupCelebrations(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS group_celebrations (
+			chat_id INTEGER,
+			date DATE,
+			celebrated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (chat_id, date)
+		)
+	`)
+	return err
+}
 
-		var completed bool
-		err := b.db.QueryRow(`
-			SELECT EXISTS(
-				SELECT 1 FROM daily_completions 
-				WHERE user_id = ? AND completed_at = ?
-			)
-		`, userID, dateStr).Scan(&completed)
+// migratePendingJoinEmptyReplies counts empty answers to the name prompt
+func migratePendingJoinEmptyReplies(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "pending_joins", "empty_replies", "INTEGER DEFAULT 0")
+}
 
+// migrateBackfillMilestones quietly grants the 7, 30 and 200 day milestones to participants
+// who already hold a longer one, dated like it, so their next completion doesn't announce
+// a burst of old milestones
+func migrateBackfillMilestones(tx *sql.Tx) error {
+	backfills := []struct{ earned, implied string }{
+		{"100_days", "7_days"},
+		{"100_days", "30_days"},
+		{"365_days", "200_days"},
+	}
+	for _, bf := range backfills {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO achievements (user_id, achievement_type, achieved_at)
+			SELECT user_id, ?, achieved_at FROM achievements WHERE achievement_type = ?
+		`, bf.implied, bf.earned)
 		if err != nil {
-			return 0, err
-		}
-
-		if !completed {
-			break
+			return err
 		}
-
-		consecutiveDays++
-		currentDate = currentDate.AddDate(0, 0, -1)
-	}
-
-	// Check if completed today
-	today := time.Now().Format("2006-01-02")
-	var completedToday bool
-	err := b.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM daily_completions 
-			WHERE user_id = ? AND completed_at = ?
-		)
-	`, userID, today).Scan(&completedToday)
-
-	if err != nil {
-		return 0, err
-	}
-
-	// Add today to streak if completed
-	if completedToday {
-		consecutiveDays++
 	}
+	return nil
+}
 
-	return consecutiveDays, nil
+// migrateCompletionChangeDate remembers which date the last completion change was for,
+// so the mark cooldown only applies to toggling that same date
+func migrateCompletionChangeDate(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "completion_changes", "completed_at", "DATE")
 }
 
-func (b *Bot) handleJoinChallenge(query *tgbotapi.CallbackQuery) error {
-	msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["enter_name"])
-	msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
-	_, err := b.sendMessage(msg)
+// migrateJoinPrompts moves the /start debounce out of pending_joins into start_offers,
+// so only users who pressed the join button have a pending join, and remembers the
+// name prompt's message_id to recognize replies to it
+func migrateJoinPrompts(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS start_offers (
+			user_id INTEGER PRIMARY KEY,
+			chat_id INTEGER,
+			shown_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
 	if err != nil {
 		return err
 	}
+	return addColumnIfMissing(tx, "pending_joins", "prompt_message_id", "INTEGER")
+}
 
-	// Store temporary state in DB to handle the name response
-	_, err = b.db.Exec(`
-		INSERT OR REPLACE INTO pending_joins (user_id, chat_id)
-		VALUES (?, ?)
-	`, query.From.ID, query.Message.Chat.ID)
+// migrateChatRecordsPerChat keys chat records by chat, so each chat has its own. The
+// existing record stays with the holder's chat, the other chats seed theirs from history.
+func migrateChatRecordsPerChat(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE chat_records_per_chat (
+			chat_id INTEGER,
+			record_type TEXT,
+			user_id INTEGER,
+			value INTEGER,
+			started_at DATE,
+			ended_at DATE,
+			achieved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (chat_id, record_type)
+		);
+		INSERT OR IGNORE INTO chat_records_per_chat (chat_id, record_type, user_id, value, started_at, ended_at, achieved_at)
+		SELECT p.chat_id, r.record_type, r.user_id, r.value, r.started_at, r.ended_at, r.achieved_at
+		FROM chat_records r
+		JOIN participants p ON p.user_id = r.user_id;
+		DROP TABLE chat_records;
+		ALTER TABLE chat_records_per_chat RENAME TO chat_records;
+	`)
 	return err
 }
 
-func (b *Bot) handleNameResponse(message *tgbotapi.Message) error {
-	userID := message.From.ID
-	chatID := message.Chat.ID
-	displayName := message.Text
-
-	// Insert participant with custom name
-	_, err := b.db.Exec(`
-		INSERT OR REPLACE INTO participants (user_id, username, chat_id, display_name)
-		VALUES (?, ?, ?, ?)
-	`, userID, message.From.UserName, chatID, displayName)
+// addColumnIfMissing adds a column to an existing table unless it's already there
+func addColumnIfMissing(q querier, table, column, definition string) error {
+	rows, err := q.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	// Remove from pending joins
-	_, err = b.db.Exec(`DELETE FROM pending_joins WHERE user_id = ?`, userID)
-	if err != nil {
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
 		return err
 	}
+	rows.Close()
 
-	return b.sendParticipantsList(chatID, userID)
+	_, err = q.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
 }
 
-func (b *Bot) sendParticipantsList(chatID int64, userID int64) error {
-	participants, err := b.getParticipantsList()
+// migrateCompletionChatIDs adds chat_id to daily_completions and fills it for legacy rows
+// from the participant's current chat. It only touches rows without a chat_id, so it is
+// safe to re-run, and rows of users who are no longer participants are left untouched
+// rather than guessed.
+func migrateCompletionChatIDs(q querier) error {
+	if err := addColumnIfMissing(q, "daily_completions", "chat_id", "INTEGER"); err != nil {
+		return err
+	}
+
+	res, err := q.Exec(`
+		UPDATE daily_completions
+		SET chat_id = (
+			SELECT p.chat_id FROM participants p 
+			WHERE p.user_id = daily_completions.user_id
+		)
+		WHERE chat_id IS NULL
+			AND user_id IN (SELECT user_id FROM participants WHERE chat_id IS NOT NULL)
+	`)
 	if err != nil {
 		return err
 	}
 
-	// Get weekday in Russian
-	currentWeekday := WeekdayNames[time.Now().Weekday().String()]
+	if updated, err := res.RowsAffected(); err == nil && updated > 0 {
+		slog.Info("backfilled chat_id on completions", "rows", updated)
+	}
+	return nil
+}
 
-	currentDate := time.Now().Format("02.01.2006")
-	response := fmt.Sprintf("%s, %s\n", currentWeekday, currentDate)
+// startDebounce is how long a repeated /start from a new user points to the join offer
+// already shown instead of sending another one, and a participant's only restores the
+// keyboard instead of sending the list again
+const startDebounce = time.Minute
 
-	response += "\n"
+const (
+	dataDir   = "./data"
+	dbPath    = dataDir + "/database.db"
+	backupDir = dataDir + "/backups"
+)
 
-	for _, p := range participants {
-		status := StatusIcons["pending"]
-		if p.Completed {
-			status = StatusIcons["completed"]
-		}
+// Congrats modes: a completion for today, a catch-up mark for yesterday, or for an earlier day
+const (
+	congratsModeToday     = "today"
+	congratsModeYesterday = "yesterday"
+	congratsModePastDay   = "pastday"
+)
 
-		response += fmt.Sprintf("- %s %s (%d %s)\n\n", status, p.Name, p.Streak, GetDayWord(p.Streak))
+// getRandomCongratsMessage picks a congrats from the pool for the given mode
+func getRandomCongratsMessage(mode string) string {
+	pool := CongratsMessages
+	switch mode {
+	case congratsModeYesterday:
+		pool = CatchUpCongratsMessages
+	case congratsModePastDay:
+		pool = PastDayCongratsMessages
 	}
+	return pool[rand.Intn(len(pool))]
+}
 
-	// Check if user completed today
-	today := time.Now().Format("2006-01-02")
-	var completed bool
-	err = b.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM daily_completions 
-			WHERE user_id = ? AND completed_at = ?
-		)
-	`, userID, today).Scan(&completed)
+// getChatSetting returns a per-chat setting, or def if it was never set
+func (b *Bot) getChatSetting(chatID int64, key, def string) (string, error) {
+	var value string
+	err := b.db.QueryRow(`SELECT value FROM chat_settings WHERE chat_id = ? AND key = ?`, chatID, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return def, nil
+	}
 	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// setChatSetting stores a per-chat setting, an empty value removes it
+func (b *Bot) setChatSetting(chatID int64, key, value string) error {
+	if value == "" {
+		_, err := b.db.Exec(`DELETE FROM chat_settings WHERE chat_id = ? AND key = ?`, chatID, key)
 		return err
 	}
 
-	// hidden for now
-	// Add streak information to the response
-	streak, err := b.getConsecutiveCompletionDays()
+	_, err := b.db.Exec(`
+		INSERT OR REPLACE INTO chat_settings (chat_id, key, value)
+		VALUES (?, ?, ?)
+	`, chatID, key, value)
+	return err
+}
+
+// getUserSetting returns a per-user setting, or def if it was never set
+func (b *Bot) getUserSetting(userID int64, key, def string) (string, error) {
+	var value string
+	err := b.db.QueryRow(`SELECT value FROM user_settings WHERE user_id = ? AND key = ?`, userID, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return def, nil
+	}
 	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// setUserSetting stores a per-user setting, an empty value removes it
+func (b *Bot) setUserSetting(userID int64, key, value string) error {
+	if value == "" {
+		_, err := b.db.Exec(`DELETE FROM user_settings WHERE user_id = ? AND key = ?`, userID, key)
 		return err
 	}
 
-	response += fmt.Sprintf("\n🔥 Совместных дней подряд: %d\n",
-		streak,
-	)
+	_, err := b.db.Exec(`
+		INSERT OR REPLACE INTO user_settings (user_id, key, value)
+		VALUES (?, ?, ?)
+	`, userID, key, value)
+	return err
+}
 
-	// Add Walk of Fame
-	fame, err := b.getWalkOfFame()
+// canManageChat reports whether the user may change the chat's settings: bot admins
+// anywhere, the user in their own private chat, and the chat's Telegram admins in groups
+func (b *Bot) canManageChat(chatID, userID int64) (bool, error) {
+	if b.isAdmin(userID) || isDM(chatID, userID) {
+		return true, nil
+	}
+
+	member, err := b.api.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
 
-	if len(fame) > 0 {
-		response += Messages["hall_of_fame_separator"] + "\n"
-		response += Messages["hall_of_fame"] + "\n\n"
+// requireChatManager checks canManageChat for the sender of a settings command and tells
+// them when they may not. It reports whether the command may go on.
+func (b *Bot) requireChatManager(message *tgbotapi.Message) (bool, error) {
+	ok, err := b.canManageChat(message.Chat.ID, message.From.ID)
+	if err != nil || ok {
+		return ok, err
+	}
 
-		// Then list 100 achievers who haven't reached 365 yet
-		has100 := false
-		response += Messages["achievement_100"] + "\n"
-		for _, f := range fame {
-			if f.Achievement100 && !f.Achievement365 {
-				has100 = true
-				achievedDate := f.AchievedAt100.Format("02.01.2006")
-				response += fmt.Sprintf("  • %s - %s (%s)\n", f.Name, Messages["achievement_reached"], achievedDate)
-			}
-		}
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["chat_managers_only"])
+	_, err = b.sendMessage(msg)
+	return false, err
+}
 
-		if !has100 {
-			response += Messages["no_achievements"] + "\n"
-		}
+// chatLang returns the language for messages sent to a chat
+func (b *Bot) chatLang(chatID int64) (string, error) {
+	return b.getChatSetting(chatID, "lang", DefaultLang)
+}
 
-		response += "\n"
+// dmChat returns the chat ID of the user's private chat with the bot.
+// Private chats share their ID with the user.
+func dmChat(userID int64) int64 {
+	return userID
+}
 
-		// First list 365 achievers
-		hasLegends := false
-		response += Messages["achievement_365"] + "\n"
-		for _, f := range fame {
-			if f.Achievement365 {
-				hasLegends = true
-				achievedDate := f.AchievedAt365.Format("02.01.2006")
-				response += fmt.Sprintf("  • %s - %s (%s)\n", f.Name, Messages["achievement_reached"], achievedDate)
-			}
+// isDM reports whether chatID is the user's private chat with the bot
+func isDM(chatID, userID int64) bool {
+	return chatID == dmChat(userID)
+}
+
+// resolveLang returns the effective language for a message to userID in chatID:
+// user override (private chats only) → chat setting → default
+func (b *Bot) resolveLang(userID, chatID int64) (string, error) {
+	if isDM(chatID, userID) {
+		lang, err := b.getUserSetting(userID, "lang", "")
+		if err != nil || lang != "" {
+			return lang, err
 		}
+	}
+	return b.chatLang(chatID)
+}
+
+// handleSetLang sets the chat language in groups, or the user's own language in private
+func (b *Bot) handleSetLang(message *tgbotapi.Message) error {
+	lang := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	chatID := message.Chat.ID
+
+	current, err := b.resolveLang(message.From.ID, chatID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := Translations[lang]; !ok {
+		msg := tgbotapi.NewMessage(chatID, t(current, "lang_usage"))
+		_, err := b.sendMessage(msg)
+		return err
+	}
 
-		if !hasLegends {
-			response += Messages["no_achievements"]
+	if !message.Chat.IsPrivate() {
+		if ok, err := b.requireChatManager(message); !ok {
+			return err
 		}
 	}
 
-	// Create a reply keyboard with options
-	replyKeyboard := tgbotapi.NewReplyKeyboard(
-		tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton(ButtonLabels["mark_yesterday"]),
-			tgbotapi.NewKeyboardButton(ButtonLabels["do_exercise"]),
-		),
-	)
-	replyKeyboard.ResizeKeyboard = true // Make keyboard smaller
-	replyKeyboard.Selective = true
+	key := "lang_chat_set"
+	if message.Chat.IsPrivate() {
+		err = b.setUserSetting(message.From.ID, "lang", lang)
+		key = "lang_user_set"
+	} else {
+		err = b.setChatSetting(chatID, "lang", lang)
+	}
+	if err != nil {
+		return err
+	}
 
-	msg := tgbotapi.NewMessage(chatID, response)
-	msg.ReplyMarkup = replyKeyboard
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(t(lang, key), lang))
 	_, err = b.sendMessage(msg)
 	return err
 }
 
-func (b *Bot) handleUpdateList(query *tgbotapi.CallbackQuery) error {
-	// Add callback acknowledgment
-	callback := tgbotapi.NewCallback(query.ID, "")
-	if _, err := b.api.Request(callback); err != nil {
-		return err
+// Deadline policies for completions made after the chat's daily deadline
+const (
+	deadlinePolicyReject  = "reject"
+	deadlinePolicyNextDay = "nextday"
+)
+
+// effectiveCompletionDate returns the date a completion made at now counts for.
+// After the chat's deadline it is either rejected (ok is false) or moved to the
+// next day, depending on the chat's deadline policy.
+func (b *Bot) effectiveCompletionDate(chatID int64, now time.Time) (date time.Time, ok bool, err error) {
+	deadline, err := b.getChatSetting(chatID, "deadline", "")
+	if err != nil || deadline == "" {
+		return now, err == nil, err
 	}
 
-	return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
-}
+	cutoff, err := time.Parse("15:04", deadline)
+	if err != nil {
+		b.logger.Warn("invalid deadline setting, ignoring", "chat_id", chatID, "deadline", deadline)
+		return now, true, nil
+	}
 
-func (b *Bot) handleCompleteChallenge(query *tgbotapi.CallbackQuery) error {
-	today := time.Now().Format("2006-01-02")
+	loc, err := b.chatLocation(chatID)
+	if err != nil {
+		return now, false, err
+	}
+	local := now.In(loc)
+	deadlineToday := time.Date(local.Year(), local.Month(), local.Day(), cutoff.Hour(), cutoff.Minute(), 0, 0, loc)
+	if local.Before(deadlineToday) {
+		return now, true, nil
+	}
 
-	// Check if already completed today
-	var completed bool
-	err := b.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM daily_completions 
-			WHERE user_id = ? AND completed_at = ?
-		)
-	`, query.From.ID, today).Scan(&completed)
+	policy, err := b.getChatSetting(chatID, "deadline_policy", deadlinePolicyReject)
 	if err != nil {
+		return now, false, err
+	}
+	if policy == deadlinePolicyNextDay {
+		return now.AddDate(0, 0, 1), true, nil
+	}
+	return now, false, nil
+}
+
+// handleSetDeadline sets the chat's daily deadline: "/setdeadline 20:00 [reject|nextday]" or "/setdeadline off"
+func (b *Bot) handleSetDeadline(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
 		return err
 	}
+	args := strings.Fields(message.CommandArguments())
+	chatID := message.Chat.ID
 
-	if completed {
-		// For reply keyboard, send a message instead of callback
-		msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["already_completed"])
-		_, err := b.api.Send(msg)
-		if err != nil {
+	if len(args) == 1 && args[0] == "off" {
+		if err := b.setChatSetting(chatID, "deadline", ""); err != nil {
 			return err
 		}
-
-		// Show current stats
-		return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
+		if err := b.setChatSetting(chatID, "deadline_policy", ""); err != nil {
+			return err
+		}
+		msg := tgbotapi.NewMessage(chatID, Messages["deadline_off"])
+		_, err := b.sendMessage(msg)
+		return err
 	}
 
-	congratsMessage := getRandomCongratsMessage()
+	policy := deadlinePolicyReject
+	if len(args) == 2 {
+		policy = args[1]
+	}
 
-	// Mark as completed with congrats message
-	_, err = b.db.Exec(`
-		INSERT INTO daily_completions (user_id, completed_at, congrats_message)
-		VALUES (?, ?, ?)
-	`, query.From.ID, today, congratsMessage)
-	if err != nil {
+	valid := len(args) == 1 || len(args) == 2
+	if valid {
+		_, err := time.Parse("15:04", args[0])
+		valid = err == nil && (policy == deadlinePolicyReject || policy == deadlinePolicyNextDay)
+	}
+	if !valid {
+		msg := tgbotapi.NewMessage(chatID, Messages["deadline_usage"])
+		_, err := b.sendMessage(msg)
 		return err
 	}
 
-	// Get current streak to check for achievements
-	streak, err := b.getIndividualStreak(query.From.ID)
-	if err != nil {
+	if err := b.setChatSetting(chatID, "deadline", args[0]); err != nil {
 		return err
 	}
-
-	// Check and record achievements if applicable
-	if err := b.checkAndRecordAchievements(query.From.ID, streak); err != nil {
+	if err := b.setChatSetting(chatID, "deadline_policy", policy); err != nil {
 		return err
 	}
 
-	// Send congrats message
-	msg := tgbotapi.NewMessage(query.Message.Chat.ID, congratsMessage)
-	_, err = b.sendMessage(msg)
+	text := fmt.Sprintf(Messages["deadline_set_reject"], args[0])
+	if policy == deadlinePolicyNextDay {
+		text = fmt.Sprintf(Messages["deadline_set_nextday"], args[0])
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// markCooldownLeft returns how long the user must wait before changing their completion
+// for date (YYYY-MM-DD) again. Only toggling the date of the last change is limited, so
+// marking yesterday and then today, or catching up on several days, isn't slowed down.
+// Zero means the action is allowed.
+func (b *Bot) markCooldownLeft(userID int64, date string) (time.Duration, error) {
+	if b.markCooldown <= 0 {
+		return 0, nil
+	}
+
+	var changedAt int64
+	err := b.db.QueryRow(`
+		SELECT changed_at FROM completion_changes
+		WHERE user_id = ? AND completed_at = ?
+	`, userID, date).Scan(&changedAt)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// Show updated list
-	return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
+	elapsed := time.Since(time.Unix(changedAt, 0))
+	if elapsed >= b.markCooldown {
+		return 0, nil
+	}
+	return b.markCooldown - elapsed, nil
 }
 
-func (b *Bot) handleMarkYesterday(message *tgbotapi.Message) error {
-	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-	userID := message.From.ID
-	chatID := message.Chat.ID
+// recordCompletionChange remembers when and for which date the user last marked or
+// unmarked a completion
+func (b *Bot) recordCompletionChange(userID int64, date string) error {
+	_, err := b.db.Exec(`
+		INSERT OR REPLACE INTO completion_changes (user_id, changed_at, completed_at)
+		VALUES (?, ?, ?)
+	`, userID, time.Now().Unix(), date)
+	return err
+}
 
-	// Check if already completed yesterday
-	var completed bool
+// sendCooldownMessage tells the user to wait before the next completion change
+func (b *Bot) sendCooldownMessage(chatID int64, left time.Duration) error {
+	seconds := int(left.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["mark_cooldown"], seconds))
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+func (b *Bot) handleStart(message *tgbotapi.Message) error {
+	// Check if user is already a participant
+	var exists bool
 	err := b.db.QueryRow(`
 		SELECT EXISTS(
-			SELECT 1 FROM daily_completions 
-			WHERE user_id = ? AND completed_at = ?
+			SELECT 1 FROM participants 
+			WHERE user_id = ?
 		)
-	`, userID, yesterday).Scan(&completed)
+	`, message.From.ID).Scan(&exists)
 	if err != nil {
-		b.logger.Error("db error checking yesterday's completion", "error", err, "user_id", userID)
-		errMsg := tgbotapi.NewMessage(chatID, Messages["error_try_later"])
-		b.sendMessage(errMsg)
 		return err
 	}
 
-	if completed {
-		msg := tgbotapi.NewMessage(chatID, Messages["already_completed_yesterday"])
-		_, errSend := b.sendMessage(msg)
-		if errSend != nil {
-			b.logger.Error("failed to send 'already_completed_yesterday' message", "error", errSend, "user_id", userID)
+	if exists {
+		left, err := b.leftAt(message.From.ID)
+		if err != nil {
+			return err
+		}
+		if !left.IsZero() {
+			msg := tgbotapi.NewMessage(message.Chat.ID, Messages["leave_rejoin_hint"])
+			_, err := b.sendMessage(msg)
+			return err
 		}
 
-		// Show current stats
-		return b.sendParticipantsList(chatID, userID)
-	}
+		if b.startListRecentlySent(message.From.ID, message.Chat.ID) {
+			b.logger.Info("list already sent on /start, restoring the keyboard only", "user_id", message.From.ID)
+			return b.sendStartKeyboard(message.Chat.ID, message.From.ID)
+		}
 
-	congratsMessage := getRandomCongratsMessage()
+		summary, err := b.sinceLastVisit(message.From.ID)
+		if err != nil {
+			return err
+		}
+		if summary != "" {
+			msg := tgbotapi.NewMessage(message.Chat.ID, summary)
+			if _, err := b.sendMessage(msg); err != nil {
+				return err
+			}
+		}
+		return b.sendParticipantsList(message.Chat.ID, message.From.ID)
+	}
 
-	// Mark yesterday as completed
-	_, err = b.db.Exec(`
-		INSERT INTO daily_completions (user_id, completed_at, congrats_message)
-		VALUES (?, ?, ?)
-	`, userID, yesterday, congratsMessage)
+	// Remember that the join offer was shown so a double /start doesn't send it twice.
+	// A stale offer (older than the debounce window) is refreshed and shown again.
+	res, err := b.db.Exec(`
+		INSERT INTO start_offers (user_id, chat_id)
+		VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			chat_id = excluded.chat_id,
+			shown_at = CURRENT_TIMESTAMP
+		WHERE start_offers.shown_at <= datetime('now', ?)
+	`, message.From.ID, message.Chat.ID, fmt.Sprintf("-%d seconds", int(startDebounce.Seconds())))
 	if err != nil {
-		b.logger.Error("db error inserting yesterday's completion", "error", err, "user_id", userID)
-		errMsg := tgbotapi.NewMessage(chatID, Messages["error_marking_yesterday"])
-		b.sendMessage(errMsg)
 		return err
 	}
 
-	// Get current streak to check for achievements
-	streak, err := b.getIndividualStreak(userID)
+	affected, err := res.RowsAffected()
 	if err != nil {
-		b.logger.Error("failed to get individual streak after marking yesterday", "error", err, "user_id", userID)
-	} else {
-		if errAch := b.checkAndRecordAchievements(userID, streak); errAch != nil {
-			b.logger.Error("failed to check/record achievements after marking yesterday", "error", errAch, "user_id", userID)
-		}
+		return err
+	}
+	if affected == 0 {
+		b.logger.Info("join offer already shown, pointing to it", "user_id", message.From.ID)
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["join_offer_shown"])
+		_, err := b.sendMessage(msg)
+		return err
 	}
 
-	successMsg := tgbotapi.NewMessage(chatID, Messages["yesterday_marked_success"])
-	_, errSend := b.sendMessage(successMsg)
-	if errSend != nil {
-		b.logger.Error("failed to send 'yesterday_marked_success' message", "error", errSend, "user_id", userID)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["join_challenge"], "join_challenge"),
+		),
+	)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["want_to_join"])
+	msg.ReplyMarkup = keyboard
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// startListKey is a participant's /start in a chat
+type startListKey struct {
+	userID, chatID int64
+}
+
+// startListRecentlySent reports whether /start sent the user the list in the chat within
+// startDebounce. If it didn't, this /start is remembered as the one that does.
+func (b *Bot) startListRecentlySent(userID, chatID int64) bool {
+	b.recentStartListsMu.Lock()
+	defer b.recentStartListsMu.Unlock()
+
+	now := time.Now()
+	for key, sentAt := range b.recentStartLists {
+		if now.Sub(sentAt) >= startDebounce {
+			delete(b.recentStartLists, key)
+		}
 	}
 
-	return b.sendParticipantsList(chatID, userID)
+	key := startListKey{userID, chatID}
+	if _, ok := b.recentStartLists[key]; ok {
+		return true
+	}
+	b.recentStartLists[key] = now
+	return false
 }
 
-// handleBackfillToToday inserts missing completion days for every participant up to today.
-// It preserves existing marks and only fills gaps between the participant's last completion
-// date and today. Uses a fixed congrats message for backfilled days to avoid noisy random texts.
-func (b *Bot) handleBackfillToToday(message *tgbotapi.Message) error {
-	today := time.Now().Format("2006-01-02")
+// sendStartKeyboard restores the main keyboard of a participant who just got the list
+func (b *Bot) sendStartKeyboard(chatID, userID int64) error {
+	var name string
+	err := b.db.QueryRow(`SELECT COALESCE(display_name, username, '') FROM participants WHERE user_id = ?`, userID).Scan(&name)
+	if err != nil {
+		return err
+	}
 
-	// Collect participants
-	rows, err := b.db.Query(`SELECT user_id FROM participants`)
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	completedToday, err := b.completedOn(userID, now.Format("2006-01-02"))
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	var participantIDs []int64
-	for rows.Next() {
-		var userID int64
-		if err := rows.Scan(&userID); err != nil {
-			return err
-		}
-		participantIDs = append(participantIDs, userID)
+	_, err = b.sendMessage(keyboardRefreshMessage(chatID, userID, name, completedToday))
+	return err
+}
+
+// touchLastSeen remembers when the participant last interacted with the bot.
+// A participant who was marked inactive is evidently back, so they're reactivated,
+// unless they left on purpose: that takes /rejoin.
+func (b *Bot) touchLastSeen(userID int64) error {
+	_, err := b.db.Exec(`UPDATE participants SET last_seen = CURRENT_TIMESTAMP WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
 	}
 
-	if err := rows.Err(); err != nil {
+	res, err := b.db.Exec(`
+		UPDATE participants SET inactive_at = NULL 
+		WHERE user_id = ? AND inactive_at IS NOT NULL AND left_at IS NULL
+	`, userID)
+	if err != nil {
 		return err
 	}
+	if reactivated, err := res.RowsAffected(); err == nil && reactivated > 0 {
+		b.logger.Info("reactivated participant", "user_id", userID)
+		b.invalidateUserParticipantsCache(userID)
+	}
+	return nil
+}
 
-	if len(participantIDs) == 0 {
-		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["backfill_none"]))
-		_, _ = b.sendMessage(msg)
-		return nil
+// sinceLastVisit summarizes what changed in the group since the participant's last
+// interaction: new members, new achievements and how the group streak moved. The group
+// streak is compared with the one shown in the previous summary. Empty if nothing changed
+// or the participant has never been seen.
+func (b *Bot) sinceLastVisit(userID int64) (string, error) {
+	var lastSeen sql.NullString
+	var chatID int64
+	err := b.db.QueryRow(`SELECT CAST(last_seen AS TEXT), chat_id FROM participants WHERE user_id = ?`, userID).Scan(&lastSeen, &chatID)
+	if err != nil || !lastSeen.Valid {
+		return "", err
 	}
 
-	// Backfill per participant
-	totalInserted := 0
-	fixedCongrats := "Бэкаповая отметка ✅"
+	var lines []string
 
-	for _, userID := range participantIDs {
-		var lastDate sql.NullString
-		// Find the most recent completion date for the user
-		err := b.db.QueryRow(`
+	rows, err := b.db.Query(`
+		SELECT COALESCE(display_name, username) FROM participants 
+		WHERE joined_at > ? AND user_id != ?
+		ORDER BY joined_at
+	`, lastSeen.String, userID)
+	if err != nil {
+		return "", err
+	}
+	var members []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return "", err
+		}
+		members = append(members, name)
+	}
+	rows.Close()
+	if len(members) > 0 {
+		lines = append(lines, fmt.Sprintf(Messages["since_new_members"], strings.Join(members, ", ")))
+	}
+
+	rows, err = b.db.Query(`
+		SELECT COALESCE(p.display_name, p.username), a.achievement_type 
+		FROM achievements a
+		JOIN participants p ON p.user_id = a.user_id
+		WHERE a.achieved_at >= date(?)
+		ORDER BY a.achieved_at
+	`, lastSeen.String)
+	if err != nil {
+		return "", err
+	}
+	var achievements []string
+	for rows.Next() {
+		var name, achievementType string
+		if err := rows.Scan(&name, &achievementType); err != nil {
+			rows.Close()
+			return "", err
+		}
+		achievements = append(achievements, fmt.Sprintf("%s (%s)", name, achievementType))
+	}
+	rows.Close()
+	if len(achievements) > 0 {
+		lines = append(lines, fmt.Sprintf(Messages["since_new_achievements"], strings.Join(achievements, ", ")))
+	}
+
+	streak, err := b.getConsecutiveCompletionDays(chatID)
+	if err != nil {
+		return "", err
+	}
+	previous, err := b.getUserSetting(userID, "last_group_streak", "")
+	if err != nil {
+		return "", err
+	}
+	if previousStreak, err := strconv.Atoi(previous); err == nil && previousStreak != streak {
+		lines = append(lines, fmt.Sprintf(Messages["since_group_streak"], previousStreak, streak))
+	}
+	if err := b.setUserSetting(userID, "last_group_streak", strconv.Itoa(streak)); err != nil {
+		return "", err
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return Messages["since_header"] + "\n\n" + strings.Join(lines, "\n"), nil
+}
+
+// isBotAdded reports whether the bot itself is among the new members of a chat
+func isBotAdded(message *tgbotapi.Message, botID int64) bool {
+	for _, member := range message.NewChatMembers {
+		if member.ID == botID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleNewChatMembers welcomes a group the bot was just added to, so the organizer
+// doesn't have to know about /start. Ordinary members joining are left alone.
+func (b *Bot) handleNewChatMembers(message *tgbotapi.Message) error {
+	if !isBotAdded(message, b.api.Self.ID) {
+		return nil
+	}
+
+	b.logger.Info("bot added to chat", "chat_id", message.Chat.ID, "added_by", message.From.ID)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["join_challenge"], "join_challenge"),
+		),
+	)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["bot_added"]+"\n\n"+Messages["want_to_join"])
+	msg.ReplyMarkup = keyboard
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// participantRow is a participant as shown on the scoreboard
+type participantRow struct {
+	Name      string
+	Completed bool
+	Skipped   bool
+	Frozen    bool
+	Streak    int
+	Best      int
+	Total     int
+	Grid      string
+	Tier      string
+}
+
+// getParticipantsList returns the chat's active participants with their status on the chat's today
+func (b *Bot) getParticipantsList(chatID int64) ([]participantRow, error) {
+	now, err := b.todayIn(chatID)
+	if err != nil {
+		return nil, err
+	}
+	today := now.Format("2006-01-02")
+
+	rows, err := b.db.Query(`
+		SELECT 
+			COALESCE(p.display_name, p.username) as name,
+			CASE WHEN dc.completed_at IS NOT NULL THEN 1 ELSE 0 END as completed,
+			EXISTS(SELECT 1 FROM skipped_days s WHERE s.user_id = p.user_id AND s.skipped_on = ?) as skipped,
+			EXISTS(SELECT 1 FROM streak_freezes f WHERE f.user_id = p.user_id AND f.frozen_on = ?) as frozen,
+			COALESCE(dc.tier, '') as tier,
+			p.user_id
+		FROM participants p
+		LEFT JOIN daily_completions dc 
+			ON p.user_id = dc.user_id 
+			AND dc.completed_at = ?
+		WHERE p.inactive_at IS NULL AND p.chat_id = ?
+		ORDER BY p.joined_at DESC
+	`, today, today, today, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []participantRow
+	for rows.Next() {
+		var p participantRow
+		var userID int64
+		if err := rows.Scan(&p.Name, &p.Completed, &p.Skipped, &p.Frozen, &p.Tier, &userID); err != nil {
+			return nil, err
+		}
+		p.Streak, err = b.getIndividualStreak(userID)
+		if err != nil {
+			return nil, err
+		}
+		best, err := b.getLongestStreak(userID)
+		if err != nil {
+			return nil, err
+		}
+		// Vacations and the rolling mode can make the current streak longer than any plain run
+		p.Best = max(best.Length, p.Streak)
+		p.Total, err = b.getTotalCompletions(userID)
+		if err != nil {
+			return nil, err
+		}
+		days, joinedAt, err := b.getLast7Days(userID)
+		if err != nil {
+			return nil, err
+		}
+		p.Grid = weekGrid(days, joinedAt, now)
+		participants = append(participants, p)
+	}
+	return participants, nil
+}
+
+// getTotalCompletions returns how many days the user has completed over all time.
+// Undone completions are deleted, so they don't count.
+func (b *Bot) getTotalCompletions(userID int64) (int, error) {
+	var total int
+	err := b.db.QueryRow(`SELECT COUNT(*) FROM daily_completions WHERE user_id = ?`, userID).Scan(&total)
+	return total, err
+}
+
+// participantsCacheTTL is how long a built participants list is served without a DB query
+const participantsCacheTTL = 30 * time.Second
+
+// participantsCacheEntry is a chat's participants list built at some point in time
+type participantsCacheEntry struct {
+	participants []participantRow
+	builtAt      time.Time
+}
+
+// cachedParticipantsList returns the chat's participants list for the scoreboard, reusing
+// the last one built within participantsCacheTTL.
+func (b *Bot) cachedParticipantsList(chatID int64) ([]participantRow, error) {
+	b.participantsCacheMu.RLock()
+	entry := b.participantsCache[chatID]
+	gen := b.participantsCacheGen[chatID]
+	b.participantsCacheMu.RUnlock()
+
+	now, err := b.todayIn(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil && now.Sub(entry.builtAt) < participantsCacheTTL && entry.builtAt.Format("2006-01-02") == now.Format("2006-01-02") {
+		return entry.participants, nil
+	}
+
+	participants, err := b.getParticipantsList(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.participantsCacheMu.Lock()
+	if b.participantsCacheGen[chatID] == gen {
+		b.participantsCache[chatID] = &participantsCacheEntry{participants: participants, builtAt: now}
+	}
+	b.participantsCacheMu.Unlock()
+	return participants, nil
+}
+
+// invalidateParticipantsCache drops the chat's cached participants list after completions,
+// joins and other changes to its streaks
+func (b *Bot) invalidateParticipantsCache(chatID int64) {
+	b.participantsCacheMu.Lock()
+	delete(b.participantsCache, chatID)
+	b.participantsCacheGen[chatID]++
+	b.participantsCacheMu.Unlock()
+}
+
+// invalidateUserParticipantsCache drops the cached list of the chat the user takes part from
+func (b *Bot) invalidateUserParticipantsCache(userID int64) {
+	chatID, err := b.participantChat(userID)
+	if err != nil {
+		b.logger.Error("failed to find participant chat", "user_id", userID, "error", err)
+		return
+	}
+	b.invalidateParticipantsCache(chatID)
+}
+
+// callbackDedupTTL is how long a handled callback query is remembered to drop repeats
+const callbackDedupTTL = 5 * time.Second
+
+// duplicateCallback reports whether the callback query repeats one handled within
+// callbackDedupTTL, and remembers it otherwise. A redelivered query has the same ID,
+// a double tap gets a new ID but the same user, message and data, so both are checked.
+func (b *Bot) duplicateCallback(query *tgbotapi.CallbackQuery) bool {
+	keys := []string{"id:" + query.ID}
+	if query.Message != nil {
+		keys = append(keys, fmt.Sprintf("tap:%d:%d:%d:%s", query.From.ID, query.Message.Chat.ID, query.Message.MessageID, query.Data))
+	}
+
+	b.recentCallbacksMu.Lock()
+	defer b.recentCallbacksMu.Unlock()
+
+	now := time.Now()
+	for key, handledAt := range b.recentCallbacks {
+		if now.Sub(handledAt) >= callbackDedupTTL {
+			delete(b.recentCallbacks, key)
+		}
+	}
+
+	for _, key := range keys {
+		if _, ok := b.recentCallbacks[key]; ok {
+			return true
+		}
+	}
+	for _, key := range keys {
+		b.recentCallbacks[key] = now
+	}
+	return false
+}
+
+// Streak modes: strict counts consecutive days, rolling keeps the streak alive
+// as long as every 7-day window has at least N completions
+const (
+	streakModeStrict  = "strict"
+	streakModeRolling = "rolling"
+)
+
+// streakMode returns the streak mode of the user's chat and, for the rolling mode,
+// the minimum completions per 7 days
+func (b *Bot) streakMode(userID int64) (mode string, minPerWeek int, err error) {
+	var chatID int64
+	err = b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return streakModeStrict, 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return b.chatStreakMode(chatID)
+}
+
+// chatStreakMode returns the chat's streak mode and rolling minimum
+func (b *Bot) chatStreakMode(chatID int64) (mode string, minPerWeek int, err error) {
+	mode, err = b.getChatSetting(chatID, "streak_mode", streakModeStrict)
+	if err != nil || mode != streakModeRolling {
+		return streakModeStrict, 0, err
+	}
+
+	value, err := b.getChatSetting(chatID, "streak_rolling_min", "5")
+	if err != nil {
+		return "", 0, err
+	}
+	minPerWeek, err = strconv.Atoi(value)
+	if err != nil || minPerWeek < 1 || minPerWeek > 7 {
+		minPerWeek = 5
+	}
+	return streakModeRolling, minPerWeek, nil
+}
+
+// rollingStreak counts consecutive days back from today whose 7-day window
+// (the day and the six before it) has at least minPerWeek completions.
+// Like the strict streak, a today that doesn't qualify yet doesn't break it.
+// The walk stops at the first completion: days before it aren't part of any streak.
+func rollingStreak(completed map[string]bool, today time.Time, minPerWeek int) int {
+	if len(completed) == 0 {
+		return 0
+	}
+	first := ""
+	for date := range completed {
+		if first == "" || date < first {
+			first = date
+		}
+	}
+
+	windowOK := func(day time.Time) bool {
+		if day.Format("2006-01-02") < first {
+			return false
+		}
+		count := 0
+		for i := 0; i < 7; i++ {
+			if completed[day.AddDate(0, 0, -i).Format("2006-01-02")] {
+				count++
+			}
+		}
+		return count >= minPerWeek
+	}
+
+	streak := 0
+	if windowOK(today) {
+		streak++
+	}
+	for day := today.AddDate(0, 0, -1); windowOK(day); day = day.AddDate(0, 0, -1) {
+		streak++
+	}
+	return streak
+}
+
+// getRollingStreak returns the user's streak in the rolling "best N of 7" mode
+func (b *Bot) getRollingStreak(q querier, userID int64, minPerWeek int) (int, error) {
+	rows, err := q.Query(`SELECT CAST(completed_at AS TEXT) FROM daily_completions WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	completed := make(map[string]bool)
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return 0, err
+		}
+		completed[date] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	// Rest days count as done so they don't break the rolling window
+	rest, err := b.restDays(userID)
+	if err != nil {
+		return 0, err
+	}
+	for date := range rest {
+		completed[date] = true
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return 0, err
+	}
+	return rollingStreak(completed, now, minPerWeek), nil
+}
+
+// handleStreakMode sets how streaks are counted: "/streakmode strict" or "/streakmode rolling 5"
+func (b *Bot) handleStreakMode(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	args := strings.Fields(message.CommandArguments())
+
+	var text string
+	switch {
+	case len(args) == 1 && args[0] == streakModeStrict:
+		if err := b.setChatSetting(chatID, "streak_mode", ""); err != nil {
+			return err
+		}
+		if err := b.setChatSetting(chatID, "streak_rolling_min", ""); err != nil {
+			return err
+		}
+		text = Messages["streak_mode_strict"]
+	case len(args) == 2 && args[0] == streakModeRolling:
+		minPerWeek, err := strconv.Atoi(args[1])
+		if err != nil || minPerWeek < 1 || minPerWeek > 7 {
+			text = Messages["streak_mode_usage"]
+			break
+		}
+		if err := b.setChatSetting(chatID, "streak_mode", streakModeRolling); err != nil {
+			return err
+		}
+		if err := b.setChatSetting(chatID, "streak_rolling_min", args[1]); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["streak_mode_rolling"], minPerWeek)
+	default:
+		text = Messages["streak_mode_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// vacationDays returns the days the user declared as vacation. An ongoing vacation lasts until today.
+func (b *Bot) vacationDays(userID int64) (map[string]bool, error) {
+	now, err := b.userNow(userID)
+	if err != nil {
+		return nil, err
+	}
+	today := now.Format("2006-01-02")
+
+	rows, err := b.db.Query(`
+		SELECT CAST(started_at AS TEXT), COALESCE(CAST(ended_at AS TEXT), '')
+		FROM vacations WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make(map[string]bool)
+	for rows.Next() {
+		var startedAt, endedAt string
+		if err := rows.Scan(&startedAt, &endedAt); err != nil {
+			return nil, err
+		}
+		if endedAt == "" {
+			endedAt = today
+		}
+
+		start, err := time.Parse("2006-01-02", startedAt)
+		if err != nil {
+			return nil, err
+		}
+		for d := start; d.Format("2006-01-02") <= endedAt; d = d.AddDate(0, 0, 1) {
+			days[d.Format("2006-01-02")] = true
+		}
+	}
+	return days, rows.Err()
+}
+
+// Skip modes: whether a day marked with /skiptoday breaks the streak like a silent miss
+const (
+	skipModeBreak = "break"
+	skipModeKeep  = "keep"
+)
+
+// restDays returns the days that don't break the user's streak: vacation days and,
+// if the user's chat allows it, days skipped with /skiptoday
+func (b *Bot) restDays(userID int64) (map[string]bool, error) {
+	days, err := b.vacationDays(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Frozen days keep the streak whatever the skip mode
+	frozen, err := b.db.Query(`SELECT CAST(frozen_on AS TEXT) FROM streak_freezes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for frozen.Next() {
+		var date string
+		if err := frozen.Scan(&date); err != nil {
+			frozen.Close()
+			return nil, err
+		}
+		days[date] = true
+	}
+	frozen.Close()
+	if err := frozen.Err(); err != nil {
+		return nil, err
+	}
+
+	var chatID int64
+	err = b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return days, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := b.getChatSetting(chatID, "skip_mode", skipModeBreak)
+	if err != nil || mode != skipModeKeep {
+		return days, err
+	}
+
+	rows, err := b.db.Query(`SELECT CAST(skipped_on AS TEXT) FROM skipped_days WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, err
+		}
+		days[date] = true
+	}
+	return days, rows.Err()
+}
+
+// handleSkipToday records an intentional rest day: no reminders today and a 😴 in the list.
+// A skip is not a completion; whether it breaks the streak depends on the chat's skip mode.
+func (b *Bot) handleSkipToday(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	var isParticipant bool
+	err := b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, userID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	today := now.Format("2006-01-02")
+
+	var completed bool
+	err = b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM daily_completions 
+			WHERE user_id = ? AND completed_at = ?
+		)
+	`, userID, today).Scan(&completed)
+	if err != nil {
+		return err
+	}
+	if completed {
+		msg := tgbotapi.NewMessage(chatID, Messages["skip_already_completed"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	_, err = b.db.Exec(`INSERT OR IGNORE INTO skipped_days (user_id, skipped_on) VALUES (?, ?)`, userID, today)
+	if err != nil {
+		return err
+	}
+	b.invalidateUserParticipantsCache(userID)
+
+	mode, err := b.getChatSetting(chatID, "skip_mode", skipModeBreak)
+	if err != nil {
+		return err
+	}
+	text := Messages["skip_today_break"]
+	if mode == skipModeKeep {
+		text = Messages["skip_today_keep"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// maxFreezesPerMonth is how many days a participant can freeze in a calendar month
+const maxFreezesPerMonth = 2
+
+// freezeWindowDays is how far back or ahead of today a day can be frozen
+const freezeWindowDays = 30
+
+// handleToday shows the congrats the user got for a day: "/today" for today or
+// "/today 25.12.2024" for another day
+func (b *Bot) handleToday(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+
+	now, err := b.userNow(message.From.ID)
+	if err != nil {
+		return err
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		day, err = time.ParseInLocation("02.01.2006", arg, now.Location())
+		if err != nil {
+			msg := tgbotapi.NewMessage(chatID, Messages["today_usage"])
+			_, err = b.sendMessage(msg)
+			return err
+		}
+	}
+	shown := day.Format("02.01.2006")
+
+	var congrats sql.NullString
+	err = b.db.QueryRow(`
+		SELECT congrats_message FROM daily_completions 
+		WHERE user_id = ? AND completed_at = ?
+	`, message.From.ID, day.Format("2006-01-02")).Scan(&congrats)
+
+	var text string
+	switch {
+	case err == sql.ErrNoRows:
+		text = fmt.Sprintf(Messages["today_none"], shown)
+	case err != nil:
+		return err
+	case congrats.String == "":
+		text = fmt.Sprintf(Messages["today_no_message"], shown)
+	default:
+		text = fmt.Sprintf(Messages["today_congrats"], shown, congrats.String)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleFreeze protects a day from breaking the streak: "/freeze" for today or
+// "/freeze 25.12.2024". A frozen day keeps the streak going but doesn't add to it,
+// and shows as ❄️ in the list.
+func (b *Bot) handleFreeze(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	lang, err := b.resolveLang(userID, chatID)
+	if err != nil {
+		return err
+	}
+
+	var isParticipant bool
+	err = b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, userID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		msg := tgbotapi.NewMessage(chatID, t(lang, "not_participant"))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := today
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		day, err = time.ParseInLocation("02.01.2006", arg, now.Location())
+		if err != nil || day.Before(today.AddDate(0, 0, -freezeWindowDays)) || day.After(today.AddDate(0, 0, freezeWindowDays)) {
+			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(t(lang, "freeze_usage"), freezeWindowDays))
+			_, err = b.sendMessage(msg)
+			return err
+		}
+	}
+	date := day.Format("2006-01-02")
+
+	completed, err := b.completedOn(userID, date)
+	if err != nil {
+		return err
+	}
+	if completed {
+		msg := tgbotapi.NewMessage(chatID, t(lang, "freeze_already_completed"))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	monthStart := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, now.Location())
+	var used int
+	err = b.db.QueryRow(`
+		SELECT COUNT(*) FROM streak_freezes 
+		WHERE user_id = ? AND frozen_on >= ? AND frozen_on < ?
+	`, userID, monthStart.Format("2006-01-02"), monthStart.AddDate(0, 1, 0).Format("2006-01-02")).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used >= maxFreezesPerMonth {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(t(lang, "freeze_limit"), maxFreezesPerMonth, pluralize(maxFreezesPerMonth, "freeze", lang)))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	res, err := b.db.Exec(`INSERT OR IGNORE INTO streak_freezes (user_id, frozen_on) VALUES (?, ?)`, userID, date)
+	if err != nil {
+		return err
+	}
+	if added, err := res.RowsAffected(); err != nil {
+		return err
+	} else if added == 0 {
+		msg := tgbotapi.NewMessage(chatID, t(lang, "freeze_already"))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	b.invalidateUserParticipantsCache(userID)
+	b.logger.Info("day frozen", "user_id", userID, "date", date)
+
+	left := maxFreezesPerMonth - used - 1
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(t(lang, "freeze_done"), day.Format("02.01.2006"), left, pluralize(left, "freeze", lang)))
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// handleSkipMode sets whether skipped days break streaks: "/skipmode break|keep"
+func (b *Bot) handleSkipMode(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	mode := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+
+	var text string
+	switch mode {
+	case skipModeBreak:
+		if err := b.setChatSetting(chatID, "skip_mode", ""); err != nil {
+			return err
+		}
+		text = Messages["skip_mode_break"]
+	case skipModeKeep:
+		if err := b.setChatSetting(chatID, "skip_mode", mode); err != nil {
+			return err
+		}
+		text = Messages["skip_mode_keep"]
+	default:
+		text = Messages["skip_mode_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// completedOn reports whether the user has a completion for the date
+func (b *Bot) completedOn(userID int64, date string) (bool, error) {
+	var completed bool
+	err := b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM daily_completions 
+			WHERE user_id = ? AND completed_at = ?
+		)
+	`, userID, date).Scan(&completed)
+	return completed, err
+}
+
+// isOnVacation reports whether the user has an ongoing vacation
+func (b *Bot) isOnVacation(userID int64) (bool, error) {
+	var onVacation bool
+	err := b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM vacations 
+			WHERE user_id = ? AND ended_at IS NULL
+		)
+	`, userID).Scan(&onVacation)
+	return onVacation, err
+}
+
+// handleVacation pauses or resumes the user's own participation: "/vacation on|off".
+// Vacation days neither count towards nor break the streak, and reminders skip the user.
+func (b *Bot) handleVacation(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+
+	var isParticipant bool
+	err := b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, userID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	onVacation, err := b.isOnVacation(userID)
+	if err != nil {
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	today := now.Format("2006-01-02")
+
+	var text string
+	switch {
+	case arg == "on" && onVacation:
+		text = Messages["vacation_already_on"]
+	case arg == "on":
+		_, err := b.db.Exec(`
+			INSERT OR REPLACE INTO vacations (user_id, started_at)
+			VALUES (?, ?)
+		`, userID, today)
+		if err != nil {
+			return err
+		}
+		b.invalidateUserParticipantsCache(userID)
+		text = Messages["vacation_on"]
+	case arg == "off" && !onVacation:
+		text = Messages["vacation_already_off"]
+	case arg == "off":
+		_, err := b.db.Exec(`UPDATE vacations SET ended_at = ? WHERE user_id = ? AND ended_at IS NULL`, today, userID)
+		if err != nil {
+			return err
+		}
+		b.invalidateUserParticipantsCache(userID)
+		text = Messages["vacation_off"]
+	default:
+		text = Messages["vacation_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// streakDisplay holds a chat's options for showing streaks in lists. Zero means unset.
+type streakDisplay struct {
+	// Cap is the longest streak shown as is, longer ones are shown as "Cap+"
+	Cap int
+	// Min is the shortest streak shown at all, shorter ones are hidden
+	Min int
+	// Titles adds a streak title like "Боец" next to names
+	Titles bool
+}
+
+// positiveIntSetting returns a positive integer chat setting, or 0 if unset or invalid
+func (b *Bot) positiveIntSetting(chatID int64, key string) (int, error) {
+	value, err := b.getChatSetting(chatID, key, "")
+	if err != nil || value == "" {
+		return 0, err
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		b.logger.Warn("invalid chat setting, ignoring", "chat_id", chatID, "key", key, "value", value)
+		return 0, nil
+	}
+	return parsed, nil
+}
+
+// getStreakDisplay returns how streaks are shown in the chat's lists
+func (b *Bot) getStreakDisplay(chatID int64) (streakDisplay, error) {
+	streakCap, err := b.positiveIntSetting(chatID, "display_streak_cap")
+	if err != nil {
+		return streakDisplay{}, err
+	}
+	streakMin, err := b.positiveIntSetting(chatID, "display_streak_min")
+	if err != nil {
+		return streakDisplay{}, err
+	}
+	titles, err := b.getChatSetting(chatID, "streak_titles", "")
+	if err != nil {
+		return streakDisplay{}, err
+	}
+	return streakDisplay{Cap: streakCap, Min: streakMin, Titles: titles == "on"}, nil
+}
+
+// formatName renders a participant's name in lists, with their streak title if the chat wants it
+func formatName(lang, name string, streak int, display streakDisplay) string {
+	if !display.Titles {
+		return name
+	}
+	return name + " · " + StreakTitle(lang, streak)
+}
+
+// handleTiers turns difficulty tiers for completions on or off: "/tiers on|off"
+func (b *Bot) handleTiers(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		if err := b.setChatSetting(chatID, "tiers", "on"); err != nil {
+			return err
+		}
+		text = Messages["tiers_on"]
+	case "off":
+		if err := b.setChatSetting(chatID, "tiers", ""); err != nil {
+			return err
+		}
+		text = Messages["tiers_off"]
+	default:
+		text = Messages["tiers_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleTitles turns streak titles next to names on or off: "/titles on|off"
+func (b *Bot) handleTitles(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		if err := b.setChatSetting(chatID, "streak_titles", "on"); err != nil {
+			return err
+		}
+		text = Messages["titles_on"]
+	case "off":
+		if err := b.setChatSetting(chatID, "streak_titles", ""); err != nil {
+			return err
+		}
+		text = Messages["titles_off"]
+	default:
+		text = Messages["titles_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// getLast7Days returns whether the user completed each of the last 7 days, oldest first
+// and today last, and when they joined
+func (b *Bot) getLast7Days(userID int64) ([]bool, time.Time, error) {
+	var joinedAt time.Time
+	err := b.db.QueryRow(`SELECT joined_at FROM participants WHERE user_id = ?`, userID).Scan(&joinedAt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	rows, err := b.db.Query(`
+		SELECT CAST(completed_at AS TEXT) FROM daily_completions 
+		WHERE user_id = ? AND completed_at > ? AND completed_at <= ?
+	`, userID, now.AddDate(0, 0, -7).Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	completed := make(map[string]bool)
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			return nil, time.Time{}, err
+		}
+		completed[date] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	days := make([]bool, 7)
+	for i := range days {
+		days[i] = completed[now.AddDate(0, 0, i-6).Format("2006-01-02")]
+	}
+	return days, joinedAt, nil
+}
+
+// weekGrid renders the last 7 days like "🟩🟩⬜🟩🟩🟩🟩". Days before the user joined are
+// left blank rather than shown as missed, unless there is a completion on them.
+func weekGrid(days []bool, joinedAt, now time.Time) string {
+	joined := joinedAt.In(now.Location()).Format("2006-01-02")
+	var grid strings.Builder
+	for i, done := range days {
+		date := now.AddDate(0, 0, i-len(days)+1).Format("2006-01-02")
+		switch {
+		case done:
+			grid.WriteString(GridIcons["completed"])
+		case date < joined:
+			grid.WriteString(GridIcons["not_joined"])
+		default:
+			grid.WriteString(GridIcons["missed"])
+		}
+	}
+	return grid.String()
+}
+
+// handleWeekGrid shows or hides the 7-day grid under each name in the list: "/weekgrid on|off"
+func (b *Bot) handleWeekGrid(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		if err := b.setChatSetting(chatID, "week_grid", "on"); err != nil {
+			return err
+		}
+		text = Messages["week_grid_on"]
+	case "off":
+		if err := b.setChatSetting(chatID, "week_grid", ""); err != nil {
+			return err
+		}
+		text = Messages["week_grid_off"]
+	default:
+		text = Messages["week_grid_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// formatStreak renders a streak like "12 дней", "500+ дней" once it is past the cap,
+// or "—" while it is below the minimum. These options only affect how the streak looks,
+// the true value is stored and used everywhere else.
+func formatStreak(lang string, streak int, display streakDisplay) string {
+	if display.Min > 0 && streak < display.Min {
+		return "—"
+	}
+	if display.Cap > 0 && streak > display.Cap {
+		return fmt.Sprintf("%d+ %s", display.Cap, DayWord(lang, display.Cap))
+	}
+	return fmt.Sprintf("%d %s", streak, DayWord(lang, streak))
+}
+
+// handleStreakMin hides short streaks in lists: "/streakmin 3" or "/streakmin off"
+func (b *Bot) handleStreakMin(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+
+	value := strings.TrimSpace(message.CommandArguments())
+
+	var text string
+	if value == "off" {
+		if err := b.setChatSetting(chatID, "display_streak_min", ""); err != nil {
+			return err
+		}
+		text = t(lang, "streak_min_off")
+	} else if streakMin, err := strconv.Atoi(value); err != nil || streakMin < 1 {
+		text = t(lang, "streak_min_usage")
+	} else {
+		if err := b.setChatSetting(chatID, "display_streak_min", value); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(t(lang, "streak_min_set"), streakMin, DayWord(lang, streakMin))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleGroupThreshold sets the share of participants that keeps the group streak alive:
+// "/groupthreshold 90" or "/groupthreshold off" to require everyone again
+func (b *Bot) handleGroupThreshold(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	value := strings.TrimSuffix(strings.TrimSpace(message.CommandArguments()), "%")
+
+	var text string
+	if value == "off" {
+		if err := b.setChatSetting(chatID, "group_streak_threshold", ""); err != nil {
+			return err
+		}
+		text = Messages["group_threshold_off"]
+	} else if threshold, err := strconv.Atoi(value); err != nil || threshold < 1 || threshold > 100 {
+		text = Messages["group_threshold_usage"]
+	} else {
+		if err := b.setChatSetting(chatID, "group_streak_threshold", value); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["group_threshold_set"], threshold)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleStreakCap sets the display cap for streaks in lists: "/streakcap 500" or "/streakcap off"
+func (b *Bot) handleStreakCap(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	value := strings.TrimSpace(message.CommandArguments())
+
+	var text string
+	if value == "off" {
+		if err := b.setChatSetting(chatID, "display_streak_cap", ""); err != nil {
+			return err
+		}
+		text = Messages["streak_cap_off"]
+	} else if streakCap, err := strconv.Atoi(value); err != nil || streakCap < 1 {
+		text = Messages["streak_cap_usage"]
+	} else {
+		if err := b.setChatSetting(chatID, "display_streak_cap", value); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["streak_cap_set"], streakCap)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+func (b *Bot) getIndividualStreak(userID int64) (int, error) {
+	return b.individualStreak(b.db, userID)
+}
+
+// individualStreak computes the streak reading completions through q, so a completion
+// written in a not yet committed transaction is counted
+func (b *Bot) individualStreak(q querier, userID int64) (int, error) {
+	mode, minPerWeek, err := b.streakMode(userID)
+	if err != nil {
+		return 0, err
+	}
+	if mode == streakModeRolling {
+		return b.getRollingStreak(q, userID, minPerWeek)
+	}
+
+	rest, err := b.restDays(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Start from yesterday and go backwards to get the base streak
+	currentDate := now.AddDate(0, 0, -1)
+	consecutiveDays := 0
+
+	// Get base streak (not including today)
+	for {
+		dateStr := currentDate.Format("2006-01-02")
+
+		var completed bool
+		err := q.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM daily_completions 
+				WHERE user_id = ? AND completed_at = ?
+			)
+		`, userID, dateStr).Scan(&completed)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if !completed {
+			// A missed rest day doesn't break the streak
+			if rest[dateStr] {
+				currentDate = currentDate.AddDate(0, 0, -1)
+				continue
+			}
+			break
+		}
+
+		consecutiveDays++
+		currentDate = currentDate.AddDate(0, 0, -1)
+	}
+
+	// Check if completed today
+	today := now.Format("2006-01-02")
+	var completedToday bool
+	err = q.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM daily_completions 
+			WHERE user_id = ? AND completed_at = ?
+		)
+	`, userID, today).Scan(&completedToday)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Add today to streak if completed
+	if completedToday {
+		consecutiveDays++
+	}
+
+	return consecutiveDays, nil
+}
+
+func (b *Bot) handleJoinChallenge(query *tgbotapi.CallbackQuery) error {
+	msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["enter_name"])
+	msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+	sent, err := b.sendMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	// Store temporary state in DB to handle the name response
+	_, err = b.db.Exec(`
+		INSERT OR REPLACE INTO pending_joins (user_id, chat_id, prompt_message_id)
+		VALUES (?, ?, ?)
+	`, query.From.ID, query.Message.Chat.ID, sent.MessageID)
+	return err
+}
+
+// handleNameResponse finishes joining with the name the user replied with. An empty
+// reply asks again once, after that the Telegram username is used instead.
+func (b *Bot) handleNameResponse(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+	displayName := strings.TrimSpace(message.Text)
+
+	if displayName == "" {
+		var emptyReplies int
+		err := b.db.QueryRow(`SELECT COALESCE(empty_replies, 0) FROM pending_joins WHERE user_id = ?`, userID).Scan(&emptyReplies)
+		if err != nil {
+			return err
+		}
+
+		if emptyReplies == 0 {
+			if _, err := b.db.Exec(`UPDATE pending_joins SET empty_replies = empty_replies + 1 WHERE user_id = ?`, userID); err != nil {
+				return err
+			}
+			msg := tgbotapi.NewMessage(chatID, Messages["enter_name_empty"])
+			msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+			sent, err := b.sendMessage(msg)
+			if err != nil {
+				return err
+			}
+			_, err = b.db.Exec(`UPDATE pending_joins SET prompt_message_id = ? WHERE user_id = ?`, sent.MessageID, userID)
+			return err
+		}
+
+		displayName = message.From.UserName
+		if displayName == "" {
+			displayName = message.From.FirstName
+		}
+	}
+
+	// Insert participant with custom name
+	_, err := b.db.Exec(`
+		INSERT OR REPLACE INTO participants (user_id, username, chat_id, display_name)
+		VALUES (?, ?, ?, ?)
+	`, userID, message.From.UserName, chatID, displayName)
+	if err != nil {
+		return err
+	}
+	b.invalidateParticipantsCache(chatID)
+
+	// Remove from pending joins
+	_, err = b.db.Exec(`DELETE FROM pending_joins WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(`DELETE FROM start_offers WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	// Explain what counts as a completion before showing the list
+	if err := b.sendRules(chatID); err != nil {
+		return err
+	}
+
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// handleRenameCallback starts a name change from the scoreboard button: "rename:userID".
+// Only the participant the button was made for may use it.
+func (b *Bot) handleRenameCallback(query *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid callback data format")
+	}
+	ownerID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if query.From.ID != ownerID {
+		callback := tgbotapi.NewCallback(query.ID, Messages["button_not_yours"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+
+	callback := tgbotapi.NewCallback(query.ID, "")
+	if _, err := b.api.Request(callback); err != nil {
+		return err
+	}
+
+	return b.startRename(query.From.ID, query.Message.Chat.ID, query.Message.MessageID)
+}
+
+// handleRename starts a name change from the /rename or /me command
+func (b *Bot) handleRename(message *tgbotapi.Message) error {
+	var isParticipant bool
+	err := b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, message.From.ID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	return b.startRename(message.From.ID, message.Chat.ID, message.MessageID)
+}
+
+// startRename waits for the user's next message in the chat as their new name
+func (b *Bot) startRename(userID, chatID int64, replyTo int) error {
+	_, err := b.db.Exec(`
+		INSERT OR REPLACE INTO bot_state (user_id, chat_id, state, context)
+		VALUES (?, ?, 'waiting_rename', '')
+	`, userID, chatID)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, Messages["enter_new_name"])
+	msg.ReplyToMessageID = replyTo
+	msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// maxDisplayNameLength is the longest name, in characters, a participant can rename to
+const maxDisplayNameLength = 32
+
+// handleRenameInput saves the new name the user sent after starting a rename
+func (b *Bot) handleRenameInput(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	displayName := strings.TrimSpace(message.Text)
+	if displayName == "" || utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		text := Messages["enter_new_name"]
+		if displayName != "" {
+			text = fmt.Sprintf(Messages["rename_too_long"], maxDisplayNameLength)
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	if _, err := b.db.Exec(`UPDATE participants SET display_name = ? WHERE user_id = ?`, displayName, userID); err != nil {
+		return err
+	}
+	b.invalidateUserParticipantsCache(userID)
+
+	if _, err := b.db.Exec(`DELETE FROM bot_state WHERE user_id = ? AND chat_id = ?`, userID, chatID); err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["rename_done"], displayName))
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// pendingJoinTTL is how long a join waits for the user's name before it's abandoned
+const pendingJoinTTL = time.Hour
+
+// hasPendingJoin reports whether the user has a fresh join waiting for their name in
+// the chat. A stale one is removed, so a much later reply isn't taken for the name.
+func (b *Bot) hasPendingJoin(userID, chatID int64) (bool, error) {
+	_, err := b.db.Exec(`
+		DELETE FROM pending_joins 
+		WHERE user_id = ? AND created_at <= datetime('now', ?)
+	`, userID, fmt.Sprintf("-%d seconds", int(pendingJoinTTL.Seconds())))
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM pending_joins 
+			WHERE user_id = ? AND chat_id = ?
+		)
+	`, userID, chatID).Scan(&exists)
+	return exists, err
+}
+
+// isNameReply reports whether the message answers the user's pending name prompt. The
+// reply is matched by the prompt's message_id, since the prompt's text depends on the language.
+func (b *Bot) isNameReply(message *tgbotapi.Message) (bool, error) {
+	if message.ReplyToMessage == nil {
+		return false, nil
+	}
+	joining, err := b.hasPendingJoin(message.From.ID, message.Chat.ID)
+	if err != nil || !joining {
+		return false, err
+	}
+
+	var promptID sql.NullInt64
+	err = b.db.QueryRow(`SELECT prompt_message_id FROM pending_joins WHERE user_id = ?`, message.From.ID).Scan(&promptID)
+	if err != nil {
+		return false, err
+	}
+	return promptID.Valid && promptID.Int64 == int64(message.ReplyToMessage.MessageID), nil
+}
+
+// cleanupStalePendingJoins removes joins that waited for a name longer than pendingJoinTTL,
+// along with join offers shown as long ago
+func (b *Bot) cleanupStalePendingJoins() error {
+	age := fmt.Sprintf("-%d seconds", int(pendingJoinTTL.Seconds()))
+	if _, err := b.db.Exec(`DELETE FROM start_offers WHERE shown_at <= datetime('now', ?)`, age); err != nil {
+		return err
+	}
+
+	res, err := b.db.Exec(`
+		DELETE FROM pending_joins 
+		WHERE created_at <= datetime('now', ?)
+	`, age)
+	if err != nil {
+		return err
+	}
+
+	if removed, err := res.RowsAffected(); err == nil && removed > 0 {
+		b.logger.Info("removed stale pending joins", "rows", removed)
+	}
+	return nil
+}
+
+// leaveGracePeriod is how long the data of a participant who left is kept for /rejoin
+const leaveGracePeriod = 7 * 24 * time.Hour
+
+// leftAt returns when the participant left, zero if they haven't
+func (b *Bot) leftAt(userID int64) (time.Time, error) {
+	var left sql.NullTime
+	err := b.db.QueryRow(`SELECT left_at FROM participants WHERE user_id = ?`, userID).Scan(&left)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return left.Time, err
+}
+
+// handleLeave asks the user to confirm leaving the challenge: "leave_confirm:userID"
+// or "leave_cancel:userID"
+func (b *Bot) handleLeave(message *tgbotapi.Message) error {
+	userID := message.From.ID
+
+	var left sql.NullTime
+	err := b.db.QueryRow(`SELECT left_at FROM participants WHERE user_id = ?`, userID).Scan(&left)
+	if err == sql.ErrNoRows || left.Valid {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_participant"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["leave_confirm"], int(leaveGracePeriod.Hours()/24)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["leave_confirm"], fmt.Sprintf("leave_confirm:%d", userID)),
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["leave_cancel"], fmt.Sprintf("leave_cancel:%d", userID)),
+		),
+	)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleLeaveCallback answers the /leave confirmation. Only the user who asked may answer.
+// Nothing is deleted right away: the participant is hidden like an inactive one and can
+// come back with /rejoin within leaveGracePeriod, after that purgeLeftParticipants
+// removes their data.
+func (b *Bot) handleLeaveCallback(query *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid callback data format")
+	}
+	ownerID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if query.From.ID != ownerID {
+		callback := tgbotapi.NewCallback(query.ID, Messages["button_not_yours"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		return err
+	}
+
+	if parts[0] == "leave_cancel" {
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, Messages["leave_cancelled"])
+		_, err := b.send(edit)
+		return err
+	}
+
+	_, err = b.db.Exec(`
+		UPDATE participants SET left_at = CURRENT_TIMESTAMP, inactive_at = COALESCE(inactive_at, CURRENT_TIMESTAMP)
+		WHERE user_id = ? AND left_at IS NULL
+	`, ownerID)
+	if err != nil {
+		return err
+	}
+	b.invalidateUserParticipantsCache(ownerID)
+	b.logger.Info("participant left", "user_id", ownerID)
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, Messages["leave_goodbye"])
+	if _, err := b.send(edit); err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(query.Message.Chat.ID, fmt.Sprintf(Messages["leave_done"], int(leaveGracePeriod.Hours()/24)))
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleRejoin brings back a participant who left within leaveGracePeriod, with their
+// completions and streak intact
+func (b *Bot) handleRejoin(message *tgbotapi.Message) error {
+	userID := message.From.ID
+
+	var left sql.NullTime
+	err := b.db.QueryRow(`SELECT left_at FROM participants WHERE user_id = ?`, userID).Scan(&left)
+	if err == sql.ErrNoRows {
+		// Never joined, or left long enough ago to be purged
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["rejoin_expired"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !left.Valid {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["rejoin_not_left"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	if time.Since(left.Time) > leaveGracePeriod {
+		// The purge job hasn't got to this participant yet, but the window is over
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["rejoin_expired"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	_, err = b.db.Exec(`UPDATE participants SET left_at = NULL, inactive_at = NULL WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	b.invalidateUserParticipantsCache(userID)
+
+	streak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		return err
+	}
+	b.logger.Info("participant rejoined", "user_id", userID, "streak", streak)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["rejoin_done"], streak))
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(message.Chat.ID, userID)
+}
+
+// purgeLeftParticipants deletes everything about participants who left more than
+// leaveGracePeriod ago. The admin audit log is kept.
+func (b *Bot) purgeLeftParticipants() error {
+	rows, err := b.db.Query(`
+		SELECT user_id, chat_id FROM participants 
+		WHERE left_at IS NOT NULL AND left_at <= datetime('now', ?)
+	`, fmt.Sprintf("-%d seconds", int(leaveGracePeriod.Seconds())))
+	if err != nil {
+		return err
+	}
+	var userIDs []int64
+	chats := make(map[int64]bool)
+	for rows.Next() {
+		var userID, chatID int64
+		if err := rows.Scan(&userID, &chatID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+		chats[chatID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		tx, err := b.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, query := range []string{
+			`DELETE FROM daily_completions WHERE user_id = ?`,
+			`DELETE FROM achievements WHERE user_id = ?`,
+			`DELETE FROM bot_state WHERE user_id = ?`,
+			`DELETE FROM digest_subscriptions WHERE user_id = ?`,
+			`DELETE FROM user_settings WHERE user_id = ?`,
+			`DELETE FROM vacations WHERE user_id = ?`,
+			`DELETE FROM skipped_days WHERE user_id = ?`,
+			`DELETE FROM streak_freezes WHERE user_id = ?`,
+			`DELETE FROM completion_changes WHERE user_id = ?`,
+			`DELETE FROM pending_joins WHERE user_id = ?`,
+			`DELETE FROM start_offers WHERE user_id = ?`,
+			`DELETE FROM completion_reactions WHERE user_id = ?1 OR reactor_id = ?1`,
+			`DELETE FROM linked_users WHERE user_id = ?1 OR partner_id = ?1`,
+			`DELETE FROM participants WHERE user_id = ?`,
+		} {
+			if _, err := tx.Exec(query, userID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		b.logger.Info("purged participant who left", "user_id", userID)
+	}
+	for chatID := range chats {
+		b.invalidateParticipantsCache(chatID)
+	}
+	return nil
+}
+
+// pendingFlows returns the message keys describing flows the user has started in the chat
+// but not finished: a join waiting for a name, or an admin waiting for a custom streak
+func (b *Bot) pendingFlows(userID, chatID int64) ([]string, error) {
+	var flows []string
+
+	joining, err := b.hasPendingJoin(userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if joining {
+		flows = append(flows, "status_pending_join")
+	}
+
+	var waitingStreak bool
+	err = b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM bot_state 
+			WHERE user_id = ? AND chat_id = ? AND state = 'waiting_custom_streak'
+		)
+	`, userID, chatID).Scan(&waitingStreak)
+	if err != nil {
+		return nil, err
+	}
+	if waitingStreak {
+		flows = append(flows, "status_waiting_streak")
+	}
+
+	waitingRename, err := b.hasBotState(userID, chatID, "waiting_rename")
+	if err != nil {
+		return nil, err
+	}
+	if waitingRename {
+		flows = append(flows, "status_waiting_rename")
+	}
+
+	return flows, nil
+}
+
+// hasBotState reports whether the user is in the given state in the chat
+func (b *Bot) hasBotState(userID, chatID int64, state string) (bool, error) {
+	var exists bool
+	err := b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM bot_state 
+			WHERE user_id = ? AND chat_id = ? AND state = ?
+		)
+	`, userID, chatID, state).Scan(&exists)
+	return exists, err
+}
+
+// cancelPendingFlows drops every unfinished flow of the user in the chat
+func (b *Bot) cancelPendingFlows(userID, chatID int64) error {
+	if _, err := b.db.Exec(`DELETE FROM pending_joins WHERE user_id = ? AND chat_id = ?`, userID, chatID); err != nil {
+		return err
+	}
+	_, err := b.db.Exec(`DELETE FROM bot_state WHERE user_id = ? AND chat_id = ?`, userID, chatID)
+	return err
+}
+
+// handleStatus reports the user's unfinished flows and offers to cancel them
+func (b *Bot) handleStatus(message *tgbotapi.Message) error {
+	flows, err := b.pendingFlows(message.From.ID, message.Chat.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(flows) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["status_idle"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	response := Messages["status_header"] + "\n"
+	for _, flow := range flows {
+		response += "  • " + Messages[flow] + "\n"
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, response)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["cancel"], "cancel_state"),
+		),
+	)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleCancel cancels the user's unfinished flows in the chat
+func (b *Bot) handleCancel(message *tgbotapi.Message) error {
+	flows, err := b.pendingFlows(message.From.ID, message.Chat.ID)
+	if err != nil {
+		return err
+	}
+	if err := b.cancelPendingFlows(message.From.ID, message.Chat.ID); err != nil {
+		return err
+	}
+
+	text := Messages["cancel_done"]
+	if len(flows) == 0 {
+		text = Messages["status_idle"]
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleCancelCallback cancels the user's unfinished flows from the /status button
+func (b *Bot) handleCancelCallback(query *tgbotapi.CallbackQuery) error {
+	if err := b.cancelPendingFlows(query.From.ID, query.Message.Chat.ID); err != nil {
+		return err
+	}
+
+	callback := tgbotapi.NewCallback(query.ID, Messages["cancel_done"])
+	if _, err := b.api.Request(callback); err != nil {
+		return err
+	}
+
+	editMsg := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, Messages["cancel_done"])
+	_, err := b.send(editMsg)
+	return err
+}
+
+// sendRules sends the chat's challenge rules, or the generic ones if none were set
+func (b *Bot) sendRules(chatID int64) error {
+	rules, err := b.getChatSetting(chatID, "rules", Messages["default_rules"])
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, Messages["rules_header"]+"\n\n"+rules)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleSetRules stores the chat's challenge rules: "/setrules текст", "/setrules" alone resets them
+func (b *Bot) handleSetRules(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	rules := strings.TrimSpace(message.CommandArguments())
+	if err := b.setChatSetting(message.Chat.ID, "rules", rules); err != nil {
+		return err
+	}
+
+	text := Messages["rules_saved"]
+	if rules == "" {
+		text = Messages["rules_reset"]
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+func (b *Bot) sendParticipantsList(chatID int64, userID int64) error {
+	response, err := b.buildParticipantsList(chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	completedToday, err := b.completedOn(userID, now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	msg.ReplyMarkup = buildMainKeyboard(completedToday)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// sendRefreshedList answers an explicit refresh. The user already has the main keyboard
+// by then, so participants get an inline button to change their name instead.
+func (b *Bot) sendRefreshedList(chatID int64, userID int64) error {
+	response, err := b.buildParticipantsList(chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	var isParticipant bool
+	err = b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, userID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	if isParticipant {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["rename"], fmt.Sprintf("rename:%d", userID)),
+			),
+		)
+	} else {
+		// Not a participant, so there's no completion to undo
+		msg.ReplyMarkup = buildMainKeyboard(false)
+	}
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// scoreboardChat is the chat whose scoreboard the user sees in chatID. In their private
+// chat that's the chat they joined from.
+func (b *Bot) scoreboardChat(chatID, userID int64) (int64, error) {
+	if !isDM(chatID, userID) {
+		return chatID, nil
+	}
+	return b.participantChat(userID)
+}
+
+// buildParticipantsList renders the chat's scoreboard: today's statuses, the group streak
+// and the walk of fame
+func (b *Bot) buildParticipantsList(chatID int64, userID int64) (string, error) {
+	boardChat, err := b.scoreboardChat(chatID, userID)
+	if err != nil {
+		return "", err
+	}
+	participants, err := b.cachedParticipantsList(boardChat)
+	if err != nil {
+		return "", err
+	}
+
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return "", err
+	}
+
+	now, err := b.todayIn(boardChat)
+	if err != nil {
+		return "", err
+	}
+	currentWeekday := WeekdayName(lang, now.Weekday())
+
+	currentDate := now.Format("02.01.2006")
+	response := fmt.Sprintf("%s, %s\n", currentWeekday, currentDate)
+
+	// In the rolling mode the number next to a name means something else, so explain it
+	mode, minPerWeek, err := b.chatStreakMode(chatID)
+	if err != nil {
+		return "", err
+	}
+	if mode == streakModeRolling {
+		response += fmt.Sprintf(t(lang, "streak_mode_rolling_label"), minPerWeek) + "\n"
+	}
+
+	display, err := b.getStreakDisplay(chatID)
+	if err != nil {
+		return "", err
+	}
+	showGrid, err := b.getChatSetting(chatID, "week_grid", "")
+	if err != nil {
+		return "", err
+	}
+
+	response += "\n"
+
+	for _, p := range participants {
+		status := StatusIcons["pending"]
+		if p.Completed {
+			status = StatusIcons["completed"]
+		} else if p.Frozen {
+			status = StatusIcons["frozen"]
+		} else if p.Skipped {
+			status = StatusIcons["skipped"]
+		}
+		if p.Tier != "" {
+			status += " " + TierLabels[p.Tier]
+		}
+
+		streak := formatStreak(lang, p.Streak, display)
+		if p.Best > p.Streak {
+			streak += fmt.Sprintf(t(lang, "streak_best"), p.Best)
+		}
+		streak += fmt.Sprintf(t(lang, "streak_total"), p.Total)
+		response += fmt.Sprintf("- %s %s (%s)\n", status, formatName(lang, p.Name, p.Streak, display), streak)
+		if showGrid == "on" {
+			response += "  " + p.Grid + "\n"
+		}
+		response += "\n"
+	}
+
+	// hidden for now
+	// Add streak information to the response
+	streak, err := b.getConsecutiveCompletionDays(boardChat)
+	if err != nil {
+		return "", err
+	}
+
+	response += "\n" + fmt.Sprintf(t(lang, "group_streak"), streak) + "\n"
+	if combo := comboLine(lang, streak); combo != "" {
+		response += combo + "\n"
+	}
+
+	// Add Walk of Fame unless the chat moved it to /fame
+	showFame, err := b.getChatSetting(chatID, "show_walk_of_fame", "on")
+	if err != nil {
+		return "", err
+	}
+	if showFame != "off" {
+		fame, err := b.getWalkOfFame()
+		if err != nil {
+			return "", err
+		}
+		if len(fame) > 0 {
+			response += t(lang, "hall_of_fame_separator") + "\n"
+			response += formatWalkOfFame(lang, fame)
+		}
+	}
+
+	return response, nil
+}
+
+// registerCommands publishes BotCommands as the bot's "/" menu in Telegram
+func (b *Bot) registerCommands() error {
+	var commands []tgbotapi.BotCommand
+	for _, c := range BotCommands {
+		commands = append(commands, tgbotapi.BotCommand{Command: c.Command, Description: c.Description})
+	}
+	_, err := b.api.Request(tgbotapi.NewSetMyCommands(commands...))
+	return err
+}
+
+// handleHelp lists the commands from the "/" menu
+func (b *Bot) handleHelp(message *tgbotapi.Message) error {
+	response := Messages["help_header"] + "\n\n"
+	for _, c := range BotCommands {
+		response += fmt.Sprintf("/%s - %s\n", c.Command, c.Description)
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, response)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// buildMainKeyboard creates the reply keyboard with the main actions. The undo button
+// is only there for a user who has completed today.
+func buildMainKeyboard(completedToday bool) tgbotapi.ReplyKeyboardMarkup {
+	replyKeyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(ButtonLabels["mark_yesterday"]),
+			tgbotapi.NewKeyboardButton(ButtonLabels["do_exercise"]),
+		),
+	)
+	if completedToday {
+		replyKeyboard.Keyboard = append(replyKeyboard.Keyboard, tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(ButtonLabels["undo_complete"]),
+		))
+	}
+	replyKeyboard.ResizeKeyboard = true // Make keyboard smaller
+	replyKeyboard.Selective = true
+	return replyKeyboard
+}
+
+// refreshKeyboardsDelay spaces out /refreshkeyboards messages to stay
+// within Telegram's limit of about 20 messages per minute in a group
+const refreshKeyboardsDelay = 3 * time.Second
+
+// keyboardRefreshMessage re-attaches the main keyboard for one participant, with the undo
+// button if they completed today. The keyboard is selective, so the participant is
+// mentioned to make it show up for them.
+func keyboardRefreshMessage(chatID, userID int64, name string, completedToday bool) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["keyboard_refreshed"], name))
+	msg.Entities = []tgbotapi.MessageEntity{{
+		Type:   "text_mention",
+		Offset: 0,
+		Length: len(utf16.Encode([]rune(name))),
+		User:   &tgbotapi.User{ID: userID},
+	}}
+	msg.ReplyMarkup = buildMainKeyboard(completedToday)
+	return msg
+}
+
+// keyboardRefreshTargets builds the keyboard refresh message of every active participant
+// of the chat, one each
+func (b *Bot) keyboardRefreshTargets(chatID int64) ([]tgbotapi.MessageConfig, error) {
+	rows, err := b.db.Query(`
+		SELECT user_id, chat_id, COALESCE(display_name, username) FROM participants
+		WHERE chat_id = ? AND inactive_at IS NULL AND left_at IS NULL
+		ORDER BY joined_at
+	`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	type participant struct {
+		UserID, ChatID int64
+		Name           string
+	}
+	var participants []participant
+	for rows.Next() {
+		var p participant
+		if err := rows.Scan(&p.UserID, &p.ChatID, &p.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		participants = append(participants, p)
+	}
+	rows.Close()
+
+	var targets []tgbotapi.MessageConfig
+	for _, p := range participants {
+		now, err := b.todayIn(p.ChatID)
+		if err != nil {
+			return nil, err
+		}
+		completedToday, err := b.completedOn(p.UserID, now.Format("2006-01-02"))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, keyboardRefreshMessage(p.ChatID, p.UserID, p.Name, completedToday))
+	}
+	return targets, nil
+}
+
+// handleRefreshKeyboards sends every participant of the chat the main keyboard again, for when
+// clients lost it. Messages are spaced out, so it runs in the background and reports when done.
+func (b *Bot) handleRefreshKeyboards(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	targets, err := b.keyboardRefreshTargets(chatID)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["refresh_keyboards_started"], len(targets)))
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				b.logger.Error("panic while refreshing keyboards",
+					"panic", r,
+					"chat_id", chatID,
+					"stack", string(debug.Stack()),
+				)
+			}
+		}()
+
+		refreshed := 0
+		for i, target := range targets {
+			if i > 0 {
+				time.Sleep(refreshKeyboardsDelay)
+			}
+			if _, err := b.sendMessage(target); err != nil {
+				continue
+			}
+			refreshed++
+		}
+
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["refresh_keyboards_done"], refreshed, len(targets)))
+		if _, err := b.sendMessage(msg); err != nil {
+			b.logger.Error("failed to report refreshed keyboards", "chat_id", chatID, "error", err)
+		}
+	}()
+	return nil
+}
+
+func (b *Bot) handleUpdateList(query *tgbotapi.CallbackQuery) error {
+	// Add callback acknowledgment
+	callback := tgbotapi.NewCallback(query.ID, "")
+	if _, err := b.api.Request(callback); err != nil {
+		return err
+	}
+
+	return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
+}
+
+// completionTiers are the difficulties a completion can have in chats with tiers
+var completionTiers = []string{"easy", "normal", "hard"}
+
+func (b *Bot) handleCompleteChallenge(query *tgbotapi.CallbackQuery) error {
+	// In chats with tiers the completion is recorded once the user picks a tier
+	tiers, err := b.getChatSetting(query.Message.Chat.ID, "tiers", "")
+	if err != nil {
+		return err
+	}
+	if tiers == "on" {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, tier := range completionTiers {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(TierLabels[tier], "tier:"+tier))
+		}
+		msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["tier_pick"])
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	return b.completeChallenge(query, "")
+}
+
+// handleTierCallback completes today with the picked tier: "tier:easy"
+func (b *Bot) handleTierCallback(query *tgbotapi.CallbackQuery) error {
+	tier := strings.TrimPrefix(query.Data, "tier:")
+	if _, ok := TierLabels[tier]; !ok {
+		return fmt.Errorf("unknown tier %q", tier)
+	}
+
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, TierLabels[tier])); err != nil {
+		return err
+	}
+
+	// Already answered, so the completion flow must not answer again
+	query.ID = ""
+	return b.completeChallenge(query, tier)
+}
+
+// completeChallenge marks today for the user, with a tier in chats that use them
+func (b *Bot) completeChallenge(query *tgbotapi.CallbackQuery, tier string) error {
+	// The day and the deadline are those of the chat the user joined from,
+	// wherever they press the button
+	userChat, err := b.participantChat(query.From.ID)
+	if err != nil {
+		return err
+	}
+	now, err := b.todayIn(userChat)
+	if err != nil {
+		return err
+	}
+
+	// Completions after the chat's deadline are rejected or count for tomorrow
+	date, ok, err := b.effectiveCompletionDate(userChat, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["deadline_passed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	today := date.Format("2006-01-02")
+	movedToNextDay := today != now.Format("2006-01-02")
+
+	// Check if already completed today
+	var completed bool
+	err = b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM daily_completions 
+			WHERE user_id = ? AND completed_at = ?
+		)
+	`, query.From.ID, today).Scan(&completed)
+	if err != nil {
+		return err
+	}
+
+	if completed {
+		return b.sendAlreadyCompleted(query)
+	}
+
+	// Reject rapid complete/undo toggling
+	left, err := b.markCooldownLeft(query.From.ID, today)
+	if err != nil {
+		return err
+	}
+	if left > 0 {
+		return b.sendCooldownMessage(query.Message.Chat.ID, left)
+	}
+
+	congratsMessage := getRandomCongratsMessage(congratsModeToday)
+
+	// The completion and the achievements it earns are written together, so a crash
+	// in between can't leave a completion without its achievement
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	added, err := b.insertCompletion(tx, query.From.ID, today, congratsMessage, tier)
+	if err != nil {
+		return err
+	}
+	if !added {
+		// A double tap got here first, between the check above and the insert
+		return b.sendAlreadyCompleted(query)
+	}
+	streak, err := b.individualStreak(tx, query.From.ID)
+	if err != nil {
+		return err
+	}
+	earned, err := recordAchievements(tx, query.From.ID, streak, today)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.invalidateParticipantsCache(userChat)
+
+	if err := b.announceAchievements(query.From.ID, earned); err != nil {
+		return err
+	}
+
+	if err := b.recordCompletionChange(query.From.ID, today); err != nil {
+		return err
+	}
+
+	partnerName, err := b.completeLinkedPartner(query.From.ID, today, congratsMessage, tier)
+	if err != nil {
+		b.logger.Error("failed to complete for linked partner", "error", err, "user_id", query.From.ID)
+	}
+
+	if err := b.checkPersonalGoal(query.From.ID, streak); err != nil {
+		b.logger.Error("failed to check personal goal", "error", err, "user_id", query.From.ID)
+	}
+
+	if err := b.updateStreakRecord(query.From.ID); err != nil {
+		b.logger.Error("failed to update streak record", "error", err, "user_id", query.From.ID)
+	}
+
+	if err := b.updateGroupCountdown(userChat); err != nil {
+		b.logger.Error("failed to update group countdown", "error", err, "chat_id", userChat)
+	}
+
+	// Send congrats message
+	if movedToNextDay {
+		congratsMessage += "\n\n" + Messages["deadline_next_day"]
+	}
+	if partnerName != "" {
+		congratsMessage += "\n\n" + fmt.Sprintf(Messages["link_completed"], partnerName)
+	}
+	msg := tgbotapi.NewMessage(query.Message.Chat.ID, congratsMessage)
+	msg.ReplyMarkup = clapKeyboard(query.From.ID, today, 0)
+	_, err = b.sendMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := b.celebrateAllCompleted(userChat, today); err != nil {
+		b.logger.Error("failed to celebrate group completion", "error", err, "chat_id", userChat, "date", today)
+	}
+
+	// Show updated list
+	return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
+}
+
+// sendAlreadyCompleted answers a completion for a day that is already marked
+func (b *Bot) sendAlreadyCompleted(query *tgbotapi.CallbackQuery) error {
+	// Stop the button spinner in the inline path, the reply keyboard path has no callback to answer
+	if query.ID != "" {
+		if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, Messages["already_completed"])); err != nil {
+			return err
+		}
+	}
+
+	msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["already_completed"])
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+
+	// Show current stats so the user isn't left on a dead end
+	return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
+}
+
+// clapKeyboard builds the "👏" button under a congrats message: "clap:userID:date"
+func clapKeyboard(userID int64, date string, count int) tgbotapi.InlineKeyboardMarkup {
+	label := "👏"
+	if count > 0 {
+		label = fmt.Sprintf("👏 %d", count)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("clap:%d:%s", userID, date)),
+		),
+	)
+}
+
+// addClap records one member's applause for a completion. It returns the new tally and
+// whether the clap counted: each member claps once per completion and not for themselves.
+func (b *Bot) addClap(userID int64, date string, reactorID int64) (count int, added bool, err error) {
+	if reactorID != userID {
+		res, err := b.db.Exec(`
+			INSERT OR IGNORE INTO completion_reactions (user_id, completed_at, reactor_id)
+			VALUES (?, ?, ?)
+		`, userID, date, reactorID)
+		if err != nil {
+			return 0, false, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, false, err
+		}
+		added = affected > 0
+	}
+
+	err = b.db.QueryRow(`
+		SELECT COUNT(*) FROM completion_reactions 
+		WHERE user_id = ? AND completed_at = ?
+	`, userID, date).Scan(&count)
+	return count, added, err
+}
+
+// handleClapCallback applauds a completion from the button under its congrats message
+func (b *Bot) handleClapCallback(query *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid callback data format")
+	}
+
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	date := parts[2]
+
+	count, added, err := b.addClap(userID, date, query.From.ID)
+	if err != nil {
+		return err
+	}
+
+	answer := Messages["clap_added"]
+	if !added {
+		answer = Messages["clap_not_counted"]
+	}
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+		return err
+	}
+	if !added {
+		return nil
+	}
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, clapKeyboard(userID, date, count))
+	_, err = b.send(edit)
+	return err
+}
+
+// linkedPartner returns the participant linked with userID, or 0 if there is none
+func (b *Bot) linkedPartner(userID int64) (int64, error) {
+	var partnerID int64
+	err := b.db.QueryRow(`SELECT partner_id FROM linked_users WHERE user_id = ?`, userID).Scan(&partnerID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return partnerID, err
+}
+
+// completeLinkedPartner records the same completion for the user's linked partner, tagged
+// 'linked'. It returns the partner's name, or "" if nothing was recorded: no partner, the
+// partner left the bot or already completed that day.
+func (b *Bot) completeLinkedPartner(userID int64, date, congratsMessage, tier string) (string, error) {
+	partnerID, err := b.linkedPartner(userID)
+	if err != nil || partnerID == 0 {
+		return "", err
+	}
+
+	var tierValue sql.NullString
+	if tier != "" {
+		tierValue = sql.NullString{String: tier, Valid: true}
+	}
+	res, err := b.db.Exec(`
+		INSERT OR IGNORE INTO daily_completions (user_id, completed_at, congrats_message, chat_id, source, tier)
+		SELECT user_id, ?, ?, chat_id, 'linked', ? FROM participants
+		WHERE user_id = ? AND inactive_at IS NULL
+	`, date, congratsMessage, tierValue, partnerID)
+	if err != nil {
+		return "", err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return "", err
+	}
+	b.invalidateUserParticipantsCache(userID)
+
+	streak, err := b.getIndividualStreak(partnerID)
+	if err != nil {
+		return "", err
+	}
+	if err := b.checkAndRecordAchievements(partnerID, streak); err != nil {
+		return "", err
+	}
+	if err := b.updateStreakRecord(partnerID); err != nil {
+		b.logger.Error("failed to update streak record", "error", err, "user_id", partnerID)
+	}
+
+	var name string
+	err = b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, partnerID).Scan(&name)
+	if err != nil {
+		return "", err
+	}
+	b.logger.Info("completion recorded for linked partner", "user_id", userID, "partner_id", partnerID, "date", date)
+	return name, nil
+}
+
+// linkKeyboard asks the invited participant to accept a link: "link_accept:requesterID:targetID"
+func linkKeyboard(requesterID, targetID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["link_accept"], fmt.Sprintf("link_accept:%d:%d", requesterID, targetID)),
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["link_decline"], fmt.Sprintf("link_decline:%d:%d", requesterID, targetID)),
+		),
+	)
+}
+
+// handleLink invites another participant to share completions: "/link @username".
+// Nothing is linked until the invited participant accepts.
+func (b *Bot) handleLink(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	senderID := message.From.ID
+
+	arg := strings.TrimSpace(message.CommandArguments())
+	if !strings.HasPrefix(arg, "@") || len(arg) < 2 {
+		msg := tgbotapi.NewMessage(chatID, Messages["link_usage"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	targetUsername := strings.TrimPrefix(arg, "@")
+
+	var senderName string
+	var senderChatID int64
+	err := b.db.QueryRow(`SELECT COALESCE(display_name, username), chat_id FROM participants WHERE user_id = ?`, senderID).Scan(&senderName, &senderChatID)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var targetID int64
+	var targetName string
+	err = b.db.QueryRow(`
+		SELECT user_id, COALESCE(display_name, username) FROM participants 
+		WHERE username = ? COLLATE NOCASE AND chat_id = ?
+	`, targetUsername, senderChatID).Scan(&targetID, &targetName)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["shoutout_not_member"], targetUsername))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if targetID == senderID {
+		msg := tgbotapi.NewMessage(chatID, Messages["link_self"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	for _, id := range []int64{senderID, targetID} {
+		partnerID, err := b.linkedPartner(id)
+		if err != nil {
+			return err
+		}
+		if partnerID != 0 {
+			msg := tgbotapi.NewMessage(chatID, Messages["link_already"])
+			_, err = b.sendMessage(msg)
+			return err
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["link_request"], targetName, senderName))
+	msg.ReplyMarkup = linkKeyboard(senderID, targetID)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleLinkCallback answers a link invitation: "link_accept:requesterID:targetID" or
+// "link_decline:requesterID:targetID". Only the invited participant may answer.
+func (b *Bot) handleLinkCallback(query *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid callback data format")
+	}
+	requesterID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	targetID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if query.From.ID != targetID {
+		callback := tgbotapi.NewCallback(query.ID, Messages["link_not_yours"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+
+	text := Messages["link_declined"]
+	if parts[0] == "link_accept" {
+		tx, err := b.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Either side may have linked with someone else since the invitation
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM linked_users WHERE user_id IN (?, ?))`, requesterID, targetID).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			text = Messages["link_already"]
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO linked_users (user_id, partner_id) VALUES (?, ?), (?, ?)
+			`, requesterID, targetID, targetID, requesterID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			b.logger.Info("participants linked", "user_id", requesterID, "partner_id", targetID)
+			text = Messages["link_accepted"]
+		}
+	}
+
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		return err
+	}
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	_, err = b.send(edit)
+	return err
+}
+
+// handleUnlink removes the user's link, for both partners
+func (b *Bot) handleUnlink(message *tgbotapi.Message) error {
+	userID := message.From.ID
+
+	partnerID, err := b.linkedPartner(userID)
+	if err != nil {
+		return err
+	}
+	if partnerID == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["link_none"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	_, err = b.db.Exec(`DELETE FROM linked_users WHERE user_id IN (?, ?)`, userID, partnerID)
+	if err != nil {
+		return err
+	}
+	b.logger.Info("participants unlinked", "user_id", userID, "partner_id", partnerID)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["link_removed"])
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// errImpossibleDate is returned when a completion date can't be right given the user's
+// last completion and the current clock
+var errImpossibleDate = errors.New("completion date is too far in the future")
+
+// querier is what *sql.DB and *sql.Tx have in common, so the same code can run
+// on its own or as part of a transaction
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// recordCompletion inserts a completion for the given date (YYYY-MM-DD) and reports
+// whether it was added: a completion already there for the day, e.g. from a double tap
+// racing the first one, is left as is.
+// Dates more than one day after the user's stored last completion are refused, unless
+// the clock says that day has come, as it does for a user back from a break: anything
+// further can only come from a clock anomaly (NTP correction, DST) and would corrupt
+// streak math.
+func (b *Bot) recordCompletion(userID int64, date string, congratsMessage string) (bool, error) {
+	added, err := b.insertCompletion(b.db, userID, date, congratsMessage, "")
+	if err != nil {
+		return false, err
+	}
+	b.invalidateUserParticipantsCache(userID)
+	return added, nil
+}
+
+// insertCompletion is recordCompletion through q, with an optional tier.
+// The caller invalidates the participants cache once the write is committed.
+func (b *Bot) insertCompletion(q querier, userID int64, date, congratsMessage, tier string) (bool, error) {
+	parsed, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false, err
+	}
+
+	var lastDate sql.NullString
+	err = q.QueryRow(`SELECT MAX(date(completed_at)) FROM daily_completions WHERE user_id = ?`, userID).Scan(&lastDate)
+	if err != nil {
+		return false, err
+	}
+
+	reference, err := b.userNow(userID)
+	if err != nil {
+		return false, err
+	}
+	if lastDate.Valid {
+		last, err := time.Parse("2006-01-02", lastDate.String)
+		if err != nil {
+			return false, err
+		}
+		if last.Format("2006-01-02") > reference.Format("2006-01-02") {
+			reference = last
+		}
+	}
+	latestAllowed := reference.AddDate(0, 0, 1).Format("2006-01-02")
+	if parsed.Format("2006-01-02") > latestAllowed {
+		b.logger.Warn("refusing completion with impossible date",
+			"user_id", userID,
+			"date", date,
+			"last_completion", lastDate.String,
+			"now", time.Now(),
+		)
+		return false, fmt.Errorf("%w: %s", errImpossibleDate, date)
+	}
+
+	var tierValue sql.NullString
+	if tier != "" {
+		tierValue = sql.NullString{String: tier, Valid: true}
+	}
+	res, err := q.Exec(`
+		INSERT OR IGNORE INTO daily_completions (user_id, completed_at, congrats_message, chat_id, tier)
+		VALUES (?, ?, ?, (SELECT chat_id FROM participants WHERE user_id = ?), ?)
+	`, userID, date, congratsMessage, userID, tierValue)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+func (b *Bot) handleMarkYesterday(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+	userChat, err := b.participantChat(userID)
+	if err != nil {
+		return err
+	}
+	now, err := b.todayIn(userChat)
+	if err != nil {
+		return err
+	}
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+
+	// Check if already completed yesterday
+	var completed bool
+	err = b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM daily_completions 
+			WHERE user_id = ? AND completed_at = ?
+		)
+	`, userID, yesterday).Scan(&completed)
+	if err != nil {
+		b.logger.Error("db error checking yesterday's completion", "error", err, "user_id", userID)
+		return err
+	}
+
+	if completed {
+		return b.sendAlreadyCompletedYesterday(chatID, userID)
+	}
+
+	// Reject rapid complete/undo toggling
+	left, err := b.markCooldownLeft(userID, yesterday)
+	if err != nil {
+		b.logger.Error("db error checking mark cooldown", "error", err, "user_id", userID)
+		return err
+	}
+	if left > 0 {
+		return b.sendCooldownMessage(chatID, left)
+	}
+
+	congratsMessage := getRandomCongratsMessage(congratsModeYesterday)
+
+	// Mark yesterday as completed
+	added, err := b.recordCompletion(userID, yesterday, congratsMessage)
+	if err != nil {
+		b.logger.Error("db error inserting yesterday's completion", "error", err, "user_id", userID)
+		return withUserMessage("error_marking_yesterday", err)
+	}
+	if !added {
+		return b.sendAlreadyCompletedYesterday(chatID, userID)
+	}
+
+	if errChange := b.recordCompletionChange(userID, yesterday); errChange != nil {
+		b.logger.Error("failed to record completion change after marking yesterday", "error", errChange, "user_id", userID)
+	}
+
+	// Get current streak to check for achievements
+	streak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		b.logger.Error("failed to get individual streak after marking yesterday", "error", err, "user_id", userID)
+	} else {
+		if errAch := b.checkAndRecordAchievements(userID, streak); errAch != nil {
+			b.logger.Error("failed to check/record achievements after marking yesterday", "error", errAch, "user_id", userID)
+		}
+		if errGoal := b.checkPersonalGoal(userID, streak); errGoal != nil {
+			b.logger.Error("failed to check personal goal after marking yesterday", "error", errGoal, "user_id", userID)
+		}
+	}
+
+	if errRecord := b.updateStreakRecord(userID); errRecord != nil {
+		b.logger.Error("failed to update streak record after marking yesterday", "error", errRecord, "user_id", userID)
+	}
+
+	successMsg := tgbotapi.NewMessage(chatID, congratsMessage+"\n\n"+Messages["yesterday_marked_success"])
+	_, errSend := b.sendMessage(successMsg)
+	if errSend != nil {
+		b.logger.Error("failed to send 'yesterday_marked_success' message", "error", errSend, "user_id", userID)
+	}
+
+	if errCelebrate := b.celebrateAllCompleted(userChat, yesterday); errCelebrate != nil {
+		b.logger.Error("failed to celebrate group completion after marking yesterday", "error", errCelebrate, "date", yesterday)
+	}
+
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// markDateWindowDays is how many days back /markdate offers to mark
+const markDateWindowDays = 7
+
+// handleMarkDate offers the last markDateWindowDays days without a completion as buttons,
+// for days the user forgot to log: "/markdate"
+func (b *Bot) handleMarkDate(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+	lang, err := b.resolveLang(userID, chatID)
+	if err != nil {
+		return err
+	}
+
+	var isParticipant bool
+	err = b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, userID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		msg := tgbotapi.NewMessage(chatID, t(lang, "not_participant"))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	for i := 1; i <= markDateWindowDays; i++ {
+		day := now.AddDate(0, 0, -i)
+		date := day.Format("2006-01-02")
+		completed, err := b.completedOn(userID, date)
+		if err != nil {
+			return err
+		}
+		if completed {
+			continue
+		}
+
+		label := fmt.Sprintf("%s %s", day.Format("02.01"), CalendarWeekdays[langOrDefault(lang)][(int(day.Weekday())+6)%7])
+		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("markdate:%d:%s", userID, date)),
+		))
+	}
+
+	if len(keyboard) == 0 {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(t(lang, "markdate_none"), markDateWindowDays, DayWord(lang, markDateWindowDays)))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, t(lang, "markdate_pick"))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleMarkDateCallback marks the day picked in /markdate: "markdate:userID:date".
+// Only the user who asked may pick, and only a past day within markDateWindowDays.
+func (b *Bot) handleMarkDateCallback(query *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid callback data format")
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	date := parts[2]
+	chatID := query.Message.Chat.ID
+	lang, err := b.resolveLang(query.From.ID, chatID)
+	if err != nil {
+		return err
+	}
+
+	if query.From.ID != userID {
+		callback := tgbotapi.NewCallback(query.ID, t(lang, "button_not_yours"))
+		_, err := b.api.Request(callback)
+		return err
+	}
+
+	// The buttons may be days old by now
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	if date >= now.Format("2006-01-02") || date < now.AddDate(0, 0, -markDateWindowDays).Format("2006-01-02") {
+		callback := tgbotapi.NewCallback(query.ID, fmt.Sprintf(t(lang, "markdate_out_of_range"), markDateWindowDays, DayWord(lang, markDateWindowDays)))
+		_, err := b.api.Request(callback)
+		return err
+	}
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		return err
+	}
+
+	left, err := b.markCooldownLeft(userID, date)
+	if err != nil {
+		return err
+	}
+	if left > 0 {
+		return b.sendCooldownMessage(chatID, left)
+	}
+
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return err
+	}
+	shown := day.Format("02.01.2006")
+
+	added, err := b.recordCompletion(userID, date, getRandomCongratsMessage(congratsModePastDay))
+	if err != nil {
+		return err
+	}
+	if !added {
+		edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, fmt.Sprintf(t(lang, "markdate_already"), shown))
+		_, err := b.send(edit)
+		return err
+	}
+
+	if err := b.recordCompletionChange(userID, date); err != nil {
+		b.logger.Error("failed to record completion change after marking a date", "error", err, "user_id", userID)
+	}
+
+	streak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		b.logger.Error("failed to get individual streak after marking a date", "error", err, "user_id", userID)
+	} else {
+		if err := b.checkAndRecordAchievements(userID, streak); err != nil {
+			b.logger.Error("failed to check/record achievements after marking a date", "error", err, "user_id", userID)
+		}
+		if err := b.checkPersonalGoal(userID, streak); err != nil {
+			b.logger.Error("failed to check personal goal after marking a date", "error", err, "user_id", userID)
+		}
+	}
+
+	if err := b.updateStreakRecord(userID); err != nil {
+		b.logger.Error("failed to update streak record after marking a date", "error", err, "user_id", userID)
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, fmt.Sprintf(t(lang, "markdate_done"), shown))
+	if _, err := b.send(edit); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// sendAlreadyCompletedYesterday answers a mark for yesterday when it is already marked
+func (b *Bot) sendAlreadyCompletedYesterday(chatID, userID int64) error {
+	msg := tgbotapi.NewMessage(chatID, Messages["already_completed_yesterday"])
+	_, errSend := b.sendMessage(msg)
+	if errSend != nil {
+		b.logger.Error("failed to send 'already_completed_yesterday' message", "error", errSend, "user_id", userID)
+	}
+
+	// Show current stats
+	return b.sendParticipantsList(chatID, userID)
+}
+
+// markAllToday marks today complete for every active participant of the chat who hasn't
+// done it yet, in one transaction, with an audit record for each. Users on vacation are skipped.
+// Returns who was marked.
+func (b *Bot) markAllToday(adminID, chatID int64) ([]int64, error) {
+	now, err := b.todayIn(chatID)
+	if err != nil {
+		return nil, err
+	}
+	today := now.Format("2006-01-02")
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT p.user_id FROM participants p
+		WHERE p.chat_id = ?
+			AND NOT EXISTS (SELECT 1 FROM daily_completions dc WHERE dc.user_id = p.user_id AND dc.completed_at = ?)
+			AND NOT EXISTS (SELECT 1 FROM vacations v WHERE v.user_id = p.user_id AND v.ended_at IS NULL)
+	`, chatID, today)
+	if err != nil {
+		return nil, err
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		before, err := countCompletions(tx, userID)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO daily_completions (user_id, completed_at, congrats_message, chat_id, source)
+			VALUES (?, ?, ?, ?, 'admin')
+		`, userID, today, Messages["markall_congrats"], chatID)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAudit(tx, adminID, "mark_all", userID, before, before+1); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	b.invalidateParticipantsCache(chatID)
+	return userIDs, nil
+}
+
+// handleMarkAll marks today for the whole chat after a joint session: "/markall"
+func (b *Bot) handleMarkAll(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	marked, err := b.markAllToday(message.From.ID, chatID)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Info("admin marked today for the chat", "admin_id", message.From.ID, "chat_id", chatID, "marked", len(marked))
+
+	for _, userID := range marked {
+		streak, err := b.getIndividualStreak(userID)
+		if err != nil {
+			b.logger.Error("failed to get streak after markall", "error", err, "user_id", userID)
+			continue
+		}
+		if err := b.checkAndRecordAchievements(userID, streak); err != nil {
+			b.logger.Error("failed to check achievements after markall", "error", err, "user_id", userID)
+		}
+		if err := b.updateStreakRecord(userID); err != nil {
+			b.logger.Error("failed to update streak record after markall", "error", err, "user_id", userID)
+		}
+	}
+
+	if err := b.updateGroupCountdown(chatID); err != nil {
+		b.logger.Error("failed to update group countdown after markall", "error", err, "chat_id", chatID)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["markall_done"], len(marked)))
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(chatID, message.From.ID)
+}
+
+// handleBackfillToToday inserts missing completion days for every participant up to today.
+// It preserves existing marks and only fills gaps between the participant's last completion
+// date and today. Uses a fixed congrats message for backfilled days to avoid noisy random texts.
+func (b *Bot) handleBackfillToToday(message *tgbotapi.Message) error {
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	// Collect participants
+	rows, err := b.db.Query(`SELECT user_id FROM participants`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var participantIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return err
+		}
+		participantIDs = append(participantIDs, userID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(participantIDs) == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["backfill_none"]))
+		_, _ = b.sendMessage(msg)
+		return nil
+	}
+
+	// Backfill per participant, in one transaction with an audit record for everyone changed
+	totalInserted := 0
+	fixedCongrats := "Бэкаповая отметка ✅"
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userID := range participantIDs {
+		// Each participant is filled up to their own chat's today
+		now, err := b.userNow(userID)
+		if err != nil {
+			return err
+		}
+		end, err := time.Parse("2006-01-02", now.Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+
+		var lastDate sql.NullString
+		// Find the most recent completion date for the user
+		err = tx.QueryRow(`
             SELECT MAX(completed_at) FROM daily_completions WHERE user_id = ?
         `, userID).Scan(&lastDate)
 		if err != nil {
 			return err
 		}
 
-		// Determine start date: if no completions, use the day before today so we only fill today
-		var start time.Time
-		if lastDate.Valid && lastDate.String != "" {
-			parsed, parseErr := time.Parse("2006-01-02", lastDate.String)
-			if parseErr != nil {
-				return parseErr
-			}
-			// Start from the day after the last completion
-			start = parsed.AddDate(0, 0, 1)
-		} else {
-			// If user never had a completion, we choose to only fill today
-			start = time.Now()
+		// Determine start date: if no completions, only fill today
+		start := end
+		if lastDate.Valid && lastDate.String != "" {
+			parsed, parseErr := time.Parse("2006-01-02", lastDate.String)
+			if parseErr != nil {
+				return parseErr
+			}
+			// Start from the day after the last completion
+			start = parsed.AddDate(0, 0, 1)
+		}
+
+		// If start is after today, nothing to do
+		if start.After(end) {
+			continue
+		}
+
+		before, err := countCompletions(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		// Iterate from start to today, inserting missing days; existing ones are kept
+		inserted := 0
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			added, err := b.insertCompletion(tx, userID, d.Format("2006-01-02"), fixedCongrats, "")
+			if err != nil {
+				return err
+			}
+			if added {
+				inserted++
+			}
+		}
+
+		if inserted > 0 {
+			if err := writeAudit(tx, message.From.ID, "backfill", userID, before, before+inserted); err != nil {
+				return err
+			}
+			totalInserted += inserted
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, userID := range participantIDs {
+		b.invalidateUserParticipantsCache(userID)
+	}
+
+	if totalInserted == 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["backfill_none"]))
+		_, _ = b.sendMessage(msg)
+		return nil
+	}
+
+	// After backfilling, re-calc achievements in case thresholds were crossed
+	for _, userID := range participantIDs {
+		streak, err := b.getIndividualStreak(userID)
+		if err == nil {
+			_ = b.checkAndRecordAchievements(userID, streak)
+		}
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["backfill_done"], totalInserted))
+	_, _ = b.sendMessage(msg)
+	return nil
+}
+
+// handleUndoComplete asks to confirm removing today's completion, from the inline
+// "undo_complete" button or the "Отменить зарядочку" button of the reply keyboard.
+// Nothing is deleted until the user confirms in handleUndoCallback.
+func (b *Bot) handleUndoComplete(query *tgbotapi.CallbackQuery) error {
+	now, err := b.userNow(query.From.ID)
+	if err != nil {
+		return err
+	}
+	today := now.Format("2006-01-02")
+
+	completed, err := b.completedOn(query.From.ID, today)
+	if err != nil {
+		return err
+	}
+	if !completed {
+		return b.answerUndo(query, Messages["no_completion_today"])
+	}
+
+	if query.ID != "" {
+		if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+			return err
+		}
+	}
+
+	msg := tgbotapi.NewMessage(query.Message.Chat.ID, Messages["undo_confirm"])
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["undo_confirm"], fmt.Sprintf("undo_confirm:%d:%s", query.From.ID, today)),
+			tgbotapi.NewInlineKeyboardButtonData(ButtonLabels["undo_cancel"], fmt.Sprintf("undo_cancel:%d:%s", query.From.ID, today)),
+		),
+	)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleUndoCallback answers the undo confirmation: "undo_confirm:userID:date" removes the
+// completion of that date, "undo_cancel:userID:date" keeps it. Only the user who asked may
+// answer, and only on the same day, so a stale button can't remove an older completion.
+func (b *Bot) handleUndoCallback(query *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid callback data format")
+	}
+	ownerID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	date := parts[2]
+
+	if query.From.ID != ownerID {
+		callback := tgbotapi.NewCallback(query.ID, Messages["button_not_yours"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		return err
+	}
+
+	chatID := query.Message.Chat.ID
+	userChat, err := b.participantChat(ownerID)
+	if err != nil {
+		return err
+	}
+	now, err := b.todayIn(userChat)
+	if err != nil {
+		return err
+	}
+
+	text := Messages["undo_kept"]
+	switch {
+	case parts[0] == "undo_cancel":
+		// Keep the completion
+	case date != now.Format("2006-01-02"):
+		text = Messages["undo_expired"]
+	default:
+		res, err := b.db.Exec(`
+			DELETE FROM daily_completions 
+			WHERE user_id = ? AND completed_at = ?
+		`, ownerID, date)
+		if err != nil {
+			return err
+		}
+		text = Messages["no_completion_today"]
+		if removed, err := res.RowsAffected(); err == nil && removed > 0 {
+			b.invalidateParticipantsCache(userChat)
+			text = Messages["completion_cancelled"]
+
+			if err := b.recordCompletionChange(ownerID, date); err != nil {
+				return err
+			}
+			if err := b.updateGroupCountdown(userChat); err != nil {
+				b.logger.Error("failed to update group countdown", "error", err, "chat_id", userChat)
+			}
+		}
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, text)
+	if _, err := b.send(edit); err != nil {
+		return err
+	}
+	return b.sendParticipantsList(chatID, ownerID)
+}
+
+// answerUndo tells the user how the undo went: in the button's popup for the inline
+// path, as a message for the reply keyboard path, which has no callback to answer
+func (b *Bot) answerUndo(query *tgbotapi.CallbackQuery, text string) error {
+	if query.ID != "" {
+		callback := tgbotapi.NewCallback(query.ID, text)
+		_, err := b.api.Request(callback)
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(query.Message.Chat.ID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// buildReminder builds a reminder with the participants list for a chat. headerKey selects
+// the reminder text. The message is sent silently if the chat asked for it at this time.
+func (b *Bot) buildReminder(chatID int64, headerKey string, now time.Time) (tgbotapi.MessageConfig, error) {
+	participants, err := b.cachedParticipantsList(chatID)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	display, err := b.getStreakDisplay(chatID)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	response := t(lang, headerKey) + "\n\n" + t(lang, "participants_header") + "\n\n"
+	for _, p := range participants {
+		status := StatusIcons["pending"]
+		if p.Completed {
+			status = StatusIcons["completed"]
+		} else if p.Frozen {
+			status = StatusIcons["frozen"]
+		} else if p.Skipped {
+			status = StatusIcons["skipped"]
+		}
+		response += fmt.Sprintf("- %s %s (%s)\n\n", status, formatName(lang, p.Name, p.Streak, display), formatStreak(lang, p.Streak, display))
+	}
+
+	// Only the noon reminder carries the quote of the day
+	if headerKey == "reminder" {
+		quotesOn, err := b.getChatSetting(chatID, "quote_of_the_day", "")
+		if err != nil {
+			return tgbotapi.MessageConfig{}, err
+		}
+		if quotesOn == "on" && len(b.quotes) > 0 {
+			response += fmt.Sprintf(t(lang, "quote_of_the_day"), quoteOfTheDay(b.quotes, now))
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	msg.DisableNotification, err = b.silentRemindersAt(chatID, now)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+	return msg, nil
+}
+
+// quoteOfTheDay picks the quote for the calendar day of now. Everyone gets the same
+// quote that day, and the next day moves on to the next quote in the list.
+func quoteOfTheDay(quotes []string, now time.Time) string {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	index := day.Unix() / (24 * 60 * 60) % int64(len(quotes))
+	return quotes[index]
+}
+
+// handleQuote turns the quote of the day in the noon reminder on or off: "/quote on|off"
+func (b *Bot) handleQuote(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		if err := b.setChatSetting(chatID, "quote_of_the_day", "on"); err != nil {
+			return err
+		}
+		text = Messages["quote_on"]
+	case "off":
+		if err := b.setChatSetting(chatID, "quote_of_the_day", ""); err != nil {
+			return err
+		}
+		text = Messages["quote_off"]
+	default:
+		text = Messages["quote_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// silentRemindersAt reports whether reminders to the chat should be sent without sound at now.
+// The silent_reminders setting is "always", or an hour range like "22-8" (end exclusive).
+func (b *Bot) silentRemindersAt(chatID int64, now time.Time) (bool, error) {
+	setting, err := b.getChatSetting(chatID, "silent_reminders", "")
+	if err != nil || setting == "" {
+		return false, err
+	}
+
+	if setting == "always" {
+		return true, nil
+	}
+
+	from, to, ok := parseHourRange(setting)
+	if !ok {
+		b.logger.Warn("invalid silent_reminders setting, ignoring", "chat_id", chatID, "value", setting)
+		return false, nil
+	}
+
+	hour := now.Hour()
+	if from <= to {
+		return hour >= from && hour < to, nil
+	}
+	// The range wraps around midnight
+	return hour >= from || hour < to, nil
+}
+
+// parseHourRange parses "22-8" into its start and end hours
+func parseHourRange(value string) (from, to int, ok bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	from, errFrom := strconv.Atoi(strings.TrimSpace(parts[0]))
+	to, errTo := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if errFrom != nil || errTo != nil || from < 0 || from > 23 || to < 0 || to > 24 {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// handleSilentReminders configures silent reminders: "/silentreminders always|22-8|off"
+func (b *Bot) handleSilentReminders(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	value := strings.TrimSpace(message.CommandArguments())
+
+	var text string
+	switch {
+	case value == "off":
+		if err := b.setChatSetting(chatID, "silent_reminders", ""); err != nil {
+			return err
+		}
+		text = Messages["silent_reminders_off"]
+	case value == "always":
+		if err := b.setChatSetting(chatID, "silent_reminders", value); err != nil {
+			return err
+		}
+		text = Messages["silent_reminders_always"]
+	default:
+		from, to, ok := parseHourRange(value)
+		if !ok {
+			text = Messages["silent_reminders_usage"]
+			break
+		}
+		if err := b.setChatSetting(chatID, "silent_reminders", value); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["silent_reminders_hours"], from, to)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// defaultChatTimezone is the challenge timezone when TIMEZONE isn't set
+const defaultChatTimezone = "Asia/Yekaterinburg"
+
+// challengeLocation is the timezone of chats that haven't set their own, and of the jobs
+// that run for all chats at once, like the digests and the anonymized stats. It's loaded
+// from TIMEZONE at startup. Everything that belongs to a chat or a participant takes
+// "today" from todayIn or userNow instead, so completions, streaks, the scoreboard and
+// reminders agree on the date.
+var challengeLocation = loadChallengeLocation("")
+
+// loadChallengeLocation loads the named timezone. An empty or invalid name falls back to
+// defaultChatTimezone, and that to the server's zone if tzdata is missing.
+func loadChallengeLocation(name string) *time.Location {
+	if name != "" {
+		if loc, err := time.LoadLocation(name); err == nil && name != "Local" {
+			return loc
+		}
+		slog.Warn("invalid timezone, using default", "timezone", name, "default", defaultChatTimezone)
+	}
+
+	loc, err := time.LoadLocation(defaultChatTimezone)
+	if err != nil {
+		slog.Error("failed to load challenge timezone, using server time", "timezone", defaultChatTimezone, "error", err)
+		return time.Local
+	}
+	return loc
+}
+
+// challengeNow is the current time in challengeLocation
+func challengeNow() time.Time {
+	return time.Now().In(challengeLocation)
+}
+
+// Local hours of the noon reminder, the last chance reminder and the Monday weekly summary
+const (
+	reminderHour      = 12
+	lastChanceHour    = 21
+	weeklySummaryHour = 9
+)
+
+// chatLocation returns the chat's timezone, which decides when its reminders
+// and deadline fire and which day its completions count for
+func (b *Bot) chatLocation(chatID int64) (*time.Location, error) {
+	name, err := b.getChatSetting(chatID, "timezone", "")
+	if err != nil || name == "" {
+		return challengeLocation, err
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		b.logger.Warn("invalid timezone setting, using default", "chat_id", chatID, "timezone", name)
+		return challengeLocation, nil
+	}
+	return loc, nil
+}
+
+// todayIn is the current time in the chat's timezone. Its date is the day the chat's
+// participants complete for, matching the chat's reminders and deadline.
+func (b *Bot) todayIn(chatID int64) (time.Time, error) {
+	loc, err := b.chatLocation(chatID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().In(loc), nil
+}
+
+// participantChat is the chat the user joined the challenge from. Their completions,
+// streaks and scoreboard row follow its day. A user who isn't a participant gets their private chat.
+func (b *Bot) participantChat(userID int64) (int64, error) {
+	var chatID int64
+	err := b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return dmChat(userID), nil
+	}
+	return chatID, err
+}
+
+// userNow is the current time in the timezone of the user's chat. Its date is the day
+// the user's completions are stored under, wherever they press the button.
+func (b *Bot) userNow(userID int64) (time.Time, error) {
+	chatID, err := b.participantChat(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return b.todayIn(chatID)
+}
+
+// handleTimezone shows or sets the chat's timezone: "/timezone Europe/Moscow"
+func (b *Bot) handleTimezone(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	name := strings.TrimSpace(message.CommandArguments())
+
+	if name != "" {
+		if ok, err := b.requireChatManager(message); !ok {
+			return err
+		}
+	}
+
+	var text string
+	if name == "" {
+		current, err := b.getChatSetting(chatID, "timezone", challengeLocation.String())
+		if err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["timezone_current"], current)
+	} else if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+		text = Messages["timezone_usage"]
+	} else {
+		if err := b.setChatSetting(chatID, "timezone", name); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["timezone_set"], name, reminderHour, lastChanceHour)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleReminderSettings shows the user's effective reminder schedule: whether reminders
+// reach them at all, when the next ones fire in the chat's timezone, and which are silent
+func (b *Bot) handleReminderSettings(message *tgbotapi.Message) error {
+	userID := message.From.ID
+
+	var chatID int64
+	var inactive bool
+	err := b.db.QueryRow(`SELECT chat_id, inactive_at IS NOT NULL FROM participants WHERE user_id = ?`, userID).Scan(&chatID, &inactive)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_participant"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	onVacation, err := b.isOnVacation(userID)
+	if err != nil {
+		return err
+	}
+	muted, err := b.getUserSetting(userID, "reminders_muted", "")
+	if err != nil {
+		return err
+	}
+
+	loc, err := b.chatLocation(chatID)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().In(loc).Format("2006-01-02")
+	completed, err := b.completedOn(userID, today)
+	if err != nil {
+		return err
+	}
+	var skipped bool
+	err = b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM skipped_days WHERE user_id = ? AND skipped_on = ?)`, userID, today).Scan(&skipped)
+	if err != nil {
+		return err
+	}
+	quietHours, err := b.getChatSetting(chatID, "silent_reminders", "")
+	if err != nil {
+		return err
+	}
+
+	response := Messages["reminder_header"] + "\n\n"
+	switch {
+	case inactive:
+		response += Messages["reminder_inactive"] + "\n"
+	case onVacation:
+		response += Messages["reminder_vacation"] + "\n"
+	case muted != "":
+		response += Messages["reminder_muted"] + "\n"
+	case completed:
+		response += Messages["reminder_done_today"] + "\n"
+	case skipped:
+		response += Messages["reminder_skipped_today"] + "\n"
+	default:
+		response += Messages["reminder_active"] + "\n"
+	}
+
+	response += fmt.Sprintf(Messages["reminder_timezone"], loc.String()) + "\n"
+
+	remindHour, remindMinute, err := b.userRemindAt(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, r := range []struct {
+		Key    string
+		Hour   int
+		Minute int
+	}{{"reminder_next_noon", remindHour, remindMinute}, {"reminder_last_chance", lastChanceHour, 0}} {
+		at := nextReminderAt(now, loc, r.Hour, r.Minute)
+		silent, err := b.silentRemindersAt(chatID, at)
+		if err != nil {
+			return err
+		}
+		line := fmt.Sprintf(Messages[r.Key], at.Format("02.01 15:04"))
+		if silent {
+			line += " " + Messages["reminder_silent"]
+		}
+		response += line + "\n"
+	}
+
+	if quietHours == "" {
+		response += Messages["reminder_quiet_none"] + "\n"
+	} else {
+		response += fmt.Sprintf(Messages["reminder_quiet"], quietHours) + "\n"
+	}
+
+	var weekday, hour int
+	err = b.db.QueryRow(`SELECT weekday, hour FROM digest_subscriptions WHERE user_id = ?`, userID).Scan(&weekday, &hour)
+	switch {
+	case err == sql.ErrNoRows:
+		response += Messages["reminder_digest_none"]
+	case err != nil:
+		return err
+	default:
+		response += fmt.Sprintf(Messages["reminder_digest"], WeekdayName(DefaultLang, time.Weekday(weekday)), hour)
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// nextReminderAt returns the first time after after when the clock in loc strikes hour:minute
+func nextReminderAt(after time.Time, loc *time.Location, hour, minute int) time.Time {
+	local := after.In(loc)
+	at := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !at.After(local) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// reminderDue reports whether hour:minute struck in loc during (from, to]
+func reminderDue(from, to time.Time, loc *time.Location, hour, minute int) bool {
+	return !nextReminderAt(from, loc, hour, minute).After(to)
+}
+
+// parseRemindAt parses a reminder time like "08:30" or "8:30"
+func parseRemindAt(value string) (hour, minute int, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[0]) > 2 || len(parts[1]) != 2 {
+		return 0, 0, false
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	minute, err = strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// userRemindAt returns the local time of the user's daily reminder, noon unless they set their own
+func (b *Bot) userRemindAt(userID int64) (hour, minute int, err error) {
+	value, err := b.getUserSetting(userID, "remind_at", "")
+	if err != nil {
+		return 0, 0, err
+	}
+	if hour, minute, ok := parseRemindAt(value); ok {
+		return hour, minute, nil
+	}
+	return reminderHour, 0, nil
+}
+
+// handleRemindAt sets the time of the user's daily reminder: "/remindat 08:30".
+// "/remindat off" goes back to the default, without arguments the current time is shown.
+func (b *Bot) handleRemindAt(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	var text string
+	switch arg {
+	case "":
+		hour, minute, err := b.userRemindAt(userID)
+		if err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["remind_at_current"], hour, minute)
+	case "off":
+		if err := b.setUserSetting(userID, "remind_at", ""); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["remind_at_reset"], reminderHour)
+	default:
+		hour, minute, ok := parseRemindAt(arg)
+		if !ok {
+			text = Messages["remind_at_usage"]
+			break
+		}
+		if err := b.setUserSetting(userID, "remind_at", fmt.Sprintf("%02d:%02d", hour, minute)); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["remind_at_set"], hour, minute)
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleMute stops the daily and last chance reminders for the user: "/mute".
+// They stay in the list and can complete as usual.
+func (b *Bot) handleMute(message *tgbotapi.Message) error {
+	if err := b.setUserSetting(message.From.ID, "reminders_muted", "on"); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["reminders_muted"])
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleUnmute turns the user's reminders back on: "/unmute"
+func (b *Bot) handleUnmute(message *tgbotapi.Message) error {
+	if err := b.setUserSetting(message.From.ID, "reminders_muted", ""); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["reminders_unmuted"])
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// maxReminderAttempts is how many times a reminder is sent before it's given up on
+const maxReminderAttempts = 5
+
+// queueFailedReminder keeps a reminder that couldn't be sent, so the next scheduler tick retries it.
+// A user who blocked the bot was deactivated by sendMessage and won't be reached by retrying.
+func (b *Bot) queueFailedReminder(msg tgbotapi.MessageConfig, sendErr error) {
+	if isUnreachableUser(sendErr) {
+		return
+	}
+
+	_, err := b.db.Exec(`
+		INSERT INTO failed_reminders (chat_id, text, disable_notification)
+		VALUES (?, ?, ?)
+	`, msg.ChatID, msg.Text, msg.DisableNotification)
+	if err != nil {
+		b.logger.Error("failed to queue reminder for retry", "error", err, "chat_id", msg.ChatID)
+	}
+}
+
+// retryFailedReminders sends the queued reminders again. Delivered ones are removed,
+// the rest are kept for the next pass until they run out of attempts.
+func (b *Bot) retryFailedReminders() error {
+	rows, err := b.db.Query(`SELECT id, chat_id, text, disable_notification, attempts FROM failed_reminders ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	type failedReminder struct {
+		ID       int64
+		Msg      tgbotapi.MessageConfig
+		Attempts int
+	}
+	var queued []failedReminder
+	for rows.Next() {
+		var r failedReminder
+		var chatID int64
+		var text string
+		var silent bool
+		if err := rows.Scan(&r.ID, &chatID, &text, &silent, &r.Attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		r.Msg = tgbotapi.NewMessage(chatID, text)
+		r.Msg.DisableNotification = silent
+		queued = append(queued, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range queued {
+		_, sendErr := b.sendMessageOnce(r.Msg)
+		if sendErr == nil || isUnreachableUser(sendErr) || r.Attempts+1 >= maxReminderAttempts {
+			if sendErr != nil && !isUnreachableUser(sendErr) {
+				b.logger.Warn("giving up on reminder", "chat_id", r.Msg.ChatID, "attempts", r.Attempts+1)
+			}
+			if _, err := b.db.Exec(`DELETE FROM failed_reminders WHERE id = ?`, r.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := b.db.Exec(`UPDATE failed_reminders SET attempts = attempts + 1 WHERE id = ?`, r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// channelScoreboard builds the public scoreboard of the source chat for the channel.
+// Channels can't show reply keyboards, so it's plain text.
+func (b *Bot) channelScoreboard() (tgbotapi.MessageConfig, error) {
+	response, err := b.buildParticipantsList(b.channelSourceChatID, 0)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+	msg := tgbotapi.NewMessage(b.channelID, response)
+	msg.DisableNotification = true
+	return msg, nil
+}
+
+// runDueChannelPost posts the daily scoreboard to the channel if its time fell within (from, to]
+func (b *Bot) runDueChannelPost(from, to time.Time) {
+	if b.channelID == 0 || b.channelSourceChatID == 0 {
+		return
+	}
+
+	loc, err := b.chatLocation(b.channelSourceChatID)
+	if err != nil {
+		b.logger.Error("failed to get chat timezone for channel post", "error", err)
+		return
+	}
+	if !reminderDue(from, to, loc, b.channelPostHour, 0) {
+		return
+	}
+
+	msg, err := b.channelScoreboard()
+	if err != nil {
+		b.logger.Error("failed to build channel scoreboard", "error", err)
+		return
+	}
+	if _, err := b.sendMessage(msg); err != nil {
+		b.logger.Error("failed to post scoreboard to channel", "error", err, "channel_id", b.channelID)
+	}
+}
+
+// activeChats returns the chats that have active participants
+func (b *Bot) activeChats() ([]int64, error) {
+	rows, err := b.db.Query(`SELECT DISTINCT chat_id FROM participants WHERE inactive_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chatIDs []int64
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	return chatIDs, rows.Err()
+}
+
+// runDueReminders retries reminders that failed on earlier ticks, then sends the reminders
+// of every chat whose local reminder time fell within (from, to]
+func (b *Bot) runDueReminders(from, to time.Time) {
+	if err := b.retryFailedReminders(); err != nil {
+		b.logger.Error("failed to retry reminders", "error", err)
+	}
+
+	rows, err := b.db.Query(`SELECT DISTINCT chat_id FROM participants WHERE inactive_at IS NULL`)
+	if err != nil {
+		b.logger.Error("failed to list chats for reminders", "error", err)
+		return
+	}
+	var chats []int64
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			b.logger.Error("error scanning chat", "error", err)
+			continue
+		}
+		chats = append(chats, chatID)
+	}
+	rows.Close()
+
+	for _, chatID := range chats {
+		loc, err := b.chatLocation(chatID)
+		if err != nil {
+			b.logger.Error("failed to get chat timezone", "error", err, "chat_id", chatID)
+			continue
+		}
+
+		if err := b.sendDailyReminders(chatID, from, to, loc); err != nil {
+			b.logger.Error("failed to send daily reminders", "error", err, "chat_id", chatID)
+		}
+		if reminderDue(from, to, loc, lastChanceHour, 0) {
+			if err := b.sendLastChanceReminders(chatID); err != nil {
+				b.logger.Error("failed to send last chance reminders", "error", err, "chat_id", chatID)
+			}
+		}
+		if at := nextReminderAt(from, loc, weeklySummaryHour, 0); !at.After(to) && at.Weekday() == time.Monday {
+			if err := b.sendWeeklySummary(chatID, at); err != nil {
+				b.logger.Error("failed to send weekly summary", "error", err, "chat_id", chatID)
+			}
+		}
+	}
+}
+
+// sendWeeklySummary posts the recap of the 7 days before now to the chat: how many days
+// each participant completed, who had a perfect week and the group streak. Someone who
+// joined mid-week is only counted from the day they joined.
+func (b *Bot) sendWeeklySummary(chatID int64, now time.Time) error {
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+
+	weekStart := now.AddDate(0, 0, -7).Format("2006-01-02")
+	weekEnd := now.AddDate(0, 0, -1).Format("2006-01-02")
+	end, err := time.Parse("2006-01-02", weekEnd)
+	if err != nil {
+		return err
+	}
+
+	rows, err := b.db.Query(`
+		SELECT
+			COALESCE(p.display_name, p.username),
+			MAX(date(p.joined_at), ?) AS counted_from,
+			(SELECT COUNT(*) FROM daily_completions dc
+				WHERE dc.user_id = p.user_id
+				AND dc.completed_at >= MAX(date(p.joined_at), ?) AND dc.completed_at <= ?)
+		FROM participants p
+		WHERE p.chat_id = ? AND p.inactive_at IS NULL AND date(p.joined_at) <= ?
+		ORDER BY 3 DESC, p.joined_at
+	`, weekStart, weekStart, weekEnd, chatID, weekEnd)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var lines, perfect []string
+	for rows.Next() {
+		var name, countedFrom string
+		var completed int
+		if err := rows.Scan(&name, &countedFrom, &completed); err != nil {
+			return err
+		}
+		from, err := time.Parse("2006-01-02", countedFrom)
+		if err != nil {
+			return err
+		}
+		days := int(end.Sub(from).Hours()/24) + 1
+
+		lines = append(lines, fmt.Sprintf(t(lang, "weekly_summary_line"), name, completed, days))
+		if completed >= days {
+			perfect = append(perfect, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	streak, err := b.getConsecutiveCompletionDays(chatID)
+	if err != nil {
+		return err
+	}
+
+	response := fmt.Sprintf(t(lang, "weekly_summary_header"),
+		now.AddDate(0, 0, -7).Format("02.01"), now.AddDate(0, 0, -1).Format("02.01"),
+	) + "\n\n" + strings.Join(lines, "\n") + "\n\n"
+	if len(perfect) > 0 {
+		response += fmt.Sprintf(t(lang, "weekly_summary_perfect"), strings.Join(perfect, ", ")) + "\n"
+	} else {
+		response += t(lang, "weekly_summary_no_perfect") + "\n"
+	}
+	response += fmt.Sprintf(t(lang, "group_streak"), streak)
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// sendDailyReminders reminds the chat's participants whose reminder time in loc struck
+// during (from, to] and who haven't completed today
+func (b *Bot) sendDailyReminders(chatID int64, from, to time.Time, loc *time.Location) error {
+	// The chat's own date, the one its completions are stored under
+	today := to.In(loc).Format("2006-01-02")
+
+	userIDs, err := b.reminderCandidates(chatID, today)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		hour, minute, err := b.userRemindAt(userID)
+		if err != nil {
+			b.logger.Error("error getting reminder time", "user_id", userID, "error", err)
+			continue
+		}
+		if !reminderDue(from, to, loc, hour, minute) {
+			continue
+		}
+
+		b.remindIfPending(chatID, userID, today, "reminder")
+	}
+	return nil
+}
+
+// reminderCandidates returns the chat's participants who haven't completed on today
+// (YYYY-MM-DD) and aren't excused from the reminder by a vacation, skip, freeze or mute
+func (b *Bot) reminderCandidates(chatID int64, today string) ([]int64, error) {
+	rows, err := b.db.Query(`
+		SELECT p.user_id 
+		FROM participants p
+		LEFT JOIN daily_completions dc 
+			ON p.user_id = dc.user_id 
+			AND dc.completed_at = ?
+		WHERE dc.user_id IS NULL
+			AND p.chat_id = ?
+			AND p.inactive_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM vacations v WHERE v.user_id = p.user_id AND v.ended_at IS NULL)
+			AND NOT EXISTS (SELECT 1 FROM skipped_days s WHERE s.user_id = p.user_id AND s.skipped_on = ?)
+			AND NOT EXISTS (SELECT 1 FROM streak_freezes f WHERE f.user_id = p.user_id AND f.frozen_on = ?)
+			AND NOT EXISTS (SELECT 1 FROM user_settings us WHERE us.user_id = p.user_id AND us.key = 'reminders_muted')
+	`, today, chatID, today, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// remindIfPending sends the reminder selected by headerKey to the chat unless the user completed
+// on today (YYYY-MM-DD) since the candidates were queried. A failed send is queued for a retry.
+func (b *Bot) remindIfPending(chatID, userID int64, today, headerKey string) {
+	// The loop is slow, the user may have completed since the query
+	completed, err := b.completedOn(userID, today)
+	if err != nil {
+		b.logger.Error("error checking completion before reminder", "user_id", userID, "error", err)
+		return
+	}
+	if completed {
+		return
+	}
+
+	msg, err := b.buildReminder(chatID, headerKey, time.Now())
+	if err != nil {
+		b.logger.Error("error building reminder", "error", err)
+		return
+	}
+
+	if _, err := b.sendMessageOnce(msg); err != nil {
+		b.logger.Error("error sending reminder",
+			"user_id", userID,
+			"reminder", headerKey,
+			"error", err,
+		)
+		b.queueFailedReminder(msg, err)
+	}
+}
+
+// getAtRiskUsers returns participants who haven't completed today and whose streak
+// is at least minStreak, ordered by streak so those with the most to lose come first
+func (b *Bot) getAtRiskUsers(minStreak int) ([]struct {
+	UserID int64
+	ChatID int64
+	Name   string
+	Streak int
+}, error) {
+	chatIDs, err := b.activeChats()
+	if err != nil {
+		return nil, err
+	}
+
+	var atRisk []struct {
+		UserID int64
+		ChatID int64
+		Name   string
+		Streak int
+	}
+	// Each chat's participants are pending on that chat's today
+	for _, chatID := range chatIDs {
+		now, err := b.todayIn(chatID)
+		if err != nil {
+			return nil, err
+		}
+		today := now.Format("2006-01-02")
+
+		rows, err := b.db.Query(`
+			SELECT p.user_id, p.chat_id, COALESCE(p.display_name, p.username)
+			FROM participants p
+			LEFT JOIN daily_completions dc 
+				ON p.user_id = dc.user_id 
+				AND dc.completed_at = ?
+			WHERE dc.user_id IS NULL
+				AND p.chat_id = ?
+				AND p.inactive_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM vacations v WHERE v.user_id = p.user_id AND v.ended_at IS NULL)
+				AND NOT EXISTS (SELECT 1 FROM skipped_days s WHERE s.user_id = p.user_id AND s.skipped_on = ?)
+				AND NOT EXISTS (SELECT 1 FROM streak_freezes f WHERE f.user_id = p.user_id AND f.frozen_on = ?)
+				AND NOT EXISTS (SELECT 1 FROM user_settings us WHERE us.user_id = p.user_id AND us.key = 'reminders_muted')
+		`, today, chatID, today, today)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var u struct {
+				UserID int64
+				ChatID int64
+				Name   string
+				Streak int
+			}
+			if err := rows.Scan(&u.UserID, &u.ChatID, &u.Name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			atRisk = append(atRisk, u)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	filtered := atRisk[:0]
+	for _, u := range atRisk {
+		u.Streak, err = b.getIndividualStreak(u.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if u.Streak >= minStreak {
+			filtered = append(filtered, u)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Streak > filtered[j].Streak
+	})
+
+	return filtered, nil
+}
+
+// handleRiskRemind reminds only users whose streak is at risk of being lost today
+func (b *Bot) handleRiskRemind(message *tgbotapi.Message) error {
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	atRisk, err := b.getAtRiskUsers(b.riskRemindMinStreak)
+	if err != nil {
+		return err
+	}
+
+	reminded := 0
+	for _, u := range atRisk {
+		lang, err := b.chatLang(u.ChatID)
+		if err != nil {
+			return err
+		}
+
+		msg := tgbotapi.NewMessage(u.ChatID, fmt.Sprintf(t(lang, "risk_reminder"), u.Name, u.Streak, DayWord(lang, u.Streak)))
+		msg.DisableNotification, err = b.silentRemindersAt(u.ChatID, time.Now())
+		if err != nil {
+			return err
+		}
+		if _, err := b.sendMessage(msg); err != nil {
+			b.logger.Error("error sending risk reminder",
+				"user_id", u.UserID,
+				"error", err,
+			)
+			continue
+		}
+		reminded++
+	}
+
+	lang, err := b.chatLang(message.Chat.ID)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(t(lang, "risk_remind_done"), reminded))
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// groupStreakMilestones are the shared streak lengths the group counts down to
+var groupStreakMilestones = []int{30, 100, 365}
+
+// groupCountdownWindow is how many days before a milestone the countdown appears
+const groupCountdownWindow = 7
+
+// groupCountdown returns the next group streak milestone and the days left to it,
+// if the streak is close enough for a countdown
+func groupCountdown(streak int) (target, left int, ok bool) {
+	for _, m := range groupStreakMilestones {
+		if m > streak {
+			return m, m - streak, m-streak <= groupCountdownWindow
+		}
+	}
+	return 0, 0, false
+}
+
+// updateGroupCountdown keeps the chat's pinned countdown to the next group streak milestone
+// in sync with the group streak. It's pinned once the milestone is near, edited as the streak
+// moves, and turned into a celebration and unpinned once reached. The countdown is removed
+// if the streak breaks before that.
+func (b *Bot) updateGroupCountdown(chatID int64) error {
+	enabled, err := b.getChatSetting(chatID, "group_countdown", "")
+	if err != nil || enabled != "on" {
+		return err
+	}
+
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+
+	streak, err := b.getConsecutiveCompletionDays(chatID)
+	if err != nil {
+		return err
+	}
+
+	storedID, err := b.getChatSetting(chatID, "countdown_message_id", "")
+	if err != nil {
+		return err
+	}
+	messageID, _ := strconv.Atoi(storedID)
+	storedTarget, err := b.getChatSetting(chatID, "countdown_target", "")
+	if err != nil {
+		return err
+	}
+	pinnedTarget, _ := strconv.Atoi(storedTarget)
+
+	// Reached: celebrate in the countdown itself and let it go
+	if messageID != 0 && streak >= pinnedTarget {
+		edit := tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf(t(lang, "countdown_reached"), pinnedTarget, DayWord(lang, pinnedTarget)))
+		if _, err := b.send(edit); err != nil {
+			return err
+		}
+		return b.clearGroupCountdown(chatID, messageID)
+	}
+
+	target, left, ok := groupCountdown(streak)
+	if !ok {
+		if messageID != 0 {
+			return b.clearGroupCountdown(chatID, messageID)
+		}
+		return nil
+	}
+
+	text := fmt.Sprintf(t(lang, "countdown"), left, DayWord(lang, left), target)
+	if messageID != 0 && target == pinnedTarget {
+		storedText, err := b.getChatSetting(chatID, "countdown_text", "")
+		if err != nil || storedText == text {
+			return err
+		}
+		edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+		if _, err := b.send(edit); err != nil {
+			return err
+		}
+		return b.setChatSetting(chatID, "countdown_text", text)
+	}
+
+	if messageID != 0 {
+		if err := b.clearGroupCountdown(chatID, messageID); err != nil {
+			return err
+		}
+	}
+
+	sent, err := b.sendMessage(tgbotapi.NewMessage(chatID, text))
+	if err != nil {
+		return err
+	}
+	pin := tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: sent.MessageID, DisableNotification: true}
+	if _, err := b.api.Request(pin); err != nil {
+		b.logger.Error("failed to pin group countdown", "error", err, "chat_id", chatID)
+	}
+
+	if err := b.setChatSetting(chatID, "countdown_message_id", strconv.Itoa(sent.MessageID)); err != nil {
+		return err
+	}
+	if err := b.setChatSetting(chatID, "countdown_target", strconv.Itoa(target)); err != nil {
+		return err
+	}
+	return b.setChatSetting(chatID, "countdown_text", text)
+}
+
+// clearGroupCountdown unpins the countdown message and forgets it
+func (b *Bot) clearGroupCountdown(chatID int64, messageID int) error {
+	unpin := tgbotapi.UnpinChatMessageConfig{ChatID: chatID, MessageID: messageID}
+	if _, err := b.api.Request(unpin); err != nil {
+		b.logger.Error("failed to unpin group countdown", "error", err, "chat_id", chatID)
+	}
+
+	for _, key := range []string{"countdown_message_id", "countdown_target", "countdown_text"} {
+		if err := b.setChatSetting(chatID, key, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleCountdown turns the pinned group streak countdown on or off: "/countdown on|off"
+func (b *Bot) handleCountdown(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		if err := b.setChatSetting(chatID, "group_countdown", "on"); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["countdown_on"], groupCountdownWindow)
+	case "off":
+		if err := b.setChatSetting(chatID, "group_countdown", ""); err != nil {
+			return err
+		}
+		storedID, err := b.getChatSetting(chatID, "countdown_message_id", "")
+		if err != nil {
+			return err
+		}
+		if messageID, _ := strconv.Atoi(storedID); messageID != 0 {
+			if err := b.clearGroupCountdown(chatID, messageID); err != nil {
+				return err
+			}
+		}
+		text = Messages["countdown_off"]
+	default:
+		text = Messages["countdown_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
+	}
+
+	// The group may already be close to a milestone
+	return b.updateGroupCountdown(chatID)
+}
+
+// comboThresholds are the group streak lengths at which the combo celebration
+// gets one more flame
+var comboThresholds = []int{3, 5, 10, 30, 100}
+
+// comboIntensity returns how many flames a group streak earns, 0 below the first threshold
+func comboIntensity(streak int) int {
+	intensity := 0
+	for _, threshold := range comboThresholds {
+		if streak >= threshold {
+			intensity++
+		}
+	}
+	return intensity
+}
+
+// comboLine celebrates several perfect group days in a row, louder the longer the run.
+// Empty while the run is too short.
+func comboLine(lang string, streak int) string {
+	intensity := comboIntensity(streak)
+	if intensity == 0 {
+		return ""
+	}
+	return fmt.Sprintf(t(lang, "group_combo"), strings.Repeat("🔥", intensity), streak, DayWord(lang, streak))
+}
+
+// groupDayKept reports whether a day with completed out of total participants keeps
+// the group streak, given the share of participants (in percent) the chat requires
+func groupDayKept(completed, total, thresholdPercent int) bool {
+	return total > 0 && completed*100 >= total*thresholdPercent
+}
+
+// getConsecutiveCompletionDays returns the group streak: consecutive days on which enough
+// participants completed. By default that's everyone, the chat's group_streak_threshold
+// can lower it to a percentage for large groups.
+func (b *Bot) getConsecutiveCompletionDays(chatID int64) (int, error) {
+	threshold, err := b.positiveIntSetting(chatID, "group_streak_threshold")
+	if err != nil {
+		return 0, err
+	}
+	if threshold == 0 || threshold > 100 {
+		threshold = 100
+	}
+
+	now, err := b.todayIn(chatID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Start from yesterday and go backwards to get the base streak
+	currentDate := now.AddDate(0, 0, -1)
+	consecutiveDays := 0
+
+	// Get base streak (not including today)
+	for {
+		completedCount, totalParticipants, err := b.groupDayCounts(chatID, currentDate.Format("2006-01-02"))
+		if err != nil {
+			return 0, err
+		}
+
+		if !groupDayKept(completedCount, totalParticipants, threshold) {
+			break
+		}
+
+		consecutiveDays++
+		currentDate = currentDate.AddDate(0, 0, -1)
+	}
+
+	// Add today to streak if enough participants completed
+	todayCompletedCount, totalParticipants, err := b.groupDayCounts(chatID, now.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	if groupDayKept(todayCompletedCount, totalParticipants, threshold) {
+		consecutiveDays++
+	}
+
+	return consecutiveDays, nil
+}
+
+// celebrateAllCompleted tells the chat once all its participants have completed on date
+// (YYYY-MM-DD). It's recorded, so the chat hears it once per day however often the
+// completions around it are re-checked.
+func (b *Bot) celebrateAllCompleted(chatID int64, date string) error {
+	completed, total, err := b.groupDayCounts(chatID, date)
+	if err != nil {
+		return err
+	}
+	if total == 0 || completed < total {
+		return nil
+	}
+	return b.celebrateInChat(chatID, date)
+}
+
+// celebrateInChat sends the all-completed celebration to one chat, unless it already got it for date
+func (b *Bot) celebrateInChat(chatID int64, date string) error {
+	res, err := b.db.Exec(`INSERT OR IGNORE INTO group_celebrations (chat_id, date) VALUES (?, ?)`, chatID, date)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+	now, err := b.todayIn(chatID)
+	if err != nil {
+		return err
+	}
+	key := "all_completed_today"
+	if date != now.Format("2006-01-02") {
+		key = "all_completed_yesterday"
+	}
+	msg := tgbotapi.NewMessage(chatID, t(lang, key))
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// groupDayCounts returns how many of the chat's participants completed on date (YYYY-MM-DD)
+// out of those who had joined by then. joined_at is a timestamp, so it's compared by its date: someone
+// who joined that day counts for it. Completions dated before a participant joined
+// (imported or backfilled history) never count for the group, so they can neither
+// extend nor break days the participant wasn't part of. Inactive participants and those
+// who left are hidden from the list and never reminded, so they don't count either.
+func (b *Bot) groupDayCounts(chatID int64, date string) (completed, total int, err error) {
+	err = b.db.QueryRow(`
+		SELECT COUNT(DISTINCT dc.user_id)
+		FROM daily_completions dc
+		JOIN participants p ON p.user_id = dc.user_id
+		WHERE dc.completed_at = ? AND date(p.joined_at) <= dc.completed_at
+		  AND p.chat_id = ? AND p.inactive_at IS NULL AND p.left_at IS NULL
+	`, date, chatID).Scan(&completed)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = b.db.QueryRow(`
+		SELECT COUNT(*) 
+		FROM participants 
+		WHERE date(joined_at) <= ? AND chat_id = ? AND inactive_at IS NULL AND left_at IS NULL
+	`, date, chatID).Scan(&total)
+	return completed, total, err
+}
+
+// handleDigest manages the private weekly digest: "/digest пн 9" subscribes, "/digest off" unsubscribes
+func (b *Bot) handleDigest(message *tgbotapi.Message) error {
+	args := strings.Fields(strings.ToLower(message.CommandArguments()))
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	var isParticipant bool
+	err := b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM participants WHERE user_id = ?)`, userID).Scan(&isParticipant)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
+	var text string
+	switch {
+	case len(args) == 1 && args[0] == "off":
+		if _, err := b.db.Exec(`DELETE FROM digest_subscriptions WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		text = Messages["digest_off"]
+	case len(args) == 2:
+		weekday, okDay := WeekdayShortNames[args[0]]
+		hour, errHour := strconv.Atoi(args[1])
+		if !okDay || errHour != nil || hour < 0 || hour > 23 {
+			text = Messages["digest_usage"]
+			break
+		}
+
+		_, err := b.db.Exec(`
+			INSERT OR REPLACE INTO digest_subscriptions (user_id, weekday, hour)
+			VALUES (?, ?, ?)
+		`, userID, int(weekday), hour)
+		if err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["digest_on"], WeekdayNames[weekday.String()], hour)
+	default:
+		text = Messages["digest_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// sendPersonalDigests privately sends the weekly digest to users subscribed for this weekday and hour
+func (b *Bot) sendPersonalDigests(now time.Time) error {
+	today := now.Format("2006-01-02")
+
+	rows, err := b.db.Query(`
+		SELECT user_id FROM digest_subscriptions
+		WHERE weekday = ? AND hour = ? AND (last_sent IS NULL OR last_sent != ?)
+			AND user_id NOT IN (SELECT user_id FROM participants WHERE inactive_at IS NOT NULL)
+	`, int(now.Weekday()), now.Hour(), today)
+	if err != nil {
+		return err
+	}
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		// The week counted is the one of the user's chat, like their completions
+		userToday, err := b.userNow(userID)
+		if err != nil {
+			b.logger.Error("error getting the user's today for digest", "user_id", userID, "error", err)
+			continue
+		}
+
+		var completedDays int
+		err = b.db.QueryRow(`
+			SELECT COUNT(*) FROM daily_completions
+			WHERE user_id = ? AND completed_at >= ? AND completed_at <= ?
+		`, userID, userToday.AddDate(0, 0, -6).Format("2006-01-02"), userToday.Format("2006-01-02")).Scan(&completedDays)
+		if err != nil {
+			b.logger.Error("error counting weekly completions", "user_id", userID, "error", err)
+			continue
+		}
+
+		streak, err := b.getIndividualStreak(userID)
+		if err != nil {
+			b.logger.Error("error getting streak for digest", "user_id", userID, "error", err)
+			continue
+		}
+
+		// The digest is a DM, so the user's own language wins
+		lang, err := b.resolveLang(userID, userID)
+		if err != nil {
+			b.logger.Error("error resolving language for digest", "user_id", userID, "error", err)
+			lang = DefaultLang
+		}
+
+		encouragement := t(lang, "digest_encourage_low")
+		if completedDays == 7 {
+			encouragement = t(lang, "digest_encourage_perfect")
+		} else if completedDays >= 4 {
+			encouragement = t(lang, "digest_encourage_good")
+		}
+
+		text := fmt.Sprintf(t(lang, "digest"), completedDays, streak, DayWord(lang, streak), encouragement)
+
+		msg := tgbotapi.NewMessage(dmChat(userID), text)
+		if _, err := b.sendMessage(msg); err != nil {
+			b.logger.Error("error sending personal digest", "user_id", userID, "error", err)
+			continue
+		}
+
+		_, err = b.db.Exec(`UPDATE digest_subscriptions SET last_sent = ? WHERE user_id = ?`, today, userID)
+		if err != nil {
+			b.logger.Error("error saving digest send date", "user_id", userID, "error", err)
+		}
+	}
+	return nil
+}
+
+// groupCompletionRate returns the share of the chat's participant-days completed between
+// from and to inclusive. A participant counts from the day they joined. ok is false when
+// nobody was participating in the period.
+func (b *Bot) groupCompletionRate(chatID int64, from, to time.Time) (rate float64, ok bool, err error) {
+	fromStr := from.Format("2006-01-02")
+	toStr := to.Format("2006-01-02")
+
+	var completed int
+	err = b.db.QueryRow(`
+		SELECT COUNT(*) 
+		FROM daily_completions dc
+		JOIN participants p ON p.user_id = dc.user_id
+		WHERE dc.completed_at >= ? AND dc.completed_at <= ?
+			AND date(p.joined_at) <= dc.completed_at AND p.chat_id = ?
+	`, fromStr, toStr, chatID).Scan(&completed)
+	if err != nil {
+		return 0, false, err
+	}
+
+	rows, err := b.db.Query(`
+		SELECT date(joined_at), COUNT(*) 
+		FROM participants 
+		WHERE date(joined_at) <= ? AND chat_id = ?
+		GROUP BY date(joined_at)
+	`, toStr, chatID)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	participantDays := 0
+	for rows.Next() {
+		var joined string
+		var count int
+		if err := rows.Scan(&joined, &count); err != nil {
+			return 0, false, err
+		}
+
+		start := fromStr
+		if joined > start {
+			start = joined
+		}
+		startDate, err := time.Parse("2006-01-02", start)
+		if err != nil {
+			return 0, false, err
+		}
+		endDate, _ := time.Parse("2006-01-02", toStr)
+		days := int(endDate.Sub(startDate).Hours()/24) + 1
+		participantDays += days * count
+	}
+	if err := rows.Err(); err != nil {
+		return 0, false, err
+	}
+
+	if participantDays == 0 {
+		return 0, false, nil
+	}
+	return float64(completed) / float64(participantDays), true, nil
+}
+
+// rateTrend returns the change between two completion rates in percentage points
+// and an arrow showing its direction
+func rateTrend(current, previous float64) (delta float64, arrow string) {
+	delta = (current - previous) * 100
+	switch {
+	case delta >= 0.5:
+		return delta, "⬆️"
+	case delta <= -0.5:
+		return delta, "⬇️"
+	default:
+		return delta, "➡️"
+	}
+}
+
+// handleCompare compares this week's group completion rate with last week's: "/compare week"
+func (b *Bot) handleCompare(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if strings.TrimSpace(message.CommandArguments()) != "week" {
+		msg := tgbotapi.NewMessage(chatID, Messages["compare_usage"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	boardChat, err := b.scoreboardChat(chatID, message.From.ID)
+	if err != nil {
+		return err
+	}
+	now, err := b.todayIn(boardChat)
+	if err != nil {
+		return err
+	}
+	// Weeks start on Monday
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	thisMonday := now.AddDate(0, 0, -daysSinceMonday)
+	lastMonday := thisMonday.AddDate(0, 0, -7)
+	lastSunday := thisMonday.AddDate(0, 0, -1)
+
+	current, ok, err := b.groupCompletionRate(boardChat, thisMonday, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, Messages["compare_no_data"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	response := fmt.Sprintf(Messages["compare_this_week"], current*100)
+	previous, ok, err := b.groupCompletionRate(boardChat, lastMonday, lastSunday)
+	if err != nil {
+		return err
+	}
+	if ok {
+		delta, arrow := rateTrend(current, previous)
+		response += "\n" + fmt.Sprintf(Messages["compare_last_week"], previous*100)
+		response += "\n\n" + fmt.Sprintf(Messages["compare_delta"], arrow, delta)
+	} else {
+		response += "\n\n" + Messages["compare_first_week"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// teamChatStats holds one chat's contribution to its team
+type teamChatStats struct {
+	ChatID      int64
+	Title       string
+	Completions int
+	Streaks     int
+}
+
+// getTeamStats sums completions and current individual streaks for every chat in the team
+func (b *Bot) getTeamStats(teamID int64) ([]teamChatStats, error) {
+	rows, err := b.db.Query(`
+		SELECT tm.chat_id, COALESCE(tm.chat_title, ''), 
+			(SELECT COUNT(*) FROM daily_completions dc WHERE dc.chat_id = tm.chat_id)
+		FROM team_members tm
+		WHERE tm.team_id = ?
+		ORDER BY tm.chat_id
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	var stats []teamChatStats
+	for rows.Next() {
+		var cs teamChatStats
+		if err := rows.Scan(&cs.ChatID, &cs.Title, &cs.Completions); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats = append(stats, cs)
+	}
+	rows.Close()
+
+	for i := range stats {
+		rows, err := b.db.Query(`SELECT user_id FROM participants WHERE chat_id = ?`, stats[i].ChatID)
+		if err != nil {
+			return nil, err
+		}
+		var userIDs []int64
+		for rows.Next() {
+			var userID int64
+			if err := rows.Scan(&userID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			userIDs = append(userIDs, userID)
+		}
+		rows.Close()
+
+		for _, userID := range userIDs {
+			streak, err := b.getIndividualStreak(userID)
+			if err != nil {
+				return nil, err
+			}
+			stats[i].Streaks += streak
+		}
+	}
+	return stats, nil
+}
+
+// handleTeam adds the chat to a cross-chat team or removes it: "/team join название" or "/team leave"
+func (b *Bot) handleTeam(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	args := strings.SplitN(strings.TrimSpace(message.CommandArguments()), " ", 2)
+
+	var text string
+	switch {
+	case len(args) == 1 && args[0] == "leave":
+		if _, err := b.db.Exec(`DELETE FROM team_members WHERE chat_id = ?`, chatID); err != nil {
+			return err
+		}
+		text = Messages["team_left"]
+	case len(args) == 2 && args[0] == "join" && strings.TrimSpace(args[1]) != "":
+		name := strings.TrimSpace(args[1])
+		if _, err := b.db.Exec(`INSERT OR IGNORE INTO teams (name) VALUES (?)`, name); err != nil {
+			return err
+		}
+		var teamID int64
+		if err := b.db.QueryRow(`SELECT id, name FROM teams WHERE name = ?`, name).Scan(&teamID, &name); err != nil {
+			return err
+		}
+
+		title := message.Chat.Title
+		if title == "" {
+			title = fmt.Sprintf("ID: %d", chatID)
+		}
+		_, err := b.db.Exec(`
+			INSERT OR REPLACE INTO team_members (chat_id, team_id, chat_title)
+			VALUES (?, ?, ?)
+		`, chatID, teamID, title)
+		if err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["team_joined"], name)
+	default:
+		text = Messages["team_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleTeamStats shows completions and combined streaks across the chats of this chat's team
+func (b *Bot) handleTeamStats(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+
+	var teamID int64
+	var teamName string
+	err := b.db.QueryRow(`
+		SELECT t.id, t.name FROM team_members tm
+		JOIN teams t ON t.id = tm.team_id
+		WHERE tm.chat_id = ?
+	`, chatID).Scan(&teamID, &teamName)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, Messages["team_none"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	stats, err := b.getTeamStats(teamID)
+	if err != nil {
+		return err
+	}
+
+	response := fmt.Sprintf(Messages["team_stats_header"], teamName) + "\n\n"
+	totalCompletions, totalStreaks := 0, 0
+	for _, cs := range stats {
+		response += fmt.Sprintf(Messages["team_stats_chat"], cs.Title, cs.Completions, cs.Streaks) + "\n"
+		totalCompletions += cs.Completions
+		totalStreaks += cs.Streaks
+	}
+	response += "\n" + fmt.Sprintf(Messages["team_stats_total"], totalCompletions, totalStreaks)
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// TestFillCompletions fills in completion records for the specified number of days
+// If notEveryoneCompletes is true, it will randomly skip some completions
+func (b *Bot) TestFillCompletions(days int, notEveryoneCompletes bool) error {
+	// Get all participants
+	rows, err := b.db.Query(`SELECT user_id FROM participants`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var participants []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return err
+		}
+		participants = append(participants, userID)
+	}
+
+	// Fill completions for each day, up to each participant's own today
+	for _, userID := range participants {
+		now, err := b.userNow(userID)
+		if err != nil {
+			return err
+		}
+
+		for i := days - 1; i >= 0; i-- {
+			date := now.AddDate(0, 0, -i).Format("2006-01-02")
+
+			// If notEveryoneCompletes is true, randomly skip some completions
+			if notEveryoneCompletes && rand.Float32() < 0.3 { // 30% chance to skip
+				continue
+			}
+
+			congratsMessage := getRandomCongratsMessage(congratsModeToday)
+			_, err = b.db.Exec(`
+				INSERT OR REPLACE INTO daily_completions (user_id, completed_at, congrats_message)
+				VALUES (?, ?, ?)
+			`, userID, date, congratsMessage)
+			if err != nil {
+				return err
+			}
+		}
+		b.invalidateUserParticipantsCache(userID)
+	}
+
+	return nil
+}
+
+// errStreakTooLong is returned when a streak above maxStreakDays is requested
+var errStreakTooLong = errors.New("streak is longer than allowed")
+
+// errNegativeStreak is returned when a negative streak is requested
+var errNegativeStreak = errors.New("streak can't be negative")
+
+// SetUserStreak sets a specific streak for a user by filling in completion records
+// for consecutive days leading up to today. The change and its audit record are
+// written in one transaction, so an admin edit never goes untracked.
+// Negative streaks and streaks over maxStreakDays are refused before anything is written.
+func (b *Bot) SetUserStreak(adminID, userID int64, streakDays int) error {
+	if streakDays < 0 {
+		return fmt.Errorf("%w: %d", errNegativeStreak, streakDays)
+	}
+	if streakDays > b.maxStreakDays {
+		return fmt.Errorf("%w: %d > %d", errStreakTooLong, streakDays, b.maxStreakDays)
+	}
+
+	// First, check if the user exists
+	var exists bool
+	err := b.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM participants 
+			WHERE user_id = ?
+		)
+	`, userID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("user with ID %d does not exist", userID)
+	}
+
+	oldStreak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Clear existing streak data first to avoid conflicts
+	_, err = tx.Exec(`
+		DELETE FROM daily_completions 
+		WHERE user_id = ? AND completed_at >= ? AND completed_at <= ?
+	`, userID, now.AddDate(0, 0, -streakDays).Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	// Fill completions for each day in the streak
+	for i := streakDays - 1; i >= 0; i-- {
+		date := now.AddDate(0, 0, -i).Format("2006-01-02")
+		congratsMessage := getRandomCongratsMessage(congratsModeToday)
+
+		if _, err := b.insertCompletion(tx, userID, date, congratsMessage, ""); err != nil {
+			return err
+		}
+	}
+
+	if err := writeAudit(tx, adminID, "set_streak", userID, oldStreak, streakDays); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.invalidateUserParticipantsCache(userID)
+
+	b.logger.Info("admin set streak",
+		"admin_id", adminID,
+		"user_id", userID,
+		"old_streak", oldStreak,
+		"new_streak", streakDays,
+	)
+
+	// Check for achievements after setting the streak
+	streak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		return err
+	}
+
+	return b.checkAndRecordAchievements(userID, streak)
+}
+
+// countCompletions returns how many completions the user has, through q. Admin edits
+// that add completions rather than set a streak audit it before and after the change.
+func countCompletions(q querier, userID int64) (int, error) {
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM daily_completions WHERE user_id = ?`, userID).Scan(&count)
+	return count, err
+}
+
+// writeAudit records a change to user data in the audit log, within the change's transaction
+func writeAudit(tx *sql.Tx, actorID int64, action string, targetID int64, oldValue, newValue int) error {
+	_, err := tx.Exec(`
+		INSERT INTO admin_audit (admin_id, action, target_user_id, old_value, new_value)
+		VALUES (?, ?, ?, ?, ?)
+	`, actorID, action, targetID, oldValue, newValue)
+	return err
+}
+
+// importStreak fills the days before today with completions tagged 'imported', so a streak
+// built in another app carries over. Existing completions are kept. Returns the new streak.
+func (b *Bot) importStreak(userID int64, days int) (int, error) {
+	oldStreak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return 0, err
+	}
+	for i := 1; i <= days; i++ {
+		date := now.AddDate(0, 0, -i).Format("2006-01-02")
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO daily_completions (user_id, completed_at, congrats_message, chat_id, source)
+			VALUES (?, ?, ?, (SELECT chat_id FROM participants WHERE user_id = ?), 'imported')
+		`, userID, date, Messages["import_congrats"], userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	newStreak, err := b.individualStreak(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeAudit(tx, userID, "import_streak", userID, oldStreak, newStreak); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO user_settings (user_id, key, value)
+		VALUES (?, 'streak_imported', ?)
+	`, userID, strconv.Itoa(days))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	b.invalidateUserParticipantsCache(userID)
+
+	b.logger.Info("user imported streak", "user_id", userID, "days", days, "old_streak", oldStreak, "new_streak", newStreak)
+	return newStreak, nil
+}
+
+// handleImportStreak lets a user carry over a streak from another app: "/importstreak 40".
+// The chat must allow imports, the number of days is capped, and it works once per user.
+func (b *Bot) handleImportStreak(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+
+	lang, err := b.resolveLang(userID, chatID)
+	if err != nil {
+		return err
+	}
+
+	var participantChatID int64
+	err = b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&participantChatID)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, t(lang, "not_participant"))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	maxDays, err := b.positiveIntSetting(participantChatID, "import_max_days")
+	if err != nil {
+		return err
+	}
+
+	imported, err := b.getUserSetting(userID, "streak_imported", "")
+	if err != nil {
+		return err
+	}
+
+	days, errDays := strconv.Atoi(strings.TrimSpace(message.CommandArguments()))
+
+	var text string
+	switch {
+	case maxDays == 0:
+		text = t(lang, "import_disabled")
+	case imported != "":
+		text = t(lang, "import_already_done")
+	case errDays != nil || days < 1:
+		text = fmt.Sprintf(t(lang, "import_usage"), maxDays)
+	case days > maxDays:
+		text = fmt.Sprintf(t(lang, "import_too_many"), maxDays, DayWord(lang, maxDays))
+	default:
+		streak, err := b.importStreak(userID, days)
+		if err != nil {
+			return err
+		}
+		if err := b.checkAndRecordAchievements(userID, streak); err != nil {
+			b.logger.Error("failed to check achievements after import", "error", err, "user_id", userID)
+		}
+		if err := b.updateStreakRecord(userID); err != nil {
+			b.logger.Error("failed to update streak record after import", "error", err, "user_id", userID)
+		}
+		text = fmt.Sprintf(t(lang, "import_done"), days, DayWord(lang, days), streak, DayWord(lang, streak))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleAllowImport lets the chat's members import streaks: "/allowimport 365" (the cap) or "/allowimport off"
+func (b *Bot) handleAllowImport(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, t(lang, "not_allowed"))
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	value := strings.TrimSpace(message.CommandArguments())
+
+	var text string
+	if value == "off" {
+		if err := b.setChatSetting(chatID, "import_max_days", ""); err != nil {
+			return err
+		}
+		text = t(lang, "import_off")
+	} else if maxDays, err := strconv.Atoi(value); err != nil || maxDays < 1 {
+		text = t(lang, "allow_import_usage")
+	} else {
+		if err := b.setChatSetting(chatID, "import_max_days", value); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(t(lang, "import_on"), maxDays, DayWord(lang, maxDays))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// median returns the median of values, 0 for none
+func median(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return float64(sorted[mid-1]+sorted[mid]) / 2
+	}
+	return float64(sorted[mid])
+}
+
+// aggregateDailyStats stores the anonymized totals for date (YYYY-MM-DD) across all chats:
+// active participants, how many of them completed, and their median streak. Only counts
+// are kept, no user IDs. A day that is already aggregated is left as is.
+func (b *Bot) aggregateDailyStats(date string) error {
+	var exists bool
+	err := b.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM stats_daily WHERE date = ?)`, date).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	rows, err := b.db.Query(`
+		SELECT p.user_id, EXISTS(
+			SELECT 1 FROM daily_completions dc
+			WHERE dc.user_id = p.user_id AND dc.completed_at = ?
+		)
+		FROM participants p
+		WHERE p.inactive_at IS NULL AND p.left_at IS NULL AND date(p.joined_at) <= ?
+	`, date, date)
+	if err != nil {
+		return err
+	}
+	var userIDs []int64
+	completed := 0
+	for rows.Next() {
+		var userID int64
+		var done bool
+		if err := rows.Scan(&userID, &done); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+		if done {
+			completed++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	streaks := make([]int, 0, len(userIDs))
+	for _, userID := range userIDs {
+		streak, err := b.getIndividualStreak(userID)
+		if err != nil {
+			return err
+		}
+		streaks = append(streaks, streak)
+	}
+
+	rate := 0.0
+	if len(userIDs) > 0 {
+		rate = float64(completed) / float64(len(userIDs))
+	}
+
+	_, err = b.db.Exec(`
+		INSERT OR IGNORE INTO stats_daily (date, active_users, completed_users, completion_rate, median_streak)
+		VALUES (?, ?, ?, ?, ?)
+	`, date, len(userIDs), completed, rate, median(streaks))
+	if err != nil {
+		return err
+	}
+	b.logger.Info("daily stats aggregated", "date", date, "active_users", len(userIDs), "completed_users", completed)
+	return nil
+}
+
+// globalStatsDays is how many recent days /globalstats shows
+const globalStatsDays = 14
+
+// handleGlobalStats shows the operator the anonymized daily aggregates across all chats
+func (b *Bot) handleGlobalStats(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	rows, err := b.db.Query(`
+		SELECT CAST(date AS TEXT), active_users, completed_users, completion_rate, median_streak
+		FROM stats_daily
+		ORDER BY date DESC
+		LIMIT ?
+	`, globalStatsDays)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var date string
+		var active, completed int
+		var rate, medianStreak float64
+		if err := rows.Scan(&date, &active, &completed, &rate, &medianStreak); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf(Messages["global_stats_line"], date, active, completed, rate*100, medianStreak))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	response := Messages["global_stats_empty"]
+	if len(lines) > 0 {
+		response = Messages["global_stats_header"] + "\n\n" + strings.Join(lines, "\n")
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// auditPageSize is how many recent admin actions /audit shows
+const auditPageSize = 20
+
+// handleAudit lists the most recent admin changes to user data
+func (b *Bot) handleAudit(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	rows, err := b.db.Query(`
+		SELECT a.created_at, a.admin_id, a.action, a.target_user_id,
+			COALESCE(p.display_name, p.username, ''), a.old_value, a.new_value
+		FROM admin_audit a
+		LEFT JOIN participants p ON p.user_id = a.target_user_id
+		ORDER BY a.id DESC
+		LIMIT ?
+	`, auditPageSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var createdAt time.Time
+		var adminID, targetID int64
+		var action, name string
+		var oldValue, newValue int
+		if err := rows.Scan(&createdAt, &adminID, &action, &targetID, &name, &oldValue, &newValue); err != nil {
+			return err
+		}
+		if name == "" {
+			name = fmt.Sprintf("ID: %d", targetID)
+		}
+		lines = append(lines, fmt.Sprintf(Messages["audit_line"],
+			createdAt.Format("02.01.2006 15:04"), adminID, action, name, oldValue, newValue,
+		))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	response := Messages["audit_empty"]
+	if len(lines) > 0 {
+		response = Messages["audit_header"] + "\n\n" + strings.Join(lines, "\n")
+	}
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+func (b *Bot) sendLastChanceReminders(chatID int64) error {
+	now, err := b.todayIn(chatID)
+	if err != nil {
+		return err
+	}
+	today := now.Format("2006-01-02")
+
+	userIDs, err := b.reminderCandidates(chatID, today)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		b.remindIfPending(chatID, userID, today, "last_chance")
+	}
+	return nil
+}
+
+// userMessageError carries the key of a message that explains the failure better than
+// the generic ones, for reportError to show
+type userMessageError struct {
+	key string
+	err error
+}
+
+func (e *userMessageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *userMessageError) Unwrap() error {
+	return e.err
+}
+
+// withUserMessage wraps err with the message key reportError shows the user
+func withUserMessage(key string, err error) error {
+	return &userMessageError{key: key, err: err}
+}
+
+// errorMessageKey picks the message shown to the user when handling an update failed,
+// and reports false when nothing should be sent. A failed Telegram request means
+// sending failed already (or is being retried in the background), so another message
+// wouldn't help. Database errors ask to try later; anything else is unexpected.
+func errorMessageKey(err error) (string, bool) {
+	var userErr *userMessageError
+	if errors.As(err, &userErr) {
+		return userErr.key, true
+	}
+
+	var apiErr *tgbotapi.Error
+	var netErr *url.Error
+	if errors.Is(err, errSendRetrying) || errors.As(err, &apiErr) || errors.As(err, &netErr) {
+		return "", false
+	}
+	if isDBError(err) {
+		return "error_try_later", true
+	}
+	return "error_unexpected", true
+}
+
+// isUnreachableUser reports whether Telegram refused a private message because the user
+// blocked the bot or deleted their account
+func isUnreachableUser(err error) bool {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 403 {
+		return false
+	}
+	return strings.Contains(apiErr.Message, "bot was blocked by the user") ||
+		strings.Contains(apiErr.Message, "user is deactivated")
+}
+
+// deactivateParticipant stops reminders to a participant who can't be reached anymore
+// and hides them from the list. Their history is kept.
+func (b *Bot) deactivateParticipant(userID int64) error {
+	res, err := b.db.Exec(`
+		UPDATE participants SET inactive_at = CURRENT_TIMESTAMP 
+		WHERE user_id = ? AND inactive_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if deactivated, err := res.RowsAffected(); err == nil && deactivated > 0 {
+		b.logger.Info("deactivated unreachable participant", "user_id", userID)
+		b.invalidateUserParticipantsCache(userID)
+	}
+	return nil
+}
+
+// reportError tells the user that their action failed, so they're never left without a
+// response, unless the failure was Telegram's and another message wouldn't get through either
+func (b *Bot) reportError(chatID int64, err error) {
+	if chatID == 0 {
+		return
+	}
+
+	key, ok := errorMessageKey(err)
+	if !ok {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, Messages[key])
+	if _, sendErr := b.sendMessage(msg); sendErr != nil {
+		b.logger.Error("failed to report error to user", "chat_id", chatID, "error", sendErr, "cause", err)
+	}
+}
+
+// Helper functions for consistent logging
+func getChatID(update tgbotapi.Update) int64 {
+	if update.Message != nil && update.Message.Chat != nil {
+		return update.Message.Chat.ID
+	}
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil {
+		return update.CallbackQuery.Message.Chat.ID
+	}
+	return 0
+}
+
+func getUserID(update tgbotapi.Update) int64 {
+	if update.Message != nil && update.Message.From != nil {
+		return update.Message.From.ID
+	}
+	if update.CallbackQuery != nil && update.CallbackQuery.From != nil {
+		return update.CallbackQuery.From.ID
+	}
+	return 0
+}
+
+func getUpdateType(update tgbotapi.Update) string {
+	if update.Message != nil {
+		return "message"
+	}
+	if update.CallbackQuery != nil {
+		return "callback_query"
+	}
+	return "unknown"
+}
+
+// sendLimiter is a token bucket for outgoing messages. It holds up to a second's worth
+// of messages, so single replies go out right away and only bulk sends get spaced out.
+type sendLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newSendLimiter allows perSecond messages a second, 0 or less turns the limit off
+func newSendLimiter(perSecond int) *sendLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &sendLimiter{rate: float64(perSecond), tokens: float64(perSecond), last: time.Now()}
+}
+
+// wait blocks until a message may be sent
+func (l *sendLimiter) wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	// A negative balance is the queue ahead of this message
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Retries of a message that failed to send for a reason that may go away
+const (
+	sendRetryAttempts = 3
+	sendRetryBackoff  = time.Second
+)
+
+// errSendRetrying marks a send that failed but is being retried in the background
+var errSendRetrying = errors.New("send failed, retrying in the background")
+
+// sendRetryDelay reports whether a failed send is worth retrying and after how long:
+// network errors and Telegram's 5xx are retried after backoff, 429 Too Many Requests
+// after the retry_after Telegram asked for. Timeouts aren't retried, since the request
+// may have reached Telegram and a retry could post the message twice. Anything else,
+// like a user who blocked the bot, won't change on a retry.
+func sendRetryDelay(err error, backoff time.Duration) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == 429 && apiErr.RetryAfter > 0:
+			return time.Duration(apiErr.RetryAfter) * time.Second, true
+		case apiErr.Code == 429 || apiErr.Code >= 500:
+			return backoff, true
+		}
+		return 0, false
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && !urlErr.Timeout() {
+		return backoff, true
+	}
+	return 0, false
+}
+
+// trySend makes a single attempt at a request, within the send rate limit
+func (b *Bot) trySend(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	b.sendLimiter.wait()
+	return b.api.Send(c)
+}
+
+// send makes a request within the send rate limit. Transient failures are retried a few
+// times with a growing delay in the background, so a slow or rate limiting Telegram
+// doesn't hold up the update loop; the caller gets the first error wrapped in errSendRetrying.
+func (b *Bot) send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return b.sendAttempt(c, 1, sendRetryBackoff)
+}
+
+// sendAttempt is attempt number attempt of send, scheduling the next one if it's worth it
+func (b *Bot) sendAttempt(c tgbotapi.Chattable, attempt int, backoff time.Duration) (tgbotapi.Message, error) {
+	sent, err := b.trySend(c)
+	if err == nil || attempt == sendRetryAttempts {
+		return sent, err
+	}
+
+	delay, retry := sendRetryDelay(err, backoff)
+	if !retry {
+		return sent, err
+	}
+	b.logger.Debug("retrying send in the background",
+		"attempt", attempt,
+		"retry_in", delay,
+		"error", err,
+	)
+	time.AfterFunc(delay, func() {
+		_, err := b.sendAttempt(c, attempt+1, backoff*2)
+		if err != nil && !errors.Is(err, errSendRetrying) {
+			b.logger.Error("giving up on send", "attempts", attempt+1, "error", err)
+		}
+	})
+	return sent, fmt.Errorf("%w: %w", errSendRetrying, err)
+}
+
+// Helper method for sending messages with logging. Transient failures are retried
+// in the background by send.
+func (b *Bot) sendMessage(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	sent, err := b.send(msg)
+	b.logSentMessage(msg, sent, err)
+	return sent, err
+}
+
+// sendMessageOnce sends a message without background retries, for callers that keep
+// failed messages for retrying themselves, like reminders in failed_reminders
+func (b *Bot) sendMessageOnce(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	sent, err := b.trySend(msg)
+	b.logSentMessage(msg, sent, err)
+	return sent, err
+}
+
+// logSentMessage logs the outcome of sending msg and deactivates users who can't be reached anymore
+func (b *Bot) logSentMessage(msg tgbotapi.MessageConfig, sent tgbotapi.Message, err error) {
+	if err != nil {
+		b.logger.Error("failed to send message",
+			"chat_id", msg.ChatID,
+			"text", msg.Text,
+			"error", err,
+		)
+
+		// Group IDs are negative, so a positive chat ID is a DM and names the user
+		if msg.ChatID > 0 && isUnreachableUser(err) {
+			if deactivateErr := b.deactivateParticipant(msg.ChatID); deactivateErr != nil {
+				b.logger.Error("failed to deactivate participant", "user_id", msg.ChatID, "error", deactivateErr)
+			}
+		}
+		return
+	}
+
+	b.logger.Info("sent message",
+		"chat_id", msg.ChatID,
+		"text", msg.Text,
+		"message_id", sent.MessageID,
+	)
+}
+
+// checkAndRecordAchievements checks if a user has reached any milestone streaks
+// and records the achievement if they have.
+func (b *Bot) checkAndRecordAchievements(userID int64, streak int) error {
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	earned, err := recordAchievements(b.db, userID, streak, now.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	return b.announceAchievements(userID, earned)
+}
+
+// recordAchievements records the milestones the streak reached that the user doesn't
+// have yet, through q, as achieved on date (YYYY-MM-DD). It returns the newly earned
+// milestones for announcing.
+func recordAchievements(q querier, userID int64, streak int, date string) ([]milestone, error) {
+	var earned []milestone
+	for _, m := range achievementMilestones {
+		if streak < m.Days {
+			continue
+		}
+
+		res, err := q.Exec(`
+			INSERT OR IGNORE INTO achievements (user_id, achievement_type, achieved_at)
+			VALUES (?, ?, ?)
+		`, userID, m.Type, date)
+		if err != nil {
+			return nil, err
+		}
+		if added, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if added > 0 {
+			earned = append(earned, m)
+		}
+	}
+	return earned, nil
+}
+
+// announceAchievements congratulates the user on the highest of the newly earned milestones,
+// so a long streak reaching several at once isn't announced over and over
+func (b *Bot) announceAchievements(userID int64, earned []milestone) error {
+	if len(earned) == 0 {
+		return nil
+	}
+	m := earned[len(earned)-1]
+
+	var chatID int64
+	err := b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&chatID)
+	if err != nil {
+		return err
+	}
+
+	if err := b.announceAchievement(userID, chatID, Messages[m.MessageKey]); err != nil {
+		return err
+	}
+	if m.Days >= broadcastMilestoneDays {
+		if err := b.broadcastAchievement(userID, chatID, m); err != nil {
+			b.logger.Error("failed to broadcast achievement", "error", err, "user_id", userID, "days", m.Days)
+		}
+	}
+	return nil
+}
+
+// broadcastMilestoneDays is the shortest milestone announced to every participant chat
+const broadcastMilestoneDays = 100
+
+// broadcastAchievement announces a milestone by name in the chats of all other participants,
+// so a shared challenge can cheer together. Chats the congrats already went to are skipped,
+// and nothing is broadcast when the achiever's chat keeps achievements private.
+func (b *Bot) broadcastAchievement(userID, chatID int64, m milestone) error {
+	scope, err := b.getChatSetting(chatID, "achievement_scope", achievementScopeGroup)
+	if err != nil {
+		return err
+	}
+	if scope == achievementScopeDM {
+		return nil
+	}
+
+	var name string
+	err = b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, userID).Scan(&name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := b.db.Query(`
+		SELECT DISTINCT chat_id FROM participants
+		WHERE user_id != ? AND inactive_at IS NULL AND left_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	var chatIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		chatIDs = append(chatIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	notified := make(map[int64]bool)
+	for _, target := range achievementTargets(scope, userID, chatID) {
+		notified[target] = true
+	}
+	for _, target := range chatIDs {
+		if notified[target] {
+			continue
+		}
+		notified[target] = true
+
+		lang, err := b.chatLang(target)
+		if err != nil {
+			return err
+		}
+		msg := tgbotapi.NewMessage(target, fmt.Sprintf(t(lang, "achievement_broadcast"), name, m.Days, DayWord(lang, m.Days)))
+		if _, err := b.sendMessage(msg); err != nil {
+			b.logger.Error("failed to send achievement broadcast", "error", err, "chat_id", target)
+		}
+	}
+	return nil
+}
+
+// Achievement announcement scopes: in the chat the user joined from, privately, or both
+const (
+	achievementScopeGroup = "group"
+	achievementScopeDM    = "dm"
+	achievementScopeBoth  = "both"
+)
+
+// achievementTargets returns the chats a milestone congrats goes to for the given scope.
+// In a private chat the group and the DM are the same chat, so it gets one message.
+func achievementTargets(scope string, userID, chatID int64) []int64 {
+	switch {
+	case isDM(chatID, userID):
+		return []int64{chatID}
+	case scope == achievementScopeDM:
+		return []int64{dmChat(userID)}
+	case scope == achievementScopeBoth:
+		return []int64{chatID, dmChat(userID)}
+	default:
+		return []int64{chatID}
+	}
+}
+
+// announceAchievement sends the milestone congrats according to the chat's announcement scope
+func (b *Bot) announceAchievement(userID, chatID int64, text string) error {
+	scope, err := b.getChatSetting(chatID, "achievement_scope", achievementScopeGroup)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range achievementTargets(scope, userID, chatID) {
+		msg := tgbotapi.NewMessage(target, text)
+		sent, err := b.sendMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		// Only public announcements get celebrated
+		if target == chatID && chatID != userID {
+			if err := b.celebrateAnnouncement(chatID, sent.MessageID); err != nil {
+				b.logger.Error("failed to celebrate achievement announcement", "error", err, "chat_id", chatID)
+			}
+		}
+	}
+	return nil
+}
+
+// Ways to highlight a milestone announcement in a group
+const (
+	milestoneHighlightReact = "react"
+	milestoneHighlightPin   = "pin"
+)
+
+// milestonePinDuration is how long a pinned milestone announcement stays pinned
+const milestonePinDuration = 24 * time.Hour
+
+// milestoneReaction is the emoji the bot puts on milestone announcements
+const milestoneReaction = "🎉"
+
+// celebrateAnnouncement highlights a milestone announcement as the chat asked:
+// the bot reacts to it with an emoji or pins it for a day
+func (b *Bot) celebrateAnnouncement(chatID int64, messageID int) error {
+	highlight, err := b.getChatSetting(chatID, "milestone_highlight", "")
+	if err != nil {
+		return err
+	}
+
+	switch highlight {
+	case milestoneHighlightReact:
+		// The library predates message reactions, so call the method directly
+		params := tgbotapi.Params{}
+		params.AddNonZero64("chat_id", chatID)
+		params.AddNonZero("message_id", messageID)
+		params["reaction"] = fmt.Sprintf(`[{"type":"emoji","emoji":"%s"}]`, milestoneReaction)
+		_, err := b.api.MakeRequest("setMessageReaction", params)
+		return err
+	case milestoneHighlightPin:
+		pin := tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: messageID, DisableNotification: true}
+		if _, err := b.api.Request(pin); err != nil {
+			return err
+		}
+		time.AfterFunc(milestonePinDuration, func() {
+			unpin := tgbotapi.UnpinChatMessageConfig{ChatID: chatID, MessageID: messageID}
+			if _, err := b.api.Request(unpin); err != nil {
+				b.logger.Error("failed to unpin milestone announcement", "error", err, "chat_id", chatID)
+			}
+		})
+	}
+	return nil
+}
+
+// handleMilestoneHighlight sets how milestone announcements stand out: "/milestonehighlight react|pin|off"
+func (b *Bot) handleMilestoneHighlight(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	value := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+
+	var text string
+	switch value {
+	case milestoneHighlightReact:
+		if err := b.setChatSetting(chatID, "milestone_highlight", value); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["milestone_highlight_react"], milestoneReaction)
+	case milestoneHighlightPin:
+		if err := b.setChatSetting(chatID, "milestone_highlight", value); err != nil {
+			return err
+		}
+		text = Messages["milestone_highlight_pin"]
+	case "off":
+		if err := b.setChatSetting(chatID, "milestone_highlight", ""); err != nil {
+			return err
+		}
+		text = Messages["milestone_highlight_off"]
+	default:
+		text = Messages["milestone_highlight_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleAchievementScope sets where milestone congrats are sent: "/achievementscope group|dm|both"
+func (b *Bot) handleAchievementScope(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+	scope := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+
+	var text string
+	switch scope {
+	case achievementScopeGroup:
+		if err := b.setChatSetting(chatID, "achievement_scope", ""); err != nil {
+			return err
+		}
+		text = Messages["achievement_scope_group"]
+	case achievementScopeDM:
+		if err := b.setChatSetting(chatID, "achievement_scope", scope); err != nil {
+			return err
+		}
+		text = Messages["achievement_scope_dm"]
+	case achievementScopeBoth:
+		if err := b.setChatSetting(chatID, "achievement_scope", scope); err != nil {
+			return err
+		}
+		text = Messages["achievement_scope_both"]
+	default:
+		text = Messages["achievement_scope_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// progressBar draws progress towards a goal as ten cells
+func progressBar(current, goal int) string {
+	filled := 10
+	if current < goal {
+		filled = current * 10 / goal
+	}
+	return strings.Repeat("▓", filled) + strings.Repeat("░", 10-filled)
+}
+
+// checkPersonalGoal privately celebrates a user who reached their personal streak goal.
+// The goal is cleared once reached, so the congrats is sent exactly once.
+func (b *Bot) checkPersonalGoal(userID int64, streak int) error {
+	var goal sql.NullInt64
+	err := b.db.QueryRow(`SELECT personal_goal FROM participants WHERE user_id = ?`, userID).Scan(&goal)
+	if err != nil {
+		return err
+	}
+	if !goal.Valid || streak < int(goal.Int64) {
+		return nil
+	}
+
+	res, err := b.db.Exec(`
+		UPDATE participants SET personal_goal = NULL 
+		WHERE user_id = ? AND personal_goal = ?
+	`, userID, goal.Int64)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(dmChat(userID), fmt.Sprintf(Messages["goal_reached"], goal.Int64, GetDayWord(int(goal.Int64))))
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleGoal sets a personal streak goal: "/goal 50", "/goal" shows progress, "/goal off" removes it
+func (b *Bot) handleGoal(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
+	arg := strings.TrimSpace(message.CommandArguments())
+
+	var goal sql.NullInt64
+	err := b.db.QueryRow(`SELECT personal_goal FROM participants WHERE user_id = ?`, userID).Scan(&goal)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	streak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		return err
+	}
+
+	var text string
+	switch {
+	case arg == "" && !goal.Valid:
+		text = Messages["goal_none"]
+	case arg == "":
+		text = fmt.Sprintf(Messages["goal_progress"], streak, goal.Int64, progressBar(streak, int(goal.Int64)))
+	case arg == "off":
+		if _, err := b.db.Exec(`UPDATE participants SET personal_goal = NULL WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+		text = Messages["goal_off"]
+	default:
+		newGoal, err := strconv.Atoi(arg)
+		if err != nil || newGoal < 1 {
+			text = Messages["goal_usage"]
+			break
+		}
+		if newGoal <= streak {
+			text = fmt.Sprintf(Messages["goal_too_small"], streak, GetDayWord(streak))
+			break
+		}
+		if _, err := b.db.Exec(`UPDATE participants SET personal_goal = ? WHERE user_id = ?`, newGoal, userID); err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["goal_set"], newGoal, GetDayWord(newGoal)) + "\n" +
+			fmt.Sprintf(Messages["goal_progress"], streak, newGoal, progressBar(streak, newGoal))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// streakRun describes a run of consecutive completion days
+type streakRun struct {
+	Length int
+	Start  time.Time
+	End    time.Time
+}
+
+// longestRun finds the longest run of consecutive days in dates sorted ascending
+func longestRun(dates []time.Time) streakRun {
+	var best, current streakRun
+	for i, d := range dates {
+		if i > 0 && d.Sub(dates[i-1]) == 24*time.Hour {
+			current.Length++
+			current.End = d
+		} else if i == 0 || !d.Equal(dates[i-1]) {
+			current = streakRun{Length: 1, Start: d, End: d}
+		}
+
+		if current.Length > best.Length {
+			best = current
+		}
+	}
+	return best
+}
+
+// getLongestStreak returns the longest streak the user has ever had
+func (b *Bot) getLongestStreak(userID int64) (streakRun, error) {
+	rows, err := b.db.Query(`
+		SELECT completed_at FROM daily_completions 
+		WHERE user_id = ?
+		ORDER BY completed_at
+	`, userID)
+	if err != nil {
+		return streakRun{}, err
+	}
+	defer rows.Close()
+
+	var dates []time.Time
+	for rows.Next() {
+		var d time.Time
+		if err := rows.Scan(&d); err != nil {
+			return streakRun{}, err
+		}
+		dates = append(dates, d)
+	}
+	if err := rows.Err(); err != nil {
+		return streakRun{}, err
+	}
+
+	return longestRun(dates), nil
+}
+
+// milestone is a streak length that earns an achievement, with the congrats sent for it
+type milestone struct {
+	Days       int
+	Type       string
+	MessageKey string
+}
+
+// achievementMilestones are all achievements in ascending order. A new milestone only
+// needs an entry here and its congrats message.
+var achievementMilestones = []milestone{
+	{7, "7_days", "achievement_7_congrats"},
+	{30, "30_days", "achievement_30_congrats"},
+	{100, "100_days", "achievement_100_congrats"},
+	{200, "200_days", "achievement_200_congrats"},
+	{365, "365_days", "achievement_365_congrats"},
+	{500, "500_days", "achievement_500_congrats"},
+	{1000, "1000_days", "achievement_1000_congrats"},
+}
+
+// reconcileAchievements compares every participant's achievements with their longest
+// streak ever. Deserved but missing achievements are granted, dated by the day the
+// milestone was reached. Achievements the history doesn't support are only reported,
+// since they may come from manual adjustments worth keeping.
+func (b *Bot) reconcileAchievements() (granted, orphaned []string, err error) {
+	rows, err := b.db.Query(`SELECT user_id, COALESCE(display_name, username) FROM participants ORDER BY joined_at`)
+	if err != nil {
+		return nil, nil, err
+	}
+	var users []struct {
+		ID   int64
+		Name string
+	}
+	for rows.Next() {
+		var u struct {
+			ID   int64
+			Name string
+		}
+		if err := rows.Scan(&u.ID, &u.Name); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+
+	for _, u := range users {
+		run, err := b.getLongestStreak(u.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, m := range achievementMilestones {
+			var exists bool
+			err := b.db.QueryRow(`
+				SELECT EXISTS(
+					SELECT 1 FROM achievements 
+					WHERE user_id = ? AND achievement_type = ?
+				)
+			`, u.ID, m.Type).Scan(&exists)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			deserved := run.Length >= m.Days
+			switch {
+			case deserved && !exists:
+				reachedAt := run.Start.AddDate(0, 0, m.Days-1).Format("2006-01-02")
+				_, err = b.db.Exec(`
+					INSERT INTO achievements (user_id, achievement_type, achieved_at)
+					VALUES (?, ?, ?)
+				`, u.ID, m.Type, reachedAt)
+				if err != nil {
+					return nil, nil, err
+				}
+				granted = append(granted, fmt.Sprintf("%s — %s", u.Name, m.Type))
+			case !deserved && exists:
+				orphaned = append(orphaned, fmt.Sprintf("%s — %s (%s %d)", u.Name, m.Type, Messages["reconcile_longest"], run.Length))
+			}
+		}
+	}
+	return granted, orphaned, nil
+}
+
+// handleReconcile checks that achievements match streak history and reports the changes
+func (b *Bot) handleReconcile(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	granted, orphaned, err := b.reconcileAchievements()
+	if err != nil {
+		return err
+	}
+
+	response := Messages["reconcile_ok"]
+	if len(granted) > 0 || len(orphaned) > 0 {
+		response = Messages["reconcile_header"]
+		if len(granted) > 0 {
+			response += "\n\n" + Messages["reconcile_granted"] + "\n  • " + strings.Join(granted, "\n  • ")
+		}
+		if len(orphaned) > 0 {
+			response += "\n\n" + Messages["reconcile_orphaned"] + "\n  • " + strings.Join(orphaned, "\n  • ")
+		}
+	}
+
+	b.logger.Info("reconciled achievements", "granted", len(granted), "orphaned", len(orphaned))
+
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// clearAchievement deletes one achievement of a user and records it in the audit log.
+// Reports false if the user didn't have it.
+func (b *Bot) clearAchievement(adminID, userID int64, achievementType string, days int) (bool, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		DELETE FROM achievements WHERE user_id = ? AND achievement_type = ?
+	`, userID, achievementType)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil || deleted == 0 {
+		return false, err
+	}
+
+	if err := writeAudit(tx, adminID, "clear_"+achievementType, userID, days, 0); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	b.logger.Info("admin cleared achievement",
+		"admin_id", adminID,
+		"user_id", userID,
+		"achievement_type", achievementType,
+	)
+	return true, nil
+}
+
+// userAchievements lists a user's achievements as "type — date" lines
+func (b *Bot) userAchievements(userID int64) ([]string, error) {
+	rows, err := b.db.Query(`
+		SELECT achievement_type, achieved_at FROM achievements
+		WHERE user_id = ?
+		ORDER BY achieved_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var achievementType string
+		var achievedAt time.Time
+		if err := rows.Scan(&achievementType, &achievedAt); err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("%s — %s", achievementType, achievedAt.Format("02.01.2006")))
+	}
+	return lines, rows.Err()
+}
+
+// handleClearAchievement lets admins fix wrong achievements: "/clearachievement userID"
+// lists them, "/clearachievement userID type" deletes one
+func (b *Bot) handleClearAchievement(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	var userID int64
+	var err error
+	if len(args) == 1 || len(args) == 2 {
+		userID, err = strconv.ParseInt(args[0], 10, 64)
+	}
+	if len(args) == 0 || len(args) > 2 || err != nil {
+		msg := tgbotapi.NewMessage(chatID, Messages["clear_achievement_usage"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
+	var text string
+	if len(args) == 1 {
+		lines, err := b.userAchievements(userID)
+		if err != nil {
+			return err
+		}
+		text = fmt.Sprintf(Messages["clear_achievement_none"], userID)
+		if len(lines) > 0 {
+			text = fmt.Sprintf(Messages["clear_achievement_list"], userID) + "\n  • " + strings.Join(lines, "\n  • ")
+		}
+	} else {
+		days := 0
+		for _, m := range achievementMilestones {
+			if m.Type == args[1] {
+				days = m.Days
+			}
+		}
+
+		if days == 0 {
+			var types []string
+			for _, m := range achievementMilestones {
+				types = append(types, m.Type)
+			}
+			text = fmt.Sprintf(Messages["clear_achievement_unknown"], strings.Join(types, ", "))
+		} else {
+			cleared, err := b.clearAchievement(message.From.ID, userID, args[1], days)
+			if err != nil {
+				return err
+			}
+			text = fmt.Sprintf(Messages["clear_achievement_missing"], args[1])
+			if cleared {
+				text = fmt.Sprintf(Messages["clear_achievement_done"], args[1], userID)
+			}
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// getStreakRecord returns the chat's record for the longest streak ever.
+// If no record is stored yet, it is computed from the chat's participants and saved.
+func (b *Bot) getStreakRecord(chatID int64) (userID int64, run streakRun, err error) {
+	err = b.db.QueryRow(`
+		SELECT user_id, value, started_at, ended_at FROM chat_records 
+		WHERE chat_id = ? AND record_type = 'longest_streak'
+	`, chatID).Scan(&userID, &run.Length, &run.Start, &run.End)
+	if err != sql.ErrNoRows {
+		return userID, run, err
+	}
+
+	// No record yet: seed it from the full history
+	rows, err := b.db.Query(`SELECT user_id FROM participants WHERE chat_id = ?`, chatID)
+	if err != nil {
+		return 0, streakRun{}, err
+	}
+	var participantIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, streakRun{}, err
+		}
+		participantIDs = append(participantIDs, id)
+	}
+	rows.Close()
+
+	for _, id := range participantIDs {
+		candidate, err := b.getLongestStreak(id)
+		if err != nil {
+			return 0, streakRun{}, err
+		}
+		if candidate.Length > run.Length {
+			userID, run = id, candidate
+		}
+	}
+
+	if run.Length == 0 {
+		return 0, run, nil
+	}
+
+	_, err = b.db.Exec(`
+		INSERT OR REPLACE INTO chat_records (chat_id, record_type, user_id, value, started_at, ended_at)
+		VALUES (?, 'longest_streak', ?, ?, ?, ?)
+	`, chatID, userID, run.Length, run.Start.Format("2006-01-02"), run.End.Format("2006-01-02"))
+	return userID, run, err
+}
+
+// updateStreakRecord stores the user's longest streak as the record of their chat if it
+// beats the current one, and announces it there when the record changes hands
+func (b *Bot) updateStreakRecord(userID int64) error {
+	var chatID int64
+	var name string
+	err := b.db.QueryRow(`SELECT chat_id, COALESCE(display_name, username) FROM participants WHERE user_id = ?`, userID).Scan(&chatID, &name)
+	if err != nil {
+		return err
+	}
+
+	holderID, record, err := b.getStreakRecord(chatID)
+	if err != nil {
+		return err
+	}
+
+	run, err := b.getLongestStreak(userID)
+	if err != nil {
+		return err
+	}
+
+	if run.Length <= record.Length {
+		return nil
+	}
+
+	_, err = b.db.Exec(`
+		INSERT OR REPLACE INTO chat_records (chat_id, record_type, user_id, value, started_at, ended_at)
+		VALUES (?, 'longest_streak', ?, ?, ?, ?)
+	`, chatID, userID, run.Length, run.Start.Format("2006-01-02"), run.End.Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	// Extending one's own record is not news
+	if holderID == userID {
+		return nil
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["new_streak_record"], name, run.Length, GetDayWord(run.Length)))
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleLongestStreakEver shows the all-time longest individual streak in the chat
+func (b *Bot) handleLongestStreakEver(message *tgbotapi.Message) error {
+	holderID, record, err := b.getStreakRecord(message.Chat.ID)
+	if err != nil {
+		return err
+	}
+
+	response := Messages["no_streak_record"]
+	if record.Length > 0 {
+		var name string
+		err = b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, holderID).Scan(&name)
+		if err != nil {
+			// The holder may have left, keep the record anyway
+			name = fmt.Sprintf("ID: %d", holderID)
+		}
+		response = fmt.Sprintf(Messages["streak_record"],
+			record.Length, GetDayWord(record.Length), name,
+			record.Start.Format("02.01.2006"), record.End.Format("02.01.2006"),
+		)
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// fameMinDays is the shortest milestone that puts a participant on the walk of fame
+const fameMinDays = 100
+
+// fameEntry is a participant on the walk of fame with the highest milestone they reached
+type fameEntry struct {
+	Name       string
+	Days       int
+	AchievedAt time.Time
+}
+
+// formatWalkOfFame renders the walk of fame, a section per milestone that has achievers
+func formatWalkOfFame(lang string, fame []fameEntry) string {
+	response := t(lang, "hall_of_fame")
+	for _, m := range achievementMilestones {
+		if m.Days < fameMinDays {
+			continue
+		}
+
+		var lines string
+		for _, f := range fame {
+			if f.Days == m.Days {
+				lines += fmt.Sprintf("  • %s - %s (%s)\n", f.Name, t(lang, "achievement_reached"), f.AchievedAt.Format("02.01.2006"))
+			}
+		}
+		if lines != "" {
+			response += "\n\n" + t(lang, fmt.Sprintf("achievement_%d", m.Days)) + "\n" + strings.TrimSuffix(lines, "\n")
+		}
+	}
+	return response
+}
+
+// handleFame shows the walk of fame on demand
+func (b *Bot) handleFame(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+
+	fame, err := b.getWalkOfFame()
+	if err != nil {
+		return err
+	}
+
+	text := t(lang, "fame_empty")
+	if len(fame) > 0 {
+		text = formatWalkOfFame(lang, fame)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = b.sendMessage(msg)
+	return err
+}
+
+// handleWalkOfFame toggles the walk of fame in the scoreboard: "/walkoffame on|off"
+func (b *Bot) handleWalkOfFame(message *tgbotapi.Message) error {
+	if ok, err := b.requireChatManager(message); !ok {
+		return err
+	}
+	chatID := message.Chat.ID
+
+	var text string
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		if err := b.setChatSetting(chatID, "show_walk_of_fame", "on"); err != nil {
+			return err
+		}
+		text = Messages["walk_of_fame_on"]
+	case "off":
+		if err := b.setChatSetting(chatID, "show_walk_of_fame", "off"); err != nil {
+			return err
+		}
+		text = Messages["walk_of_fame_off"]
+	default:
+		text = Messages["walk_of_fame_usage"]
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// getWalkOfFame returns the participants who reached a milestone of at least fameMinDays,
+// each with their highest one, the highest milestones and the most recent first
+func (b *Bot) getWalkOfFame() ([]fameEntry, error) {
+	milestoneDays := make(map[string]int)
+	for _, m := range achievementMilestones {
+		if m.Days >= fameMinDays {
+			milestoneDays[m.Type] = m.Days
 		}
+	}
 
-		end, _ := time.Parse("2006-01-02", today)
+	rows, err := b.db.Query(`
+		SELECT p.user_id, COALESCE(p.display_name, p.username), a.achievement_type, a.achieved_at
+		FROM achievements a
+		JOIN participants p ON p.user_id = a.user_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		// If start is after today, nothing to do
-		if start.After(end) {
+	highest := make(map[int64]fameEntry)
+	for rows.Next() {
+		var userID int64
+		var name, achievementType string
+		var achievedAt sql.NullTime
+		if err := rows.Scan(&userID, &name, &achievementType, &achievedAt); err != nil {
+			return nil, err
+		}
+
+		days, ok := milestoneDays[achievementType]
+		if !ok || days <= highest[userID].Days {
 			continue
 		}
+		highest[userID] = fameEntry{Name: name, Days: days, AchievedAt: achievedAt.Time}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		// Iterate from start to today, inserting missing days if not present
-		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-			dateStr := d.Format("2006-01-02")
+	fame := make([]fameEntry, 0, len(highest))
+	for _, f := range highest {
+		fame = append(fame, f)
+	}
+	sort.Slice(fame, func(i, j int) bool {
+		if fame[i].Days != fame[j].Days {
+			return fame[i].Days > fame[j].Days
+		}
+		return fame[i].AchievedAt.After(fame[j].AchievedAt)
+	})
+	return fame, nil
+}
 
-			var exists bool
-			err := b.db.QueryRow(`
-                SELECT EXISTS(
-                    SELECT 1 FROM daily_completions WHERE user_id = ? AND completed_at = ?
-                )
-            `, userID, dateStr).Scan(&exists)
-			if err != nil {
-				return err
-			}
+// leaderboardMedals mark the top three of /leaderboard
+var leaderboardMedals = []string{"🥇", "🥈", "🥉"}
 
-			if exists {
-				continue
-			}
+// handleLeaderboard ranks participants by completions in the current calendar month,
+// ties broken by the current streak. The month is taken in the chat's timezone.
+func (b *Bot) handleLeaderboard(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
 
-			_, err = b.db.Exec(`
-                INSERT INTO daily_completions (user_id, completed_at, congrats_message)
-                VALUES (?, ?, ?)
-            `, userID, dateStr, fixedCongrats)
-			if err != nil {
-				return err
-			}
-			totalInserted++
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		return err
+	}
+	loc, err := b.chatLocation(chatID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().In(loc)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	rows, err := b.db.Query(`
+		SELECT p.user_id, COALESCE(p.display_name, p.username), COUNT(*)
+		FROM participants p
+		JOIN daily_completions dc ON dc.user_id = p.user_id
+		WHERE p.inactive_at IS NULL AND dc.completed_at >= ? AND dc.completed_at < ?
+		GROUP BY p.user_id
+	`, monthStart.Format("2006-01-02"), monthStart.AddDate(0, 1, 0).Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		Name        string
+		Completions int
+		Streak      int
+	}
+	var entries []entry
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		var e entry
+		if err := rows.Scan(&userID, &e.Name, &e.Completions); err != nil {
+			rows.Close()
+			return err
 		}
+		entries = append(entries, e)
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	if totalInserted == 0 {
-		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["backfill_none"]))
-		_, _ = b.sendMessage(msg)
-		return nil
+	for i, userID := range userIDs {
+		entries[i].Streak, err = b.getIndividualStreak(userID)
+		if err != nil {
+			return err
+		}
 	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Completions != entries[j].Completions {
+			return entries[i].Completions > entries[j].Completions
+		}
+		return entries[i].Streak > entries[j].Streak
+	})
 
-	// After backfilling, re-calc achievements in case thresholds were crossed
-	for _, userID := range participantIDs {
-		streak, err := b.getIndividualStreak(userID)
-		if err == nil {
-			_ = b.checkAndRecordAchievements(userID, streak)
+	response := fmt.Sprintf(t(lang, "leaderboard_header"), MonthName(lang, now.Month()), now.Year()) + "\n\n"
+	if len(entries) == 0 {
+		response += t(lang, "leaderboard_empty")
+	}
+	for i, e := range entries {
+		place := fmt.Sprintf("%d.", i+1)
+		if i < len(leaderboardMedals) {
+			place = leaderboardMedals[i]
 		}
+		response += fmt.Sprintf(t(lang, "leaderboard_line"), place, e.Name, e.Completions, DayWord(lang, e.Completions), e.Streak) + "\n"
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["backfill_done"], totalInserted))
-	_, _ = b.sendMessage(msg)
-	return nil
+	msg := tgbotapi.NewMessage(chatID, response)
+	_, err = b.sendMessage(msg)
+	return err
 }
 
-func (b *Bot) handleUndoComplete(query *tgbotapi.CallbackQuery) error {
-	today := time.Now().Format("2006-01-02")
+// Calendar image layout, in pixels
+const (
+	calendarCell    = 40
+	calendarPadding = 10
+	calendarHeader  = 40
+	calendarDayRow  = 24
+)
 
-	// Check if completed today
-	var completed bool
-	err := b.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM daily_completions 
-			WHERE user_id = ? AND completed_at = ?
-		)
-	`, query.From.ID, today).Scan(&completed)
+// Calendar colors: completed days, missed days, days yet to come and the text
+var (
+	calendarCompleted = color.RGBA{76, 175, 80, 255}
+	calendarMissed    = color.RGBA{200, 200, 200, 255}
+	calendarFuture    = color.RGBA{240, 240, 240, 255}
+	calendarText      = color.RGBA{33, 33, 33, 255}
+)
+
+// calendarFace loads the font for calendar images. Go Regular has Cyrillic,
+// so month names render in every language.
+func calendarFace(size float64) (font.Face, error) {
+	parsed, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// drawCentered writes text centered on x with its baseline at y
+func drawCentered(dst draw.Image, face font.Face, text string, x, y int) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(calendarText), Face: face}
+	width := d.MeasureString(text).Round()
+	d.Dot = fixed.P(x-width/2, y)
+	d.DrawString(text)
+}
+
+// renderCalendar draws a month as a PNG: completed days green, missed days gray.
+// Days after today are left light so they don't read as misses.
+func renderCalendar(lang string, year int, month time.Month, completed map[int]bool, today time.Time) ([]byte, error) {
+	titleFace, err := calendarFace(20)
+	if err != nil {
+		return nil, err
+	}
+	dayFace, err := calendarFace(14)
+	if err != nil {
+		return nil, err
+	}
+
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	// Weeks start on Monday
+	offset := (int(first.Weekday()) + 6) % 7
+	weeks := (offset + daysInMonth + 6) / 7
+
+	width := 2*calendarPadding + 7*calendarCell
+	height := 2*calendarPadding + calendarHeader + calendarDayRow + weeks*calendarCell
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	drawCentered(img, titleFace, fmt.Sprintf("%s %d", MonthName(lang, month), year), width/2, calendarPadding+28)
+	for i, name := range CalendarWeekdays[langOrDefault(lang)] {
+		x := calendarPadding + i*calendarCell + calendarCell/2
+		drawCentered(img, dayFace, name, x, calendarPadding+calendarHeader+16)
+	}
+
+	todayDate := today.Format("2006-01-02")
+	for day := 1; day <= daysInMonth; day++ {
+		cell := offset + day - 1
+		x := calendarPadding + (cell%7)*calendarCell
+		y := calendarPadding + calendarHeader + calendarDayRow + (cell/7)*calendarCell
+
+		fill := calendarMissed
+		if completed[day] {
+			fill = calendarCompleted
+		} else if time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02") > todayDate {
+			fill = calendarFuture
+		}
+		rect := image.Rect(x+2, y+2, x+calendarCell-2, y+calendarCell-2)
+		draw.Draw(img, rect, image.NewUniform(fill), image.Point{}, draw.Src)
+		drawCentered(img, dayFace, strconv.Itoa(day), x+calendarCell/2, y+calendarCell/2+5)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// textCalendar renders a month for a monospace block, a week per line: ✅ for completed
+// days, · for missed ones and nothing for days after today
+func textCalendar(lang string, year int, month time.Month, completed map[int]bool, today time.Time) string {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	offset := (int(first.Weekday()) + 6) % 7
+	todayDate := today.Format("2006-01-02")
+
+	response := fmt.Sprintf("%s %d\n", MonthName(lang, month), year)
+	response += strings.Join(CalendarWeekdays[langOrDefault(lang)], " ") + "\n"
+	response += strings.Repeat("   ", offset)
+	for day := 1; day <= daysInMonth; day++ {
+		switch {
+		case completed[day]:
+			response += "✅ "
+		case time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02") > todayDate:
+			response += "   "
+		default:
+			response += "·  "
+		}
+		if (offset+day)%7 == 0 {
+			response += "\n"
+		}
+	}
+	return strings.TrimRight(response, " \n")
+}
+
+// monthCompletions returns the days of the month starting at first on which the user completed
+func (b *Bot) monthCompletions(userID int64, first time.Time) (map[int]bool, error) {
+	rows, err := b.db.Query(`
+		SELECT completed_at FROM daily_completions
+		WHERE user_id = ? AND completed_at >= ? AND completed_at < ?
+	`, userID, first.Format("2006-01-02"), first.AddDate(0, 1, 0).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	completed := make(map[int]bool)
+	for rows.Next() {
+		var completedAt time.Time
+		if err := rows.Scan(&completedAt); err != nil {
+			return nil, err
+		}
+		completed[completedAt.Day()] = true
+	}
+	return completed, rows.Err()
+}
+
+// sendTextCalendar sends the month as a monospace text calendar
+func (b *Bot) sendTextCalendar(chatID int64, lang string, now time.Time, completed map[int]bool) error {
+	calendar := textCalendar(lang, now.Year(), now.Month(), completed, now)
+	msg := tgbotapi.NewMessage(chatID, "<pre>"+html.EscapeString(calendar)+"</pre>")
+	msg.ParseMode = tgbotapi.ModeHTML
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// handleCalendar sends the user's completions for the current month as a calendar image
+func (b *Bot) handleCalendar(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	userID := message.From.ID
+
+	lang, err := b.chatLang(chatID)
 	if err != nil {
 		return err
 	}
 
-	if !completed {
-		callback := tgbotapi.NewCallback(query.ID, Messages["no_completion_today"])
-		_, err := b.api.Request(callback)
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
+	completed, err := b.monthCompletions(userID, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+	if err != nil {
 		return err
 	}
 
-	// Remove completion
-	_, err = b.db.Exec(`
-		DELETE FROM daily_completions 
-		WHERE user_id = ? AND completed_at = ?
-	`, query.From.ID, today)
+	data, err := renderCalendar(lang, now.Year(), now.Month(), completed, now)
 	if err != nil {
+		b.logger.Error("failed to render calendar, falling back to text", "error", err, "user_id", userID)
+		return b.sendTextCalendar(chatID, lang, now, completed)
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "calendar.png", Bytes: data})
+	photo.Caption = fmt.Sprintf(t(lang, "calendar_caption"), len(completed))
+	if _, err := b.send(photo); err != nil {
+		b.logger.Error("failed to send calendar", "chat_id", chatID, "error", err)
 		return err
 	}
+	return nil
+}
 
-	callback := tgbotapi.NewCallback(query.ID, Messages["completion_cancelled"])
-	if _, err := b.api.Request(callback); err != nil {
+// handleHistory sends the user's current month as a text calendar, in their chat's timezone
+func (b *Bot) handleHistory(message *tgbotapi.Message) error {
+	lang, err := b.chatLang(message.Chat.ID)
+	if err != nil {
 		return err
 	}
 
-	return b.sendParticipantsList(query.Message.Chat.ID, query.From.ID)
+	now, err := b.userNow(message.From.ID)
+	if err != nil {
+		return err
+	}
+	completed, err := b.monthCompletions(message.From.ID, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		return err
+	}
+	return b.sendTextCalendar(message.Chat.ID, lang, now, completed)
 }
 
-func (b *Bot) sendDailyReminders() error {
-	today := time.Now().Format("2006-01-02")
+// handleStats shows personal statistics with relative labels for recent completions
+func (b *Bot) handleStats(message *tgbotapi.Message) error {
+	userID := message.From.ID
+
+	lang, err := b.resolveLang(userID, message.Chat.ID)
+	if err != nil {
+		return err
+	}
+
+	var name string
+	err = b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(message.Chat.ID, t(lang, "not_participant"))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	streak, err := b.getIndividualStreak(userID)
+	if err != nil {
+		return err
+	}
+
+	var total int
+	err = b.db.QueryRow(`SELECT COUNT(*) FROM daily_completions WHERE user_id = ?`, userID).Scan(&total)
+	if err != nil {
+		return err
+	}
+
+	now, err := b.userNow(userID)
+	if err != nil {
+		return err
+	}
 
-	// Get all participants who haven't completed today's challenge
 	rows, err := b.db.Query(`
-		SELECT p.user_id, p.chat_id 
-		FROM participants p
-		LEFT JOIN daily_completions dc 
-			ON p.user_id = dc.user_id 
-			AND dc.completed_at = ?
-		WHERE dc.user_id IS NULL
-	`, today)
+		SELECT completed_at FROM daily_completions 
+		WHERE user_id = ?
+		ORDER BY completed_at DESC
+		LIMIT 5
+	`, userID)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
+	var recent []string
 	for rows.Next() {
-		var userID, chatID int64
-		if err := rows.Scan(&userID, &chatID); err != nil {
-			b.logger.Error("error scanning user", "error", err)
-			continue
+		var completedAt time.Time
+		if err := rows.Scan(&completedAt); err != nil {
+			return err
 		}
+		recent = append(recent, fmt.Sprintf("  • %s (%s)", humanizeDate(completedAt, now, lang), completedAt.Format("02.01.2006")))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		participants, err := b.getParticipantsList()
-		if err != nil {
-			b.logger.Error("error getting participants list", "error", err)
-			continue
+	var claps int
+	err = b.db.QueryRow(`SELECT COUNT(*) FROM completion_reactions WHERE user_id = ?`, userID).Scan(&claps)
+	if err != nil {
+		return err
+	}
+
+	tierRows, err := b.db.Query(`
+		SELECT tier, COUNT(*) FROM daily_completions
+		WHERE user_id = ? AND tier IS NOT NULL
+		GROUP BY tier
+	`, userID)
+	if err != nil {
+		return err
+	}
+	tierCounts := make(map[string]int)
+	for tierRows.Next() {
+		var tier string
+		var count int
+		if err := tierRows.Scan(&tier, &count); err != nil {
+			tierRows.Close()
+			return err
+		}
+		tierCounts[tier] = count
+	}
+	tierRows.Close()
+	var tierLines []string
+	for _, tier := range completionTiers {
+		if count := tierCounts[tier]; count > 0 {
+			tierLines = append(tierLines, fmt.Sprintf("  • %s — %d %s", TierLabels[tier], count, pluralize(count, "time", lang)))
 		}
+	}
+
+	response := fmt.Sprintf(t(lang, "stats_header"), name) + "\n\n"
+	response += fmt.Sprintf(t(lang, "stats_streak"), streak, DayWord(lang, streak)) + "\n"
+	response += fmt.Sprintf(t(lang, "stats_total"), total) + "\n"
+	response += fmt.Sprintf(t(lang, "stats_claps"), claps) + "\n\n"
+	if len(tierLines) > 0 {
+		response += t(lang, "stats_tiers") + "\n" + strings.Join(tierLines, "\n") + "\n\n"
+	}
+	response += t(lang, "stats_recent") + "\n"
+	if len(recent) == 0 {
+		response += t(lang, "stats_no_completions")
+	} else {
+		response += strings.Join(recent, "\n")
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, response)
+	_, err = b.sendMessage(msg)
+	return err
+}
 
-		response := Messages["reminder"] + "\n\nУчастники:\n\n"
-		for _, p := range participants {
-			status := StatusIcons["pending"]
-			if p.Completed {
-				status = StatusIcons["completed"]
-			}
-			response += fmt.Sprintf("- %s %s (%d %s)\n\n", status, p.Name, p.Streak, GetDayWord(p.Streak))
-		}
+// buildCertificate draws a bordered certificate for an earned milestone
+func buildCertificate(name string, milestoneDays int, achievedAt time.Time, total int) string {
+	lines := []string{
+		Messages["certificate_title"],
+		"",
+		name,
+		fmt.Sprintf(Messages["certificate_milestone"], milestoneDays, GetDayWord(milestoneDays)),
+		"",
+		fmt.Sprintf(Messages["certificate_date"], achievedAt.Format("02.01.2006")),
+		fmt.Sprintf(Messages["certificate_total"], total),
+	}
 
-		msg := tgbotapi.NewMessage(chatID, response)
-		if _, err := b.sendMessage(msg); err != nil {
-			b.logger.Error("error sending reminder",
-				"user_id", userID,
-				"error", err,
-			)
+	width := 0
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
 		}
 	}
-	return nil
-}
 
-func (b *Bot) getConsecutiveCompletionDays() (int, error) {
-	// Start from yesterday and go backwards to get the base streak
-	currentDate := time.Now().AddDate(0, 0, -1)
-	consecutiveDays := 0
+	border := strings.Repeat("═", width+2)
+	certificate := "╔" + border + "╗\n"
+	for _, line := range lines {
+		// Center each line inside the frame
+		pad := width - utf8.RuneCountInString(line)
+		left := pad / 2
+		certificate += "║ " + strings.Repeat(" ", left) + line + strings.Repeat(" ", pad-left) + " ║\n"
+	}
+	certificate += "╚" + border + "╝"
+	return certificate
+}
 
-	// Get base streak (not including today)
-	for {
-		dateStr := currentDate.Format("2006-01-02")
+// handleCertificate sends a certificate for the user's highest earned milestone, or for
+// the one asked for: "/certificate" or "/certificate 100"
+func (b *Bot) handleCertificate(message *tgbotapi.Message) error {
+	userID := message.From.ID
+	chatID := message.Chat.ID
 
-		var completedCount int
-		err := b.db.QueryRow(`
-			SELECT COUNT(DISTINCT user_id) 
-			FROM daily_completions 
-			WHERE completed_at = ? AND user_id IN (
-				SELECT user_id FROM participants
-				WHERE joined_at <= ?
-			)
-		`, dateStr, dateStr).Scan(&completedCount)
+	var name string
+	err := b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
+	}
 
+	arg := strings.TrimSpace(message.CommandArguments())
+	requested := 0
+	if arg != "" {
+		requested, err = strconv.Atoi(arg)
 		if err != nil {
-			return 0, err
+			msg := tgbotapi.NewMessage(chatID, Messages["certificate_usage"])
+			_, err = b.sendMessage(msg)
+			return err
 		}
+	}
 
-		var totalParticipants int
-		err = b.db.QueryRow(`
-			SELECT COUNT(*) 
-			FROM participants 
-			WHERE joined_at <= ?
-		`, dateStr).Scan(&totalParticipants)
+	// Pick the requested milestone, or the highest one earned
+	var milestoneDays int
+	var achievedAt time.Time
+	for _, m := range achievementMilestones {
+		if requested != 0 && m.Days != requested {
+			continue
+		}
 
+		var at time.Time
+		err := b.db.QueryRow(`
+			SELECT achieved_at FROM achievements 
+			WHERE user_id = ? AND achievement_type = ?
+		`, userID, m.Type).Scan(&at)
+		if err == sql.ErrNoRows {
+			continue
+		}
 		if err != nil {
-			return 0, err
+			return err
 		}
+		if m.Days > milestoneDays {
+			milestoneDays, achievedAt = m.Days, at
+		}
+	}
 
-		if completedCount != totalParticipants || totalParticipants == 0 {
-			break
+	if milestoneDays == 0 {
+		text := Messages["certificate_none"]
+		if requested != 0 {
+			text = fmt.Sprintf(Messages["certificate_not_earned"], requested, GetDayWord(requested))
 		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		_, err = b.sendMessage(msg)
+		return err
+	}
 
-		consecutiveDays++
-		currentDate = currentDate.AddDate(0, 0, -1)
+	var total int
+	err = b.db.QueryRow(`SELECT COUNT(*) FROM daily_completions WHERE user_id = ?`, userID).Scan(&total)
+	if err != nil {
+		return err
 	}
 
-	// Check if everyone completed today's challenge
-	today := time.Now().Format("2006-01-02")
-	var todayCompletedCount int
-	err := b.db.QueryRow(`
-		SELECT COUNT(DISTINCT user_id) 
-		FROM daily_completions 
-		WHERE completed_at = ? AND user_id IN (
-			SELECT user_id FROM participants
-			WHERE joined_at <= ?
-		)
-	`, today, today).Scan(&todayCompletedCount)
+	certificate := buildCertificate(name, milestoneDays, achievedAt, total)
+	msg := tgbotapi.NewMessage(chatID, "<pre>"+html.EscapeString(certificate)+"</pre>")
+	msg.ParseMode = tgbotapi.ModeHTML
+	_, err = b.sendMessage(msg)
+	return err
+}
 
-	if err != nil {
-		return 0, err
+// handleBackup sends a consistent snapshot of the database as a document.
+// VACUUM INTO reads the database in a single transaction, so the copy is
+// consistent even while other writes are in progress.
+func (b *Bot) handleBackup(message *tgbotapi.Message) error {
+	if !b.superAdmins[message.From.ID] {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
 	}
 
-	var totalParticipants int
-	err = b.db.QueryRow(`
-		SELECT COUNT(*) 
-		FROM participants 
-		WHERE joined_at <= ?
-	`, today).Scan(&totalParticipants)
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
 
-	if err != nil {
-		return 0, err
+	backupPath := fmt.Sprintf("%s/backup-%s.db", backupDir, time.Now().Format("20060102-150405"))
+	if _, err := b.db.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
 	}
+	defer os.Remove(backupPath)
 
-	// Add today to streak if everyone completed
-	if todayCompletedCount == totalParticipants && totalParticipants > 0 {
-		consecutiveDays++
+	// Read into memory, so a retry still has the snapshot after it's removed
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return err
+	}
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{Name: filepath.Base(backupPath), Bytes: data})
+	doc.Caption = fmt.Sprintf(Messages["backup_caption"], time.Now().Format("02.01.2006 15:04"))
+	if _, err := b.send(doc); err != nil {
+		b.logger.Error("failed to send backup", "chat_id", message.Chat.ID, "error", err)
+		return err
 	}
 
-	return consecutiveDays, nil
+	b.logger.Info("sent database backup", "chat_id", message.Chat.ID, "user_id", message.From.ID)
+	return nil
 }
 
-// TestFillCompletions fills in completion records for the specified number of days
-// If notEveryoneCompletes is true, it will randomly skip some completions
-func (b *Bot) TestFillCompletions(days int, notEveryoneCompletes bool) error {
-	// Get all participants
-	rows, err := b.db.Query(`SELECT user_id FROM participants`)
+// buildExportCSV writes one row per completion with the participant's ID and name.
+// Participants without completions get a row with an empty date, so nobody is missing.
+func (b *Bot) buildExportCSV() ([]byte, error) {
+	rows, err := b.db.Query(`
+		SELECT p.user_id, COALESCE(p.display_name, p.username, ''), COALESCE(date(dc.completed_at), '')
+		FROM participants p
+		LEFT JOIN daily_completions dc ON dc.user_id = p.user_id
+		ORDER BY p.joined_at, p.user_id, dc.completed_at
+	`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var participants []int64
+	var buf bytes.Buffer
+	// A byte order mark, so spreadsheets read the Cyrillic names as UTF-8
+	buf.WriteString("\uFEFF")
+	w := csv.NewWriter(&buf)
+	if err := w.Write([]string{"user_id", "display_name", "completed_at"}); err != nil {
+		return nil, err
+	}
 	for rows.Next() {
 		var userID int64
-		if err := rows.Scan(&userID); err != nil {
-			return err
+		var name, completedAt string
+		if err := rows.Scan(&userID, &name, &completedAt); err != nil {
+			return nil, err
+		}
+		if err := w.Write([]string{strconv.FormatInt(userID, 10), csvSafe(name), completedAt}); err != nil {
+			return nil, err
 		}
-		participants = append(participants, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	// Fill completions for each day
-	for i := days - 1; i >= 0; i-- {
-		date := time.Now().AddDate(0, 0, -i).Format("2006-01-02")
-
-		for _, userID := range participants {
-			// If notEveryoneCompletes is true, randomly skip some completions
-			if notEveryoneCompletes && rand.Float32() < 0.3 { // 30% chance to skip
-				continue
-			}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
 
-			congratsMessage := getRandomCongratsMessage()
-			_, err = b.db.Exec(`
-				INSERT OR REPLACE INTO daily_completions (user_id, completed_at, congrats_message)
-				VALUES (?, ?, ?)
-			`, userID, date, congratsMessage)
-			if err != nil {
-				return err
-			}
-		}
+// csvSafe keeps a user-controlled value from running as a formula when the CSV is opened
+// in a spreadsheet: values starting with = + - or @ get a leading apostrophe
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
 	}
-
-	return nil
+	return value
 }
 
-// SetUserStreak sets a specific streak for a user by filling in completion records
-// for consecutive days leading up to today
-func (b *Bot) SetUserStreak(userID int64, streakDays int) error {
-	// First, check if the user exists
-	var exists bool
-	err := b.db.QueryRow(`
-		SELECT EXISTS(
-			SELECT 1 FROM participants 
-			WHERE user_id = ?
-		)
-	`, userID).Scan(&exists)
-	if err != nil {
+// handleExport sends all participants and their completion dates as a CSV document
+func (b *Bot) handleExport(message *tgbotapi.Message) error {
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
 		return err
 	}
 
-	if !exists {
-		return fmt.Errorf("user with ID %d does not exist", userID)
+	data, err := b.buildExportCSV()
+	if err != nil {
+		return err
 	}
 
-	// Clear existing streak data first to avoid conflicts
-	_, err = b.db.Exec(`
-		DELETE FROM daily_completions 
-		WHERE user_id = ? AND completed_at >= date('now', ?) AND completed_at <= date('now')
-	`, userID, fmt.Sprintf("-%d days", streakDays))
-	if err != nil {
+	now := challengeNow()
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("zaryadochka-%s.csv", now.Format("20060102")),
+		Bytes: data,
+	})
+	doc.Caption = fmt.Sprintf(Messages["export_caption"], now.Format("02.01.2006 15:04"))
+	if _, err := b.send(doc); err != nil {
+		b.logger.Error("failed to send export", "chat_id", message.Chat.ID, "error", err)
 		return err
 	}
 
-	// Fill completions for each day in the streak
-	for i := streakDays - 1; i >= 0; i-- {
-		date := time.Now().AddDate(0, 0, -i).Format("2006-01-02")
-		congratsMessage := getRandomCongratsMessage()
+	b.logger.Info("sent data export", "chat_id", message.Chat.ID, "user_id", message.From.ID)
+	return nil
+}
 
-		_, err = b.db.Exec(`
-			INSERT INTO daily_completions (user_id, completed_at, congrats_message)
-			VALUES (?, ?, ?)
-		`, userID, date, congratsMessage)
-		if err != nil {
-			return err
-		}
-	}
+// dumpPageSize is how many raw completion dates /dump shows per page
+const dumpPageSize = 50
 
-	// Check for achievements after setting the streak
-	streak, err := b.getIndividualStreak(userID)
+// buildDumpPage lists the exact stored completion dates of a user in chronological order
+func (b *Bot) buildDumpPage(userID int64, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	var total int
+	err := b.db.QueryRow(`SELECT COUNT(*) FROM daily_completions WHERE user_id = ?`, userID).Scan(&total)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	return b.checkAndRecordAchievements(userID, streak)
-}
+	if total == 0 {
+		return fmt.Sprintf(Messages["dump_empty"], userID), nil, nil
+	}
 
-func (b *Bot) sendLastChanceReminders() error {
-	today := time.Now().Format("2006-01-02")
+	pages := (total + dumpPageSize - 1) / dumpPageSize
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
 
-	// Get all participants who haven't completed today's challenge
+	// Cast to text so the driver doesn't parse dates and the exact stored value is shown
 	rows, err := b.db.Query(`
-		SELECT p.user_id, p.chat_id 
-		FROM participants p
-		LEFT JOIN daily_completions dc 
-			ON p.user_id = dc.user_id 
-			AND dc.completed_at = ?
-		WHERE dc.user_id IS NULL
-	`, today)
+		SELECT CAST(completed_at AS TEXT) FROM daily_completions 
+		WHERE user_id = ?
+		ORDER BY completed_at
+		LIMIT ? OFFSET ?
+	`, userID, dumpPageSize, (page-1)*dumpPageSize)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	defer rows.Close()
 
+	response := fmt.Sprintf(Messages["dump_header"], userID, total, page, pages) + "\n\n"
 	for rows.Next() {
-		var userID, chatID int64
-		if err := rows.Scan(&userID, &chatID); err != nil {
-			b.logger.Error("error scanning user", "error", err)
-			continue
-		}
-
-		participants, err := b.getParticipantsList()
-		if err != nil {
-			b.logger.Error("error getting participants list", "error", err)
-			continue
-		}
-
-		response := Messages["last_chance"] + "\n\nУчастники:\n\n"
-		for _, p := range participants {
-			status := StatusIcons["pending"]
-			if p.Completed {
-				status = StatusIcons["completed"]
-			}
-			response += fmt.Sprintf("- %s %s (%d %s)\n\n", status, p.Name, p.Streak, GetDayWord(p.Streak))
-		}
-
-		msg := tgbotapi.NewMessage(chatID, response)
-		if _, err := b.sendMessage(msg); err != nil {
-			b.logger.Error("error sending last chance reminder",
-				"user_id", userID,
-				"error", err,
-			)
+		var completedAt string
+		if err := rows.Scan(&completedAt); err != nil {
+			return "", nil, err
 		}
+		response += completedAt + "\n"
 	}
-	return nil
-}
-
-// Helper functions for consistent logging
-func getChatID(update tgbotapi.Update) int64 {
-	if update.Message != nil {
-		return update.Message.Chat.ID
+	if err := rows.Err(); err != nil {
+		return "", nil, err
 	}
-	if update.CallbackQuery != nil {
-		return update.CallbackQuery.Message.Chat.ID
+
+	if pages == 1 {
+		return response, nil, nil
 	}
-	return 0
-}
 
-func getUserID(update tgbotapi.Update) int64 {
-	if update.Message != nil {
-		return update.Message.From.ID
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("dump:%d:%d", userID, page-1)))
 	}
-	if update.CallbackQuery != nil {
-		return update.CallbackQuery.From.ID
+	if page < pages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("dump:%d:%d", userID, page+1)))
 	}
-	return 0
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return response, &keyboard, nil
 }
 
-func getUpdateType(update tgbotapi.Update) string {
-	if update.Message != nil {
-		return "message"
+// handleDump shows raw completion dates of a user for debugging: "/dump userID"
+func (b *Bot) handleDump(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
 	}
-	if update.CallbackQuery != nil {
-		return "callback_query"
+
+	userID, err := strconv.ParseInt(strings.TrimSpace(message.CommandArguments()), 10, 64)
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, Messages["dump_usage"])
+		_, err := b.sendMessage(msg)
+		return err
 	}
-	return "unknown"
-}
 
-// Helper method for sending messages with logging
-func (b *Bot) sendMessage(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
-	sent, err := b.api.Send(msg)
+	text, keyboard, err := b.buildDumpPage(userID, 1)
 	if err != nil {
-		b.logger.Error("failed to send message",
-			"chat_id", msg.ChatID,
-			"text", msg.Text,
-			"error", err,
-		)
-		return sent, err
+		return err
 	}
 
-	b.logger.Info("sent message",
-		"chat_id", msg.ChatID,
-		"text", msg.Text,
-		"message_id", sent.MessageID,
-	)
-	return sent, nil
+	msg := tgbotapi.NewMessage(chatID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	_, err = b.sendMessage(msg)
+	return err
 }
 
-// checkAndRecordAchievements checks if a user has reached any milestone streaks
-// and records the achievement if they have.
-func (b *Bot) checkAndRecordAchievements(userID int64, streak int) error {
-	// Check for milestone achievements
-	if streak >= 100 {
-		// Check if user already has the 100+ days achievement
-		var exists bool
-		err := b.db.QueryRow(`
-			SELECT EXISTS(
-				SELECT 1 FROM achievements 
-				WHERE user_id = ? AND achievement_type = '100_days'
-			)
-		`, userID).Scan(&exists)
-		if err != nil {
-			return err
-		}
-
-		// Record the achievement if not yet achieved
-		if !exists {
-			_, err = b.db.Exec(`
-				INSERT INTO achievements (user_id, achievement_type, achieved_at)
-				VALUES (?, '100_days', date('now'))
-			`, userID)
-			if err != nil {
-				return err
-			}
-
-			// Send congratulatory message
-			var chatID int64
-			err = b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&chatID)
-			if err != nil {
-				return err
-			}
-
-			var name string
-			err = b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, userID).Scan(&name)
-			if err != nil {
-				return err
-			}
-
-			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["achievement_100_congrats"]))
-			_, err = b.sendMessage(msg)
-			if err != nil {
-				return err
-			}
-		}
+// handleDumpCallback switches /dump pages: "dump:userID:page"
+func (b *Bot) handleDumpCallback(query *tgbotapi.CallbackQuery) error {
+	if !b.isAdmin(query.From.ID) {
+		callback := tgbotapi.NewCallback(query.ID, Messages["not_allowed"])
+		_, err := b.api.Request(callback)
+		return err
 	}
 
-	if streak >= 365 {
-		// Check if user already has the 365+ days achievement
-		var exists bool
-		err := b.db.QueryRow(`
-			SELECT EXISTS(
-				SELECT 1 FROM achievements 
-				WHERE user_id = ? AND achievement_type = '365_days'
-			)
-		`, userID).Scan(&exists)
-		if err != nil {
-			return err
-		}
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid callback data format")
+	}
 
-		// Record the achievement if not yet achieved
-		if !exists {
-			_, err = b.db.Exec(`
-				INSERT INTO achievements (user_id, achievement_type, achieved_at)
-				VALUES (?, '365_days', date('now'))
-			`, userID)
-			if err != nil {
-				return err
-			}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return err
+	}
 
-			// Send congratulatory message
-			var chatID int64
-			err = b.db.QueryRow(`SELECT chat_id FROM participants WHERE user_id = ?`, userID).Scan(&chatID)
-			if err != nil {
-				return err
-			}
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return err
+	}
 
-			var name string
-			err = b.db.QueryRow(`SELECT COALESCE(display_name, username) FROM participants WHERE user_id = ?`, userID).Scan(&name)
-			if err != nil {
-				return err
-			}
+	// Stop the button spinner before the page is rebuilt
+	if _, err := b.api.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		return err
+	}
 
-			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["achievement_365_congrats"]))
-			_, err = b.sendMessage(msg)
-			if err != nil {
-				return err
-			}
-		}
+	text, keyboard, err := b.buildDumpPage(userID, page)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	editMsg := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	editMsg.ReplyMarkup = keyboard
+	_, err = b.send(editMsg)
+	return err
 }
 
-// getWalkOfFame returns all participants who have achieved milestone streaks
-func (b *Bot) getWalkOfFame() ([]struct {
-	Name           string
-	Achievement100 bool
-	Achievement365 bool
-	AchievedAt100  time.Time
-	AchievedAt365  time.Time
-}, error) {
-	rows, err := b.db.Query(`
-		SELECT 
-			COALESCE(p.display_name, p.username) as name,
-			a100.user_id IS NOT NULL as achievement_100,
-			a365.user_id IS NOT NULL as achievement_365,
-			a100.achieved_at as achieved_at_100,
-			a365.achieved_at as achieved_at_365
-		FROM participants p
-		LEFT JOIN achievements a100 
-			ON p.user_id = a100.user_id 
-			AND a100.achievement_type = '100_days'
-		LEFT JOIN achievements a365 
-			ON p.user_id = a365.user_id 
-			AND a365.achievement_type = '365_days'
-		WHERE a100.user_id IS NOT NULL OR a365.user_id IS NOT NULL
-		ORDER BY 
-			a365.user_id IS NULL, 
-			a365.achieved_at DESC,
-			a100.achieved_at DESC
-	`)
+// shoutoutCooldown is how often one member can send a shout-out
+const shoutoutCooldown = 10 * time.Minute
+
+// handleShoutout posts public encouragement for another member: "/shoutout @user текст"
+func (b *Bot) handleShoutout(message *tgbotapi.Message) error {
+	chatID := message.Chat.ID
+	senderID := message.From.ID
+
+	args := strings.SplitN(strings.TrimSpace(message.CommandArguments()), " ", 2)
+	if len(args) != 2 || !strings.HasPrefix(args[0], "@") || strings.TrimSpace(args[1]) == "" {
+		msg := tgbotapi.NewMessage(chatID, Messages["shoutout_usage"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+	targetUsername := strings.TrimPrefix(args[0], "@")
+	note := strings.TrimSpace(args[1])
+
+	var senderName string
+	var senderChatID int64
+	err := b.db.QueryRow(`SELECT COALESCE(display_name, username), chat_id FROM participants WHERE user_id = ?`, senderID).Scan(&senderName, &senderChatID)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, Messages["not_participant"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var fame []struct {
-		Name           string
-		Achievement100 bool
-		Achievement365 bool
-		AchievedAt100  time.Time
-		AchievedAt365  time.Time
+	// The target must be a member of the same challenge
+	var targetID int64
+	var targetName string
+	err = b.db.QueryRow(`
+		SELECT user_id, COALESCE(display_name, username) FROM participants 
+		WHERE username = ? COLLATE NOCASE AND chat_id = ?
+	`, targetUsername, senderChatID).Scan(&targetID, &targetName)
+	if err == sql.ErrNoRows {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["shoutout_not_member"], targetUsername))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+	if err != nil {
+		return err
 	}
 
-	for rows.Next() {
-		var f struct {
-			Name           string
-			Achievement100 bool
-			Achievement365 bool
-			AchievedAt100  time.Time
-			AchievedAt365  time.Time
-		}
-		var achieved100, achieved365 sql.NullTime
-		if err := rows.Scan(&f.Name, &f.Achievement100, &f.Achievement365, &achieved100, &achieved365); err != nil {
-			return nil, err
-		}
+	if targetID == senderID {
+		msg := tgbotapi.NewMessage(chatID, Messages["shoutout_self"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
 
-		if achieved100.Valid {
-			f.AchievedAt100 = achieved100.Time
-		}
-		if achieved365.Valid {
-			f.AchievedAt365 = achieved365.Time
+	lastSent, err := b.getUserSetting(senderID, "last_shoutout", "0")
+	if err != nil {
+		return err
+	}
+	lastUnix, _ := strconv.ParseInt(lastSent, 10, 64)
+	if wait := shoutoutCooldown - time.Since(time.Unix(lastUnix, 0)); wait > 0 {
+		minutes := int(wait.Round(time.Minute).Minutes())
+		if minutes < 1 {
+			minutes = 1
 		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(Messages["shoutout_cooldown"], minutes))
+		_, err = b.sendMessage(msg)
+		return err
+	}
 
-		fame = append(fame, f)
+	msg := tgbotapi.NewMessage(chatID, formatShoutout(senderName, targetName, targetUsername, note))
+	if _, err := b.sendMessage(msg); err != nil {
+		return err
 	}
 
-	return fame, nil
+	return b.setUserSetting(senderID, "last_shoutout", strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// formatShoutout builds the public shout-out text tagging the target
+func formatShoutout(senderName, targetName, targetUsername, note string) string {
+	return fmt.Sprintf(Messages["shoutout"], senderName, targetName, targetUsername, note)
 }
 
 // handleListUserIDs lists all participants with their IDs
 func (b *Bot) handleListUserIDs(message *tgbotapi.Message) error {
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
 	rows, err := b.db.Query(`
 		SELECT 
 			user_id, 
@@ -1178,6 +8559,12 @@ func (b *Bot) handleListUserIDs(message *tgbotapi.Message) error {
 
 // handleAdjustStreak combines listing users and setting streak in one interactive command
 func (b *Bot) handleAdjustStreak(message *tgbotapi.Message) error {
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err := b.sendMessage(msg)
+		return err
+	}
+
 	// Step 1: Get the list of users
 	rows, err := b.db.Query(`
 		SELECT 
@@ -1227,6 +8614,12 @@ func (b *Bot) handleAdjustStreak(message *tgbotapi.Message) error {
 
 // handleAdjustStreakCallback processes the callback when a user is selected for streak adjustment
 func (b *Bot) handleAdjustStreakCallback(query *tgbotapi.CallbackQuery) error {
+	if !b.isAdmin(query.From.ID) {
+		callback := tgbotapi.NewCallback(query.ID, Messages["not_allowed"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+
 	// Parse the callback data: "adjust_streak:userID:name"
 	parts := strings.Split(query.Data, ":")
 	if len(parts) < 3 {
@@ -1269,12 +8662,18 @@ func (b *Bot) handleAdjustStreakCallback(query *tgbotapi.CallbackQuery) error {
 	)
 	editMsg.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: keyboard}
 
-	_, err = b.api.Send(editMsg)
+	_, err = b.send(editMsg)
 	return err
 }
 
 // handleSetStreakCallback processes the callback when a streak value is selected
 func (b *Bot) handleSetStreakCallback(query *tgbotapi.CallbackQuery) error {
+	if !b.isAdmin(query.From.ID) {
+		callback := tgbotapi.NewCallback(query.ID, Messages["not_allowed"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+
 	// Parse the callback data: "set_streak:userID:days"
 	parts := strings.Split(query.Data, ":")
 	if len(parts) != 3 {
@@ -1292,7 +8691,7 @@ func (b *Bot) handleSetStreakCallback(query *tgbotapi.CallbackQuery) error {
 	}
 
 	// Set the streak
-	err = b.SetUserStreak(userID, days)
+	err = b.SetUserStreak(query.From.ID, userID, days)
 	if err != nil {
 		// Edit message to show error
 		editMsg := tgbotapi.NewEditMessageText(
@@ -1300,7 +8699,7 @@ func (b *Bot) handleSetStreakCallback(query *tgbotapi.CallbackQuery) error {
 			query.Message.MessageID,
 			fmt.Sprintf("❌ Ошибка при установке серии: %s", err.Error()),
 		)
-		_, err = b.api.Send(editMsg)
+		_, err = b.send(editMsg)
 		return err
 	}
 
@@ -1318,7 +8717,7 @@ func (b *Bot) handleSetStreakCallback(query *tgbotapi.CallbackQuery) error {
 		query.Message.MessageID,
 		fmt.Sprintf("✅ Серия для %s установлена на %d %s", name, days, GetDayWord(days)),
 	)
-	_, err = b.api.Send(editMsg)
+	_, err = b.send(editMsg)
 	if err != nil {
 		return err
 	}
@@ -1329,6 +8728,12 @@ func (b *Bot) handleSetStreakCallback(query *tgbotapi.CallbackQuery) error {
 
 // handleCustomStreakCallback initiates the custom streak input process
 func (b *Bot) handleCustomStreakCallback(query *tgbotapi.CallbackQuery) error {
+	if !b.isAdmin(query.From.ID) {
+		callback := tgbotapi.NewCallback(query.ID, Messages["not_allowed"])
+		_, err := b.api.Request(callback)
+		return err
+	}
+
 	// Parse the callback data: "custom_streak:userID"
 	parts := strings.Split(query.Data, ":")
 	if len(parts) != 2 {
@@ -1366,7 +8771,7 @@ func (b *Bot) handleCustomStreakCallback(query *tgbotapi.CallbackQuery) error {
 	// Remove the inline keyboard
 	editMsg.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{}
 
-	_, err = b.api.Send(editMsg)
+	_, err = b.send(editMsg)
 	return err
 }
 
@@ -1388,6 +8793,13 @@ func (b *Bot) handleCustomStreakInput(message *tgbotapi.Message) error {
 		return err
 	}
 
+	// Admin rights may have been revoked since the prompt was shown
+	if !b.isAdmin(message.From.ID) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, Messages["not_allowed"])
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
 	// Parse the target user ID from the context
 	targetUserID, err := strconv.ParseInt(context, 10, 64)
 	if err != nil {
@@ -1402,8 +8814,15 @@ func (b *Bot) handleCustomStreakInput(message *tgbotapi.Message) error {
 		return err
 	}
 
+	// Keep waiting for input, so the admin can just send a smaller number
+	if days > b.maxStreakDays {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(Messages["streak_too_long"], b.maxStreakDays, GetDayWord(b.maxStreakDays)))
+		_, err = b.sendMessage(msg)
+		return err
+	}
+
 	// Set the streak
-	err = b.SetUserStreak(targetUserID, days)
+	err = b.SetUserStreak(message.From.ID, targetUserID, days)
 	if err != nil {
 		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("❌ Ошибка при установке серии: %s", err.Error()))
 		_, err = b.sendMessage(msg)
@@ -1435,6 +8854,544 @@ func (b *Bot) handleCustomStreakInput(message *tgbotapi.Message) error {
 	return b.sendParticipantsList(message.Chat.ID, message.From.ID)
 }
 
+// Telegram's service accounts that appear as From for messages sent on behalf of a chat
+const (
+	groupAnonymousBotID = 1087968824
+	channelBotID        = 136817688
+)
+
+// isAnonymousSender reports whether the message was sent on behalf of a chat, by an
+// anonymous group admin or a channel. Its From is not a real person, so it must not
+// become a participant or a completion.
+func isAnonymousSender(message *tgbotapi.Message) bool {
+	if message.SenderChat != nil {
+		return true
+	}
+	return message.From != nil && (message.From.ID == groupAnonymousBotID || message.From.ID == channelBotID)
+}
+
+// handleAnonymousSender explains to anonymous senders why their commands are ignored.
+// Ordinary chat messages are ignored silently.
+func (b *Bot) handleAnonymousSender(message *tgbotapi.Message) error {
+	b.logger.Info("ignoring message from anonymous sender", "chat_id", message.Chat.ID, "message_id", message.MessageID)
+
+	isButton := false
+	for _, label := range ButtonLabels {
+		if message.Text == label {
+			isButton = true
+			break
+		}
+	}
+	if !message.IsCommand() && !isButton {
+		return nil
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, Messages["anonymous_not_supported"])
+	msg.ReplyToMessageID = message.MessageID
+	_, err := b.sendMessage(msg)
+	return err
+}
+
+// App environments. Each one has its own bot token and database, so a bot
+// started on a developer's machine can't touch the production chat or data.
+const (
+	appEnvProd    = "prod"
+	appEnvStaging = "staging"
+	appEnvDev     = "dev"
+)
+
+// appEnv is the resolved configuration of an app environment
+type appEnv struct {
+	Name string
+	// TokenVar is the name of the env variable holding the bot token
+	TokenVar string
+	DBPath   string
+}
+
+// resolveAppEnv picks the token variable and database path for APP_ENV. An empty name
+// means prod. dbOverride, from DB_PATH, replaces the default database path, but dev
+// may never use the production database.
+func resolveAppEnv(name, dbOverride string) (appEnv, error) {
+	var env appEnv
+	switch name {
+	case "", appEnvProd:
+		env = appEnv{Name: appEnvProd, TokenVar: "BOT_TOKEN", DBPath: dbPath}
+	case appEnvStaging:
+		env = appEnv{Name: appEnvStaging, TokenVar: "STAGING_BOT_TOKEN", DBPath: dataDir + "/staging.db"}
+	case appEnvDev:
+		env = appEnv{Name: appEnvDev, TokenVar: "DEV_BOT_TOKEN", DBPath: dataDir + "/dev.db"}
+	default:
+		return appEnv{}, fmt.Errorf("unknown APP_ENV %q, expected prod, staging or dev", name)
+	}
+
+	if dbOverride != "" {
+		env.DBPath = dbOverride
+	}
+	if env.Name == appEnvDev && filepath.Clean(env.DBPath) == filepath.Clean(dbPath) {
+		return appEnv{}, fmt.Errorf("dev environment refuses to use the production database %s", dbPath)
+	}
+	return env, nil
+}
+
+// connectWithRetry creates the bot API, retrying up to attempts times with a backoff
+// that doubles after each failure, so a network hiccup on boot doesn't kill the bot.
+// NewBotAPI calls getMe, so a returned API has reached Telegram.
+func connectWithRetry(newAPI func() (*tgbotapi.BotAPI, error), attempts int, backoff time.Duration) (*tgbotapi.BotAPI, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var api *tgbotapi.BotAPI
+		api, err = newAPI()
+		if err == nil {
+			return api, nil
+		}
+
+		if attempt == attempts {
+			break
+		}
+		slog.Warn("failed to connect to Telegram, retrying",
+			"attempt", attempt,
+			"attempts", attempts,
+			"retry_in", backoff,
+			"error", err,
+		)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return nil, fmt.Errorf("failed to connect to Telegram after %d attempts: %w", attempts, err)
+}
+
+// processUpdate handles one update. A panic in a handler is logged with the update
+// context and swallowed, so one bad update can't take the bot down for everyone.
+func (b *Bot) processUpdate(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Error("panic while handling update",
+				"panic", r,
+				"update_id", update.UpdateID,
+				"chat_id", getChatID(update),
+				"user_id", getUserID(update),
+				"update_type", getUpdateType(update),
+				"stack", string(debug.Stack()),
+			)
+		}
+	}()
+
+	b.handleUpdate(update)
+}
+
+// Circuit breaker settings: how many database failures in a row open it,
+// and how often a health probe checks whether the database is back
+const (
+	dbBreakerThreshold = 3
+	dbProbeInterval    = 30 * time.Second
+)
+
+// dbBreaker tracks database failures. Once open, updates aren't handled, scheduled jobs
+// are skipped and each chat gets a single notice, until a health probe finds the
+// database working again.
+type dbBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	nextProbe time.Time
+	notified  map[int64]bool
+}
+
+// isDBError reports whether err means the database itself is unusable (locked, full,
+// unreadable), as opposed to a bad query or a missing row
+func isDBError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrReadonly, sqlite3.ErrIoErr,
+			sqlite3.ErrCorrupt, sqlite3.ErrFull, sqlite3.ErrCantOpen, sqlite3.ErrNotADB:
+			return true
+		}
+	}
+	return errors.Is(err, sql.ErrConnDone)
+}
+
+// recordDBFailure counts a database failure and reports whether it opened the breaker
+func (b *Bot) recordDBFailure() bool {
+	b.breaker.mu.Lock()
+	defer b.breaker.mu.Unlock()
+
+	b.breaker.failures++
+	if b.breaker.open || b.breaker.failures < dbBreakerThreshold {
+		return false
+	}
+
+	b.breaker.open = true
+	b.breaker.nextProbe = time.Now().Add(dbProbeInterval)
+	b.breaker.notified = make(map[int64]bool)
+	b.logger.Error("database unavailable, pausing updates and scheduled jobs", "failures", b.breaker.failures)
+	return true
+}
+
+// recordDBSuccess resets the failure count after an update was handled fine
+func (b *Bot) recordDBSuccess() {
+	b.breaker.mu.Lock()
+	b.breaker.failures = 0
+	b.breaker.mu.Unlock()
+}
+
+// dbAvailable reports whether updates and scheduled jobs may use the database. While the breaker is open,
+// the database is probed at most every dbProbeInterval and the breaker closes once it answers.
+func (b *Bot) dbAvailable() bool {
+	b.breaker.mu.Lock()
+	defer b.breaker.mu.Unlock()
+
+	if !b.breaker.open {
+		return true
+	}
+	if time.Now().Before(b.breaker.nextProbe) {
+		return false
+	}
+
+	var count int
+	if err := b.db.QueryRow(`SELECT COUNT(*) FROM participants`).Scan(&count); err != nil {
+		b.logger.Warn("database health probe failed", "error", err)
+		b.breaker.nextProbe = time.Now().Add(dbProbeInterval)
+		return false
+	}
+
+	b.logger.Info("database is back, resuming update handling")
+	b.breaker.open = false
+	b.breaker.failures = 0
+	return true
+}
+
+// notifyOutage tells the chat about the outage, once per chat while the breaker is open
+func (b *Bot) notifyOutage(chatID int64) {
+	if chatID == 0 {
+		return
+	}
+
+	b.breaker.mu.Lock()
+	if b.breaker.notified[chatID] {
+		b.breaker.mu.Unlock()
+		return
+	}
+	b.breaker.notified[chatID] = true
+	b.breaker.mu.Unlock()
+
+	// The chat's language is in the database, which may well not answer now
+	lang, err := b.chatLang(chatID)
+	if err != nil {
+		lang = DefaultLang
+	}
+	msg := tgbotapi.NewMessage(chatID, t(lang, "db_outage"))
+	if _, err := b.sendMessage(msg); err != nil {
+		b.logger.Error("failed to send outage notice", "chat_id", chatID, "error", err)
+	}
+}
+
+// handleUpdate routes an update to its handler and tells the user if handling failed
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	var err error
+
+	// Add context logging for each update
+	logger := b.logger.With(
+		"update_id", update.UpdateID,
+		"chat_id", getChatID(update),
+		"user_id", getUserID(update),
+	)
+
+	if !b.dbAvailable() {
+		logger.Warn("skipping update during database outage")
+		b.notifyOutage(getChatID(update))
+		return
+	}
+
+	if update.Message != nil {
+		logger.Info("received message",
+			"text", update.Message.Text,
+			"from", update.Message.From.UserName,
+			"message_id", update.Message.MessageID,
+		)
+		if isAnonymousSender(update.Message) {
+			if err := b.handleAnonymousSender(update.Message); err != nil {
+				logger.Error("failed to answer anonymous sender", "error", err)
+			}
+			return
+		}
+
+		if len(update.Message.NewChatMembers) > 0 {
+			if err := b.handleNewChatMembers(update.Message); err != nil {
+				logger.Error("failed to handle new chat members", "error", err)
+			}
+			return
+		}
+
+		switch update.Message.Text {
+		case "/start":
+			err = b.handleStart(update.Message)
+		case "/refresh":
+			err = b.sendRefreshedList(update.Message.Chat.ID, update.Message.From.ID)
+		case "Обновить":
+			err = b.sendRefreshedList(update.Message.Chat.ID, update.Message.From.ID)
+		case "Сделать зарядочку":
+			// Create a fake callback query to reuse existing logic
+			fakeQuery := &tgbotapi.CallbackQuery{
+				Message: update.Message,
+				From:    update.Message.From,
+				Data:    "complete_challenge",
+			}
+			err = b.handleCompleteChallenge(fakeQuery)
+		case "Отменить зарядочку":
+			// Same fake callback query as above, answered with a message
+			fakeQuery := &tgbotapi.CallbackQuery{
+				Message: update.Message,
+				From:    update.Message.From,
+				Data:    "undo_complete",
+			}
+			err = b.handleUndoComplete(fakeQuery)
+		case "Отметить за вчера":
+			err = b.handleMarkYesterday(update.Message)
+		case "/listuserids":
+			err = b.handleListUserIDs(update.Message)
+		case "/adjuststreak":
+			err = b.handleAdjustStreak(update.Message)
+		case "/backfill":
+			err = b.handleBackfillToToday(update.Message)
+		case "/stats":
+			err = b.handleStats(update.Message)
+		case "/longeststreakever":
+			err = b.handleLongestStreakEver(update.Message)
+		case "/backup":
+			err = b.handleBackup(update.Message)
+		case "/export":
+			err = b.handleExport(update.Message)
+		case "/rules":
+			err = b.sendRules(update.Message.Chat.ID)
+		case "/riskremind":
+			err = b.handleRiskRemind(update.Message)
+		case "/reconcile":
+			err = b.handleReconcile(update.Message)
+		case "/status":
+			err = b.handleStatus(update.Message)
+		case "/cancel":
+			err = b.handleCancel(update.Message)
+		case "/skiptoday":
+			err = b.handleSkipToday(update.Message)
+		case "/refreshkeyboards":
+			err = b.handleRefreshKeyboards(update.Message)
+		case "/markall":
+			err = b.handleMarkAll(update.Message)
+		case "/teamstats":
+			err = b.handleTeamStats(update.Message)
+		case "/audit":
+			err = b.handleAudit(update.Message)
+		case "/globalstats":
+			err = b.handleGlobalStats(update.Message)
+		case "/help":
+			err = b.handleHelp(update.Message)
+		case "/calendar":
+			err = b.handleCalendar(update.Message)
+		case "/leaderboard":
+			err = b.handleLeaderboard(update.Message)
+		case "/history":
+			err = b.handleHistory(update.Message)
+		case "/rename", "/me":
+			err = b.handleRename(update.Message)
+		case "/markdate":
+			err = b.handleMarkDate(update.Message)
+		case "/remindersettings":
+			err = b.handleReminderSettings(update.Message)
+		default:
+			// Check for commands with parameters
+			if update.Message.Command() == "setdeadline" {
+				err = b.handleSetDeadline(update.Message)
+			} else if update.Message.Command() == "setrules" {
+				err = b.handleSetRules(update.Message)
+			} else if update.Message.Command() == "setlang" {
+				err = b.handleSetLang(update.Message)
+			} else if update.Message.Command() == "compare" {
+				err = b.handleCompare(update.Message)
+			} else if update.Message.Command() == "dump" {
+				err = b.handleDump(update.Message)
+			} else if update.Message.Command() == "silentreminders" {
+				err = b.handleSilentReminders(update.Message)
+			} else if update.Message.Command() == "streakmode" {
+				err = b.handleStreakMode(update.Message)
+			} else if update.Message.Command() == "shoutout" {
+				err = b.handleShoutout(update.Message)
+			} else if update.Message.Command() == "leave" {
+				err = b.handleLeave(update.Message)
+			} else if update.Message.Command() == "rejoin" {
+				err = b.handleRejoin(update.Message)
+			} else if update.Message.Command() == "link" {
+				err = b.handleLink(update.Message)
+			} else if update.Message.Command() == "unlink" {
+				err = b.handleUnlink(update.Message)
+			} else if update.Message.Command() == "achievementscope" {
+				err = b.handleAchievementScope(update.Message)
+			} else if update.Message.Command() == "vacation" {
+				err = b.handleVacation(update.Message)
+			} else if update.Message.Command() == "streakcap" {
+				err = b.handleStreakCap(update.Message)
+			} else if update.Message.Command() == "goal" {
+				err = b.handleGoal(update.Message)
+			} else if update.Message.Command() == "freeze" {
+				err = b.handleFreeze(update.Message)
+			} else if update.Message.Command() == "today" {
+				err = b.handleToday(update.Message)
+			} else if update.Message.Command() == "skipmode" {
+				err = b.handleSkipMode(update.Message)
+			} else if update.Message.Command() == "streakmin" {
+				err = b.handleStreakMin(update.Message)
+			} else if update.Message.Command() == "fame" {
+				err = b.handleFame(update.Message)
+			} else if update.Message.Command() == "walkoffame" {
+				err = b.handleWalkOfFame(update.Message)
+			} else if update.Message.Command() == "clearachievement" {
+				err = b.handleClearAchievement(update.Message)
+			} else if update.Message.Command() == "quote" {
+				err = b.handleQuote(update.Message)
+			} else if update.Message.Command() == "countdown" {
+				err = b.handleCountdown(update.Message)
+			} else if update.Message.Command() == "groupthreshold" {
+				err = b.handleGroupThreshold(update.Message)
+			} else if update.Message.Command() == "remindat" {
+				err = b.handleRemindAt(update.Message)
+			} else if update.Message.Command() == "mute" {
+				err = b.handleMute(update.Message)
+			} else if update.Message.Command() == "unmute" {
+				err = b.handleUnmute(update.Message)
+			} else if update.Message.Command() == "timezone" {
+				err = b.handleTimezone(update.Message)
+			} else if update.Message.Command() == "tiers" {
+				err = b.handleTiers(update.Message)
+			} else if update.Message.Command() == "weekgrid" {
+				err = b.handleWeekGrid(update.Message)
+			} else if update.Message.Command() == "titles" {
+				err = b.handleTitles(update.Message)
+			} else if update.Message.Command() == "certificate" {
+				err = b.handleCertificate(update.Message)
+			} else if update.Message.Command() == "team" {
+				err = b.handleTeam(update.Message)
+			} else if update.Message.Command() == "importstreak" {
+				err = b.handleImportStreak(update.Message)
+			} else if update.Message.Command() == "allowimport" {
+				err = b.handleAllowImport(update.Message)
+			} else if update.Message.Command() == "milestonehighlight" {
+				err = b.handleMilestoneHighlight(update.Message)
+			} else if update.Message.Command() == "digest" {
+				err = b.handleDigest(update.Message)
+			} else if strings.HasPrefix(update.Message.Text, "/setstreak") {
+				// Replace with the new command to avoid breaking existing functionality
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Команда /setstreak устарела. Пожалуйста, используйте команду /adjuststreak для установки серии зарядок.")
+				_, err = b.sendMessage(msg)
+			} else {
+				// Check if we're waiting for a custom streak input
+				var exists bool
+				err = b.db.QueryRow(`
+					SELECT EXISTS(
+						SELECT 1 FROM bot_state 
+						WHERE user_id = ? AND chat_id = ? AND state = 'waiting_custom_streak'
+					)
+				`, update.Message.From.ID, update.Message.Chat.ID).Scan(&exists)
+
+				var renaming bool
+				if err == nil && !exists {
+					renaming, err = b.hasBotState(update.Message.From.ID, update.Message.Chat.ID, "waiting_rename")
+				}
+
+				if err == nil && exists {
+					err = b.handleCustomStreakInput(update.Message)
+				} else if err == nil && renaming {
+					err = b.handleRenameInput(update.Message)
+				} else if err == nil {
+					// Handle name response if applicable
+					var nameReply bool
+					nameReply, err = b.isNameReply(update.Message)
+					if err == nil && nameReply {
+						err = b.handleNameResponse(update.Message)
+					}
+				}
+			}
+		}
+	} else if update.CallbackQuery != nil {
+		logger.Info("received callback query",
+			"data", update.CallbackQuery.Data,
+			"from", update.CallbackQuery.From.UserName,
+		)
+
+		// Extract the prefix from the callback data
+		callbackData := update.CallbackQuery.Data
+		var callbackPrefix string
+		if strings.Contains(callbackData, ":") {
+			callbackPrefix = strings.Split(callbackData, ":")[0]
+		} else {
+			callbackPrefix = callbackData
+		}
+
+		// Handle different callback types
+		switch {
+		case b.duplicateCallback(update.CallbackQuery):
+			// Still answer it to stop the client spinner
+			logger.Info("ignoring duplicate callback query", "data", callbackData)
+			_, err = b.api.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		case callbackData == "join_challenge":
+			err = b.handleJoinChallenge(update.CallbackQuery)
+		case callbackData == "complete_challenge":
+			err = b.handleCompleteChallenge(update.CallbackQuery)
+		case callbackData == "undo_complete":
+			err = b.handleUndoComplete(update.CallbackQuery)
+		case callbackData == "cancel_state":
+			err = b.handleCancelCallback(update.CallbackQuery)
+		case callbackData == "update_list":
+			err = b.handleUpdateList(update.CallbackQuery)
+		case callbackPrefix == "adjust_streak":
+			err = b.handleAdjustStreakCallback(update.CallbackQuery)
+		case callbackPrefix == "set_streak":
+			err = b.handleSetStreakCallback(update.CallbackQuery)
+		case callbackPrefix == "custom_streak":
+			err = b.handleCustomStreakCallback(update.CallbackQuery)
+		case callbackPrefix == "dump":
+			err = b.handleDumpCallback(update.CallbackQuery)
+		case callbackPrefix == "clap":
+			err = b.handleClapCallback(update.CallbackQuery)
+		case callbackPrefix == "tier":
+			err = b.handleTierCallback(update.CallbackQuery)
+		case callbackPrefix == "rename":
+			err = b.handleRenameCallback(update.CallbackQuery)
+		case callbackPrefix == "markdate":
+			err = b.handleMarkDateCallback(update.CallbackQuery)
+		case callbackPrefix == "undo_confirm" || callbackPrefix == "undo_cancel":
+			err = b.handleUndoCallback(update.CallbackQuery)
+		case callbackPrefix == "leave_confirm" || callbackPrefix == "leave_cancel":
+			err = b.handleLeaveCallback(update.CallbackQuery)
+		case callbackPrefix == "link_accept" || callbackPrefix == "link_decline":
+			err = b.handleLinkCallback(update.CallbackQuery)
+		}
+	}
+
+	if err != nil {
+		logger.Error("failed to handle update",
+			"error", err,
+			"update_type", getUpdateType(update),
+		)
+		if isDBError(err) && b.recordDBFailure() {
+			b.notifyOutage(getChatID(update))
+		} else {
+			b.reportError(getChatID(update), err)
+		}
+	} else {
+		b.recordDBSuccess()
+	}
+
+	if userID := getUserID(update); userID != 0 {
+		if err := b.touchLastSeen(userID); err != nil {
+			logger.Error("failed to update last seen", "error", err)
+		}
+	}
+}
+
 func main() {
 	// Configure structured logging
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
@@ -1449,14 +9406,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	db, err := initDB()
+	challengeLocation = loadChallengeLocation(os.Getenv("TIMEZONE"))
+	slog.Info("challenge timezone loaded", "timezone", challengeLocation.String())
+
+	env, err := resolveAppEnv(os.Getenv("APP_ENV"), os.Getenv("DB_PATH"))
+	if err != nil {
+		slog.Error("failed to resolve app environment", "error", err)
+		os.Exit(1)
+	}
+	slog.Warn("starting bot in "+strings.ToUpper(env.Name)+" environment",
+		"app_env", env.Name,
+		"token_var", env.TokenVar,
+		"db_path", env.DBPath,
+	)
+
+	db, err := initDB(env.DBPath)
 	if err != nil {
 		slog.Error("failed to initialize database", "error", err)
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	botAPI, err := tgbotapi.NewBotAPI(os.Getenv("BOT_TOKEN"))
+	botAPI, err := connectWithRetry(
+		func() (*tgbotapi.BotAPI, error) { return tgbotapi.NewBotAPI(os.Getenv(env.TokenVar)) },
+		getEnvInt("BOT_CONNECT_ATTEMPTS", 5),
+		time.Duration(getEnvInt("BOT_CONNECT_BACKOFF_SECONDS", 2))*time.Second,
+	)
 	if err != nil {
 		slog.Error("failed to create bot API", "error", err)
 		os.Exit(1)
@@ -1472,166 +9447,75 @@ func main() {
 	u.Timeout = 60
 
 	bot := NewBot(botAPI, db)
+	if err := bot.registerCommands(); err != nil {
+		slog.Error("failed to register bot commands", "error", err)
+	}
 	updates := botAPI.GetUpdatesChan(u)
 
 	rand.Seed(time.Now().UnixNano())
 
-	// Add ticker for daily reminders
+	// Check every minute for chats whose local reminder time has come
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		from := time.Now()
 		for {
-			loc, err := time.LoadLocation("Asia/Yekaterinburg")
-			if err != nil {
-				log.Fatalf("Error loading location: %v", err)
-			}
-			now := time.Now().In(loc)
-			nextNoon := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, loc)
-			nextEvening := time.Date(now.Year(), now.Month(), now.Day(), 21, 0, 0, 0, loc)
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
 
-			if now.After(nextNoon) {
-				nextNoon = nextNoon.Add(24 * time.Hour)
-			}
-			if now.After(nextEvening) {
-				nextEvening = nextEvening.Add(24 * time.Hour)
+			// Reminders due during a database outage go out once it's back
+			if !bot.dbAvailable() {
+				slog.Warn("skipping scheduled reminders during database outage")
+				continue
 			}
 
-			noonTimer := time.NewTimer(nextNoon.Sub(now))
-			eveningTimer := time.NewTimer(nextEvening.Sub(now))
-
-			select {
-			case <-noonTimer.C:
-				if err := bot.sendDailyReminders(); err != nil {
-					slog.Error("failed to send daily reminders",
-						"error", err,
-						"time", time.Now(),
-					)
-				}
-			case <-eveningTimer.C:
-				if err := bot.sendLastChanceReminders(); err != nil {
-					slog.Error("failed to send last chance reminders",
-						"error", err,
-						"time", time.Now(),
-					)
-				}
-			}
+			to := time.Now()
+			bot.runDueReminders(from, to)
+			bot.runDueChannelPost(from, to)
+			from = to
 		}
 	}()
 
-	for update := range updates {
-		var err error
+	// Check hourly for private weekly digests and stale joins
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			now := challengeNow()
+			nextHour := now.Truncate(time.Hour).Add(time.Hour)
+			time.Sleep(nextHour.Sub(now))
 
-		// Add context logging for each update
-		logger := slog.With(
-			"update_id", update.UpdateID,
-			"chat_id", getChatID(update),
-			"user_id", getUserID(update),
-		)
+			if !bot.dbAvailable() {
+				slog.Warn("skipping hourly jobs during database outage")
+				continue
+			}
 
-		if update.Message != nil {
-			logger.Info("received message",
-				"text", update.Message.Text,
-				"from", update.Message.From.UserName,
-				"message_id", update.Message.MessageID,
-			)
-			switch update.Message.Text {
-			case "/start":
-				err = bot.handleStart(update.Message)
-			case "/refresh":
-				err = bot.sendParticipantsList(update.Message.Chat.ID, update.Message.From.ID)
-			case "Обновить":
-				err = bot.sendParticipantsList(update.Message.Chat.ID, update.Message.From.ID)
-			case "Сделать зарядочку":
-				// Create a fake callback query to reuse existing logic
-				fakeQuery := &tgbotapi.CallbackQuery{
-					Message: update.Message,
-					From:    update.Message.From,
-					Data:    "complete_challenge",
-				}
-				err = bot.handleCompleteChallenge(fakeQuery)
-			case "Отметить за вчера":
-				err = bot.handleMarkYesterday(update.Message)
-			case "/listuserids":
-				err = bot.handleListUserIDs(update.Message)
-			case "/adjuststreak":
-				err = bot.handleAdjustStreak(update.Message)
-			case "/backfill":
-				err = bot.handleBackfillToToday(update.Message)
-			default:
-				// Check for commands with parameters
-				if strings.HasPrefix(update.Message.Text, "/setstreak") {
-					// Replace with the new command to avoid breaking existing functionality
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Команда /setstreak устарела. Пожалуйста, используйте команду /adjuststreak для установки серии зарядок.")
-					_, err = bot.sendMessage(msg)
-				} else {
-					// Check if we're waiting for a custom streak input
-					var exists bool
-					err = bot.db.QueryRow(`
-						SELECT EXISTS(
-							SELECT 1 FROM bot_state 
-							WHERE user_id = ? AND chat_id = ? AND state = 'waiting_custom_streak'
-						)
-					`, update.Message.From.ID, update.Message.Chat.ID).Scan(&exists)
-
-					if err == nil && exists {
-						err = bot.handleCustomStreakInput(update.Message)
-					} else if update.Message.ReplyToMessage != nil {
-						// Handle name response if applicable
-						var exists bool
-						err = bot.db.QueryRow(`
-							SELECT EXISTS(
-								SELECT 1 FROM pending_joins 
-								WHERE user_id = ? AND chat_id = ?
-							)
-						`, update.Message.From.ID, update.Message.Chat.ID).Scan(&exists)
-
-						if err == nil && exists {
-							err = bot.handleNameResponse(update.Message)
-						}
-					}
-				}
+			if err := bot.sendPersonalDigests(challengeNow()); err != nil {
+				slog.Error("failed to send personal digests",
+					"error", err,
+					"time", time.Now(),
+				)
 			}
-		} else if update.CallbackQuery != nil {
-			logger.Info("received callback query",
-				"data", update.CallbackQuery.Data,
-				"from", update.CallbackQuery.From.UserName,
-			)
 
-			// Extract the prefix from the callback data
-			callbackData := update.CallbackQuery.Data
-			var callbackPrefix string
-			if strings.Contains(callbackData, ":") {
-				callbackPrefix = strings.Split(callbackData, ":")[0]
-			} else {
-				callbackPrefix = callbackData
+			if err := bot.cleanupStalePendingJoins(); err != nil {
+				slog.Error("failed to clean up stale pending joins", "error", err)
 			}
 
-			// Handle different callback types
-			switch {
-			case callbackData == "join_challenge":
-				err = bot.handleJoinChallenge(update.CallbackQuery)
-			case callbackData == "complete_challenge":
-				err = bot.handleCompleteChallenge(update.CallbackQuery)
-			case callbackData == "undo_complete":
-				err = bot.handleUndoComplete(update.CallbackQuery)
-			case callbackData == "update_list":
-				err = bot.handleUpdateList(update.CallbackQuery)
-			case callbackPrefix == "adjust_streak":
-				err = bot.handleAdjustStreakCallback(update.CallbackQuery)
-			case callbackPrefix == "set_streak":
-				err = bot.handleSetStreakCallback(update.CallbackQuery)
-			case callbackPrefix == "custom_streak":
-				err = bot.handleCustomStreakCallback(update.CallbackQuery)
+			if err := bot.purgeLeftParticipants(); err != nil {
+				slog.Error("failed to purge participants who left", "error", err)
 			}
-		}
 
-		if err != nil {
-			logger.Error("failed to handle update",
-				"error", err,
-				"update_type", getUpdateType(update),
-			)
+			if bot.statsAggregation {
+				if err := bot.aggregateDailyStats(challengeNow().AddDate(0, 0, -1).Format("2006-01-02")); err != nil {
+					slog.Error("failed to aggregate daily stats", "error", err)
+				}
+			}
 		}
+	}()
+
+	for update := range updates {
+		bot.processUpdate(update)
 	}
 
 	// Wait for goroutine to finish (though it never will in practice)
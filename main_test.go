@@ -0,0 +1,3340 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mattn/go-sqlite3"
+)
+
+// testBotID is the user ID of the bot behind fakeTelegram
+const testBotID = 1000
+
+// fakeCall is one request the bot made to fakeTelegram
+type fakeCall struct {
+	method string
+	params map[string]string
+	// files are the uploaded files by field name
+	files map[string][]byte
+	// messageID is the ID of the message the call created, if any
+	messageID int
+}
+
+// fakeTelegram stands in for the Bot API: it records every request and answers it
+// like Telegram would, or with the error set for the method in failures
+type fakeTelegram struct {
+	mu       sync.Mutex
+	calls    []fakeCall
+	nextID   int
+	failures map[string]*tgbotapi.Error
+	// members are the chat member statuses getChatMember returns, by "chatID:userID"
+	members map[string]string
+}
+
+func (f *fakeTelegram) Do(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			return nil, err
+		}
+	} else if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	call := fakeCall{
+		method: req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:],
+		params: make(map[string]string),
+		files:  make(map[string][]byte),
+	}
+	for key, values := range req.Form {
+		call.params[key] = values[0]
+	}
+	if req.MultipartForm != nil {
+		for key, values := range req.MultipartForm.Value {
+			call.params[key] = values[0]
+		}
+		for key, headers := range req.MultipartForm.File {
+			file, err := headers[0].Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			call.files[key] = data
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body any
+	if apiErr, ok := f.failures[call.method]; ok {
+		body = map[string]any{
+			"ok":          false,
+			"error_code":  apiErr.Code,
+			"description": apiErr.Message,
+			"parameters":  map[string]any{"retry_after": apiErr.RetryAfter},
+		}
+	} else {
+		result := f.result(call)
+		if message, ok := result.(map[string]any); ok && call.method != "getMe" && call.method != "getChatMember" {
+			call.messageID = message["message_id"].(int)
+		}
+		body = map[string]any{"ok": true, "result": result}
+	}
+	if call.method != "getMe" {
+		f.calls = append(f.calls, call)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(string(data))),
+	}, nil
+}
+
+// result is Telegram's answer to a successful call. Caller holds f.mu.
+func (f *fakeTelegram) result(call fakeCall) any {
+	switch call.method {
+	case "getMe":
+		return map[string]any{"id": testBotID, "is_bot": true, "first_name": "Zaryadochka", "username": "zaryadochka_bot"}
+	case "getChatMember":
+		status := f.members[call.params["chat_id"]+":"+call.params["user_id"]]
+		if status == "" {
+			status = "member"
+		}
+		userID, _ := strconv.ParseInt(call.params["user_id"], 10, 64)
+		return map[string]any{"status": status, "user": map[string]any{"id": userID, "first_name": "Member"}}
+	case "answerCallbackQuery", "pinChatMessage", "unpinChatMessage", "setMyCommands", "setMessageReaction", "deleteMessage":
+		return true
+	}
+
+	f.nextID++
+	chatID, _ := strconv.ParseInt(call.params["chat_id"], 10, 64)
+	messageID := f.nextID
+	if id, err := strconv.Atoi(call.params["message_id"]); err == nil {
+		messageID = id
+	}
+	return map[string]any{
+		"message_id": messageID,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]any{"id": chatID, "type": "private"},
+		"text":       call.params["text"],
+	}
+}
+
+// sent returns the calls of the given method made so far
+func (f *fakeTelegram) sent(method string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []fakeCall
+	for _, call := range f.calls {
+		if call.method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// textsTo returns the texts of the messages sent to the chat
+func (f *fakeTelegram) textsTo(chatID int64) []string {
+	var texts []string
+	for _, call := range f.sent("sendMessage") {
+		if call.params["chat_id"] == strconv.FormatInt(chatID, 10) {
+			texts = append(texts, call.params["text"])
+		}
+	}
+	return texts
+}
+
+// reset forgets the calls made so far
+func (f *fakeTelegram) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// newTestDB opens a migrated in-memory database. A single connection keeps
+// every query on the same in-memory database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := applyMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestBot returns a bot on a fresh database talking to a fakeTelegram
+func newTestBot(t *testing.T) (*Bot, *fakeTelegram) {
+	t.Helper()
+
+	tg := &fakeTelegram{
+		failures: make(map[string]*tgbotapi.Error),
+		members:  make(map[string]string),
+	}
+	api, err := tgbotapi.NewBotAPIWithClient("test-token", "https://api.telegram.test/bot%s/%s", tg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file database, like in production: the bot runs queries while others are open,
+	// which would block on the single connection of newTestDB
+	db, err := initDB(filepath.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	b := NewBot(api, db)
+	b.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	b.sendLimiter = nil
+	b.markCooldown = 0
+	return b, tg
+}
+
+// addParticipant registers a participant of the chat
+func addParticipant(t *testing.T, b *Bot, userID, chatID int64, name string) {
+	t.Helper()
+
+	_, err := b.db.Exec(`INSERT INTO participants (user_id, chat_id, username, display_name) VALUES (?, ?, ?, ?)`,
+		userID, chatID, name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addCompletions marks the user's completions on the given dates
+func addCompletions(t *testing.T, b *Bot, userID int64, dates ...string) {
+	t.Helper()
+
+	for _, date := range dates {
+		_, err := b.db.Exec(`
+			INSERT INTO daily_completions (user_id, chat_id, completed_at)
+			SELECT user_id, chat_id, ? FROM participants WHERE user_id = ?
+		`, date, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// daysAgo is the date n days before today in challengeLocation
+func daysAgo(n int) string {
+	return challengeNow().AddDate(0, 0, -n).Format("2006-01-02")
+}
+
+// lastDays returns the dates of the last n days, today included
+func lastDays(n int) []string {
+	dates := make([]string, n)
+	for i := range dates {
+		dates[i] = daysAgo(i)
+	}
+	return dates
+}
+
+// command builds a command message from the user in the chat
+func command(userID, chatID int64, text string) *tgbotapi.Message {
+	name := strings.Fields(text)[0]
+	return &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID, FirstName: fmt.Sprintf("user%d", userID)},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType(chatID)},
+		Text:      text,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(name)}},
+	}
+}
+
+// chatType is the Telegram chat type of the chat ID: groups have negative IDs
+func chatType(chatID int64) string {
+	if chatID < 0 {
+		return "supergroup"
+	}
+	return "private"
+}
+
+func TestRiskRemindNudgesOnlyStreaksAtRisk(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.admins[1] = true
+	b.riskRemindMinStreak = 3
+
+	const chatID = -100
+	addParticipant(t, b, 1, chatID, "admin")
+	// At risk: a 5 day streak up to yesterday, nothing today
+	addParticipant(t, b, 2, chatID, "Anna")
+	addCompletions(t, b, 2, daysAgo(1), daysAgo(2), daysAgo(3), daysAgo(4), daysAgo(5))
+	// Below the threshold
+	addParticipant(t, b, 3, chatID, "Boris")
+	addCompletions(t, b, 3, daysAgo(1))
+	// Already done today
+	addParticipant(t, b, 4, chatID, "Vera")
+	addCompletions(t, b, 4, lastDays(6)...)
+
+	if err := b.handleRiskRemind(command(1, chatID, "/riskremind")); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := tg.textsTo(chatID)
+	if len(texts) != 2 {
+		t.Fatalf("got %d messages, want a nudge and a summary: %q", len(texts), texts)
+	}
+	if want := fmt.Sprintf(Messages["risk_reminder"], "Anna", 5, DayWord(DefaultLang, 5)); texts[0] != want {
+		t.Errorf("nudge = %q, want %q", texts[0], want)
+	}
+	if want := fmt.Sprintf(Messages["risk_remind_done"], 1); texts[1] != want {
+		t.Errorf("summary = %q, want %q", texts[1], want)
+	}
+}
+
+// callback builds a callback query from the user pressing a button under a message in the chat
+func callback(userID, chatID int64, data string) *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		ID:      fmt.Sprintf("cb-%d-%s", userID, data),
+		From:    &tgbotapi.User{ID: userID, FirstName: fmt.Sprintf("user%d", userID)},
+		Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: chatID, Type: chatType(chatID)}},
+		Data:    data,
+	}
+}
+
+// countRows counts the rows of the query's result
+func countRows(t *testing.T, b *Bot, query string, args ...any) int {
+	t.Helper()
+
+	var n int
+	if err := b.db.QueryRow(`SELECT COUNT(*) FROM (`+query+`)`, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDoubleStartSendsListOnce(t *testing.T) {
+	b, tg := newTestBot(t)
+	addParticipant(t, b, 7, 7, "Anna")
+
+	for i := 0; i < 2; i++ {
+		if err := b.handleStart(command(7, 7, "/start")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := b.buildParticipantsList(7, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := tg.textsTo(7)
+	if len(texts) != 2 || texts[0] != list || texts[1] != fmt.Sprintf(Messages["keyboard_refreshed"], "Anna") {
+		t.Fatalf("got %q, want the list and then only the keyboard", texts)
+	}
+	for i, call := range tg.sent("sendMessage") {
+		if !strings.Contains(call.params["reply_markup"], "keyboard") {
+			t.Errorf("message %d has no reply keyboard: %v", i, call.params)
+		}
+	}
+
+	// A /start in another chat sends the list there
+	tg.reset()
+	if err := b.handleStart(command(7, -100, "/start")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] == fmt.Sprintf(Messages["keyboard_refreshed"], "Anna") {
+		t.Errorf("got %q in another chat, want the list", texts)
+	}
+}
+
+func TestDoubleStartOffersToJoinOnce(t *testing.T) {
+	b, tg := newTestBot(t)
+	const userID = 7
+
+	for i := 0; i < 2; i++ {
+		if err := b.handleStart(command(userID, userID, "/start")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	texts := tg.textsTo(userID)
+	if len(texts) != 2 || texts[0] != Messages["want_to_join"] || texts[1] != Messages["join_offer_shown"] {
+		t.Fatalf("got %q, want the join offer and then a pointer to it", texts)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM start_offers WHERE user_id = ?`, userID); n != 1 {
+		t.Errorf("got %d start offers, want 1", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM pending_joins`); n != 0 {
+		t.Errorf("got %d pending joins before the join button was pressed, want 0", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.handleJoinChallenge(callback(userID, userID, "join_challenge")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countRows(t, b, `SELECT 1 FROM pending_joins WHERE user_id = ?`, userID); n != 1 {
+		t.Errorf("got %d pending joins, want 1", n)
+	}
+}
+
+func TestMarkCooldownRejectsQuickRedo(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.markCooldown = time.Minute
+
+	const userID = 7
+	addParticipant(t, b, userID, userID, "Anna")
+	today := daysAgo(0)
+
+	if err := b.completeChallenge(callback(userID, userID, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleUndoCallback(callback(userID, userID, fmt.Sprintf("undo_confirm:%d:%s", userID, today))); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := b.completedOn(userID, today); err != nil || done {
+		t.Fatalf("completed after undo = %v, %v; want false", done, err)
+	}
+
+	tg.reset()
+	if err := b.completeChallenge(callback(userID, userID, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := b.completedOn(userID, today); err != nil || done {
+		t.Errorf("completed within the cooldown = %v, %v; want false", done, err)
+	}
+	texts := tg.textsTo(userID)
+	cooldownPrefix := Messages["mark_cooldown"][:strings.Index(Messages["mark_cooldown"], "%")]
+	if len(texts) != 1 || !strings.HasPrefix(texts[0], cooldownPrefix) {
+		t.Errorf("got %q, want the cooldown message", texts)
+	}
+
+	// Another date isn't held up by the cooldown of today
+	if left, err := b.markCooldownLeft(userID, daysAgo(1)); err != nil || left != 0 {
+		t.Errorf("cooldown for yesterday = %v, %v; want 0", left, err)
+	}
+}
+
+func TestBeatingStreakRecordAnnouncesOnce(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 1, chatID, "Anna")
+	addCompletions(t, b, 1, daysAgo(20), daysAgo(21), daysAgo(22), daysAgo(23), daysAgo(24))
+	addParticipant(t, b, 2, chatID, "Boris")
+	addCompletions(t, b, 2, lastDays(5)...)
+	// A longer streak in another chat doesn't compete
+	addParticipant(t, b, 3, -200, "Vera")
+	addCompletions(t, b, 3, lastDays(30)...)
+
+	if holderID, record, err := b.getStreakRecord(chatID); err != nil || holderID != 1 || record.Length != 5 {
+		t.Fatalf("seeded record = %d, %d, %v; want Anna's 5 days", holderID, record.Length, err)
+	}
+
+	addCompletions(t, b, 2, daysAgo(5))
+	for i := 0; i < 2; i++ {
+		if err := b.updateStreakRecord(2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if holderID, record, err := b.getStreakRecord(chatID); err != nil || holderID != 2 || record.Length != 6 {
+		t.Errorf("record = %d, %d, %v; want Boris's 6 days", holderID, record.Length, err)
+	}
+	want := fmt.Sprintf(Messages["new_streak_record"], "Boris", 6, GetDayWord(6))
+	if texts := tg.textsTo(chatID); len(texts) != 1 || texts[0] != want {
+		t.Errorf("got %q, want one announcement %q", texts, want)
+	}
+}
+
+// inTempDir runs the test in a temporary working directory, for code writing to ./data
+func inTempDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// dumpTable lists the rows of a table as text, in a stable order
+func dumpTable(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump []string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			t.Fatal(err)
+		}
+		dump = append(dump, strings.TrimSpace(fmt.Sprintln(values...)))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return dump
+}
+
+func TestBackupReopensWithSameContents(t *testing.T) {
+	inTempDir(t)
+	b, tg := newTestBot(t)
+	b.superAdmins[1] = true
+
+	addParticipant(t, b, 1, 1, "admin")
+	addParticipant(t, b, 2, -100, "Anna")
+	addCompletions(t, b, 2, lastDays(3)...)
+	if err := b.setChatSetting(-100, "timezone", "Europe/Moscow"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleBackup(command(1, 1, "/backup")); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := tg.sent("sendDocument")
+	if len(docs) != 1 || len(docs[0].files["document"]) == 0 {
+		t.Fatalf("got %d documents, want one backup", len(docs))
+	}
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	if err := os.WriteFile(backupPath, docs[0].files["document"], 0600); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := sql.Open("sqlite3", backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+
+	for _, query := range []string{
+		`SELECT user_id, chat_id, display_name FROM participants ORDER BY user_id`,
+		`SELECT user_id, chat_id, completed_at FROM daily_completions ORDER BY user_id, completed_at`,
+		`SELECT chat_id, key, value FROM chat_settings ORDER BY chat_id, key`,
+		`SELECT MAX(version) FROM schema_version`,
+	} {
+		want, got := dumpTable(t, b.db, query), dumpTable(t, backup, query)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: backup has %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestEffectiveCompletionDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		timezone string
+		deadline string
+		policy   string
+		now      time.Time
+		wantDate string
+		wantOK   bool
+	}{
+		{"no deadline", "UTC", "", "", time.Date(2024, 3, 10, 23, 30, 0, 0, time.UTC), "2024-03-10", true},
+		{"before the deadline", "UTC", "20:00", deadlinePolicyReject, time.Date(2024, 3, 10, 19, 59, 0, 0, time.UTC), "2024-03-10", true},
+		{"late, rejected", "UTC", "20:00", deadlinePolicyReject, time.Date(2024, 3, 10, 20, 0, 0, 0, time.UTC), "2024-03-10", false},
+		{"late, next day", "UTC", "20:00", deadlinePolicyNextDay, time.Date(2024, 3, 10, 21, 15, 0, 0, time.UTC), "2024-03-11", true},
+		{"deadline in the chat's timezone", "Europe/Moscow", "20:00", deadlinePolicyReject, time.Date(2024, 3, 10, 17, 30, 0, 0, time.UTC), "2024-03-10", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBot(t)
+			const chatID = -100
+			for key, value := range map[string]string{"timezone": tt.timezone, "deadline": tt.deadline, "deadline_policy": tt.policy} {
+				if err := b.setChatSetting(chatID, key, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			date, ok, err := b.effectiveCompletionDate(chatID, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := date.Format("2006-01-02"); got != tt.wantDate || ok != tt.wantOK {
+				t.Errorf("effectiveCompletionDate = %s, %v; want %s, %v", got, ok, tt.wantDate, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPersonalDigestGoesOnlyToSubscribers(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	now := challengeNow()
+	if _, err := b.db.Exec(`INSERT INTO digest_subscriptions (user_id, weekday, hour) VALUES (1, ?, ?)`, int(now.Weekday()), now.Hour()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := b.sendPersonalDigests(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if texts := tg.textsTo(1); len(texts) != 1 {
+		t.Errorf("subscriber got %d digests, want 1", len(texts))
+	}
+	if texts := tg.textsTo(2); len(texts) != 0 {
+		t.Errorf("non-subscriber got %q, want nothing", texts)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 0 {
+		t.Errorf("group got %q, want nothing", texts)
+	}
+}
+
+// reply builds a plain text message from the user in the chat
+func reply(userID, chatID int64, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 2,
+		From:      &tgbotapi.User{ID: userID, FirstName: fmt.Sprintf("user%d", userID), UserName: fmt.Sprintf("user%d", userID)},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType(chatID)},
+		Text:      text,
+	}
+}
+
+func TestJoiningShowsChatRules(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	if err := b.setChatSetting(chatID, "rules", "Минимум 20 приседаний"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleJoinChallenge(callback(7, chatID, "join_challenge")); err != nil {
+		t.Fatal(err)
+	}
+	tg.reset()
+
+	if err := b.handleNameResponse(reply(7, chatID, "Anna")); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := tg.textsTo(chatID)
+	if want := Messages["rules_header"] + "\n\nМинимум 20 приседаний"; len(texts) == 0 || texts[0] != want {
+		t.Errorf("got %q, want the rules %q first", texts, want)
+	}
+}
+
+func TestInsertCompletionRefusesImpossibleDates(t *testing.T) {
+	tests := []struct {
+		name    string
+		date    string
+		wantErr bool
+	}{
+		{"today", daysAgo(0), false},
+		{"tomorrow, after a next day deadline", daysAgo(-1), false},
+		{"the day after tomorrow", daysAgo(-2), true},
+		{"next year", daysAgo(-365), true},
+		{"a week ago", daysAgo(7), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBot(t)
+			addParticipant(t, b, 7, -100, "Anna")
+			addCompletions(t, b, 7, daysAgo(1))
+
+			added, err := b.insertCompletion(b.db, 7, tt.date, "", "")
+			if tt.wantErr {
+				if !errors.Is(err, errImpossibleDate) {
+					t.Errorf("error = %v, want errImpossibleDate", err)
+				}
+				if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 7`); n != 1 {
+					t.Errorf("got %d completions, want only the seeded one", n)
+				}
+				return
+			}
+			if err != nil || !added {
+				t.Errorf("insertCompletion = %v, %v; want added", added, err)
+			}
+		})
+	}
+}
+
+func TestGroupUsesChatLanguageAndDMUsesUserLanguage(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 7, chatID, "Anna")
+	if err := b.setChatSetting(chatID, "lang", "en"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setUserSetting(7, "lang", "ru"); err != nil {
+		t.Fatal(err)
+	}
+
+	reminder, err := b.buildReminder(chatID, "reminder", challengeNow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reminder.Text, Translations["en"]["reminder"]) {
+		t.Errorf("group reminder = %q, want it in English", reminder.Text)
+	}
+
+	tests := []struct {
+		name           string
+		userID, chatID int64
+		want           string
+	}{
+		{"group ignores the user's language", 7, chatID, "en"},
+		{"DM uses the user's language", 7, 7, "ru"},
+		{"DM without an override uses the default", 8, 8, DefaultLang},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := b.resolveLang(tt.userID, tt.chatID); err != nil || got != tt.want {
+				t.Errorf("resolveLang(%d, %d) = %q, %v; want %q", tt.userID, tt.chatID, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// datesBetween lists the dates from first to last inclusive
+func datesBetween(first, last string) []string {
+	from, _ := time.Parse("2006-01-02", first)
+	to, _ := time.Parse("2006-01-02", last)
+
+	var dates []string
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		dates = append(dates, day.Format("2006-01-02"))
+	}
+	return dates
+}
+
+func TestCompareWeekDelta(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	for _, p := range []struct {
+		userID int64
+		joined string
+		dates  []string
+	}{
+		{1, "2024-01-01", datesBetween("2024-03-04", "2024-03-17")},
+		{2, "2024-01-01", datesBetween("2024-03-11", "2024-03-17")},
+		// Joined in the second week, counts only from then
+		{3, "2024-03-14", datesBetween("2024-03-14", "2024-03-17")},
+	} {
+		addParticipant(t, b, p.userID, -100, fmt.Sprintf("user%d", p.userID))
+		if _, err := b.db.Exec(`UPDATE participants SET joined_at = ? WHERE user_id = ?`, p.joined+" 10:00:00", p.userID); err != nil {
+			t.Fatal(err)
+		}
+		addCompletions(t, b, p.userID, p.dates...)
+	}
+
+	day := func(date string) time.Time {
+		parsed, _ := time.Parse("2006-01-02", date)
+		return parsed
+	}
+	previous, ok, err := b.groupCompletionRate(-100, day("2024-03-04"), day("2024-03-10"))
+	if err != nil || !ok || previous != 0.5 {
+		t.Fatalf("last week = %v, %v, %v; want 0.5", previous, ok, err)
+	}
+	current, ok, err := b.groupCompletionRate(-100, day("2024-03-11"), day("2024-03-17"))
+	if err != nil || !ok || current != 1 {
+		t.Fatalf("this week = %v, %v, %v; want 1", current, ok, err)
+	}
+
+	if delta, arrow := rateTrend(current, previous); delta != 50 || arrow != "⬆️" {
+		t.Errorf("rateTrend = %v %s, want 50 ⬆️", delta, arrow)
+	}
+	if delta, arrow := rateTrend(previous, current); delta != -50 || arrow != "⬇️" {
+		t.Errorf("rateTrend = %v %s, want -50 ⬇️", delta, arrow)
+	}
+	if _, arrow := rateTrend(0.502, 0.5); arrow != "➡️" {
+		t.Errorf("rateTrend arrow for a tiny change = %s, want ➡️", arrow)
+	}
+}
+
+func TestMigrateCompletionChatIDs(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	// The schema before completions had a chat_id
+	_, err = db.Exec(`
+		CREATE TABLE participants (user_id INTEGER PRIMARY KEY, username TEXT, chat_id INTEGER, display_name TEXT);
+		CREATE TABLE daily_completions (user_id INTEGER, completed_at DATE, congrats_message TEXT, PRIMARY KEY (user_id, completed_at));
+		INSERT INTO participants (user_id, chat_id) VALUES (1, -100), (2, -200);
+		INSERT INTO daily_completions (user_id, completed_at) VALUES (1, '2024-03-01'), (1, '2024-03-02'), (2, '2024-03-01'), (3, '2024-03-01');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"1 2024-03-01 -100",
+		"1 2024-03-02 -100",
+		"2 2024-03-01 -200",
+		// Not a participant anymore, left alone
+		"3 2024-03-01 <nil>",
+	}
+	for run := 1; run <= 2; run++ {
+		if err := migrateCompletionChatIDs(db); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		got := dumpTable(t, db, `SELECT user_id, date(completed_at), chat_id FROM daily_completions ORDER BY user_id, completed_at`)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("run %d: got %q, want %q", run, got, want)
+		}
+	}
+}
+
+func TestDumpListsSeededDatesInOrder(t *testing.T) {
+	b, _ := newTestBot(t)
+	addParticipant(t, b, 7, -100, "Anna")
+	addCompletions(t, b, 7, "2024-03-05", "2024-01-31", "2024-03-01")
+
+	text, keyboard, err := b.buildDumpPage(7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf(Messages["dump_header"], 7, 3, 1, 1) + "\n\n2024-01-31\n2024-03-01\n2024-03-05\n"
+	if text != want {
+		t.Errorf("dump = %q, want %q", text, want)
+	}
+	if keyboard != nil {
+		t.Errorf("a single page has page buttons")
+	}
+}
+
+func TestSilentReminders(t *testing.T) {
+	tests := []struct {
+		setting string
+		hour    int
+		want    bool
+	}{
+		{"", 12, false},
+		{"always", 12, true},
+		{"22-8", 23, true},
+		{"22-8", 7, true},
+		{"22-8", 8, false},
+		{"22-8", 12, false},
+		{"13-15", 14, true},
+		{"13-15", 12, false},
+		{"nonsense", 12, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s at %d", tt.setting, tt.hour), func(t *testing.T) {
+			b, _ := newTestBot(t)
+			const chatID = -100
+			if err := b.setChatSetting(chatID, "silent_reminders", tt.setting); err != nil {
+				t.Fatal(err)
+			}
+
+			now := time.Date(2024, 3, 10, tt.hour, 30, 0, 0, time.UTC)
+			msg, err := b.buildReminder(chatID, "reminder", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.DisableNotification != tt.want {
+				t.Errorf("DisableNotification = %v, want %v", msg.DisableNotification, tt.want)
+			}
+		})
+	}
+}
+
+// completedSet builds the completed days of rollingStreak from dates relative to today:
+// 0 is today, 1 yesterday and so on
+func completedSet(today time.Time, daysAgo ...int) map[string]bool {
+	completed := make(map[string]bool)
+	for _, n := range daysAgo {
+		completed[today.AddDate(0, 0, -n).Format("2006-01-02")] = true
+	}
+	return completed
+}
+
+func TestRollingStreak(t *testing.T) {
+	today := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		daysAgo    []int
+		minPerWeek int
+		want       int
+	}{
+		{"no completions", nil, 5, 0},
+		{"a single completion today", []int{0}, 1, 1},
+		{"a single completion today, five needed", []int{0}, 5, 0},
+		// The first days of the history can't fill a window yet
+		{"every day for ten days", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 7, 4},
+		{"weekends off", []int{1, 2, 3, 4, 5, 8, 9, 10, 11, 12}, 5, 9},
+		{"weekends off, seven of seven", []int{1, 2, 3, 4, 5, 8, 9, 10, 11, 12}, 7, 0},
+		{"today not qualifying yet doesn't break it", []int{1, 2, 3, 4, 5, 6, 7, 8}, 7, 2},
+		{"a gap too long", []int{0, 1, 2, 3, 4, 10, 11, 12, 13, 14}, 5, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rollingStreak(completedSet(today, tt.daysAgo...), today, tt.minPerWeek); got != tt.want {
+				t.Errorf("rollingStreak = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollingVsStrictStreak(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 7, chatID, "Anna")
+	// Six days done, one missed, six more done: the miss breaks only the strict streak
+	for _, n := range []int{0, 1, 2, 3, 4, 5, 7, 8, 9, 10, 11, 12} {
+		addCompletions(t, b, 7, daysAgo(n))
+	}
+
+	strict, err := b.getIndividualStreak(7)
+	if err != nil || strict != 6 {
+		t.Errorf("strict streak = %d, %v; want 6", strict, err)
+	}
+
+	if err := b.setChatSetting(chatID, "streak_mode", streakModeRolling); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setChatSetting(chatID, "streak_rolling_min", "5"); err != nil {
+		t.Fatal(err)
+	}
+	rolling, err := b.getIndividualStreak(7)
+	if err != nil || rolling != 9 {
+		t.Errorf("rolling streak = %d, %v; want 9", rolling, err)
+	}
+}
+
+func TestShoutout(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 1, chatID, "Anna")
+	addParticipant(t, b, 2, chatID, "Boris")
+	// Another chat's members can't be cheered
+	addParticipant(t, b, 3, -200, "Vera")
+
+	for _, target := range []string{"ghost", "Vera"} {
+		if err := b.handleShoutout(command(1, chatID, "/shoutout @"+target+" так держать")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.handleShoutout(command(1, chatID, "/shoutout @boris так держать")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		fmt.Sprintf(Messages["shoutout_not_member"], "ghost"),
+		fmt.Sprintf(Messages["shoutout_not_member"], "Vera"),
+		fmt.Sprintf(Messages["shoutout"], "Anna", "Boris", "boris", "так держать"),
+	}
+	if got := tg.textsTo(chatID); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAchievementTargets(t *testing.T) {
+	const userID, groupID = 7, -100
+
+	tests := []struct {
+		scope  string
+		chatID int64
+		want   []int64
+	}{
+		{achievementScopeGroup, groupID, []int64{groupID}},
+		{achievementScopeDM, groupID, []int64{userID}},
+		{achievementScopeBoth, groupID, []int64{groupID, userID}},
+		{"", groupID, []int64{groupID}},
+		// Joined in private: the group and the DM are the same chat
+		{achievementScopeGroup, userID, []int64{userID}},
+		{achievementScopeDM, userID, []int64{userID}},
+		{achievementScopeBoth, userID, []int64{userID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s in %d", tt.scope, tt.chatID), func(t *testing.T) {
+			if got := achievementTargets(tt.scope, userID, tt.chatID); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("achievementTargets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileAchievements(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, datesBetween("2024-03-01", "2024-03-08")...)
+	addParticipant(t, b, 2, -100, "Boris")
+	addCompletions(t, b, 2, datesBetween("2024-03-01", "2024-03-03")...)
+	if _, err := b.db.Exec(`INSERT INTO achievements (user_id, achievement_type, achieved_at) VALUES (2, '30_days', '2024-03-03')`); err != nil {
+		t.Fatal(err)
+	}
+
+	granted, orphaned, err := b.reconcileAchievements()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"Anna — 7_days"}; fmt.Sprint(granted) != fmt.Sprint(want) {
+		t.Errorf("granted = %q, want %q", granted, want)
+	}
+	var achievedAt string
+	if err := b.db.QueryRow(`SELECT date(achieved_at) FROM achievements WHERE user_id = 1 AND achievement_type = '7_days'`).Scan(&achievedAt); err != nil || achievedAt != "2024-03-07" {
+		t.Errorf("granted achievement dated %q, %v; want the day it was reached, 2024-03-07", achievedAt, err)
+	}
+
+	if want := []string{fmt.Sprintf("Boris — 30_days (%s 3)", Messages["reconcile_longest"])}; fmt.Sprint(orphaned) != fmt.Sprint(want) {
+		t.Errorf("orphaned = %q, want %q", orphaned, want)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM achievements WHERE user_id = 2`); n != 1 {
+		t.Errorf("the unsupported achievement was removed, want it only flagged")
+	}
+}
+
+func TestBotAddedToGroupIsWelcomed(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []tgbotapi.User
+		want    int
+	}{
+		{"the bot", []tgbotapi.User{{ID: testBotID, IsBot: true}}, 1},
+		{"the bot with people", []tgbotapi.User{{ID: 7}, {ID: testBotID, IsBot: true}}, 1},
+		{"only people", []tgbotapi.User{{ID: 7}, {ID: 8}}, 0},
+		{"another bot", []tgbotapi.User{{ID: 99, IsBot: true}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, tg := newTestBot(t)
+			message := reply(7, -100, "")
+			message.NewChatMembers = tt.members
+
+			if err := b.handleNewChatMembers(message); err != nil {
+				t.Fatal(err)
+			}
+			if texts := tg.textsTo(-100); len(texts) != tt.want {
+				t.Errorf("got %d welcomes, want %d", len(texts), tt.want)
+			}
+		})
+	}
+}
+
+func TestStreakSurvivesVacation(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, daysAgo(0), daysAgo(1), daysAgo(2), daysAgo(10), daysAgo(11), daysAgo(12), daysAgo(13), daysAgo(14))
+	addParticipant(t, b, 2, -100, "Boris")
+	addCompletions(t, b, 2, daysAgo(0), daysAgo(1), daysAgo(2), daysAgo(10), daysAgo(11), daysAgo(12), daysAgo(13), daysAgo(14))
+	// Only Anna declared the gap as a vacation
+	if _, err := b.db.Exec(`INSERT INTO vacations (user_id, started_at, ended_at) VALUES (1, ?, ?)`, daysAgo(9), daysAgo(3)); err != nil {
+		t.Fatal(err)
+	}
+	// Vera is still on vacation
+	addParticipant(t, b, 3, -100, "Vera")
+	addCompletions(t, b, 3, daysAgo(5), daysAgo(6), daysAgo(7))
+	if _, err := b.db.Exec(`INSERT INTO vacations (user_id, started_at) VALUES (3, ?)`, daysAgo(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		userID int64
+		want   int
+	}{{1, 8}, {2, 3}, {3, 3}} {
+		if got, err := b.getIndividualStreak(tt.userID); err != nil || got != tt.want {
+			t.Errorf("streak of %d = %d, %v; want %d", tt.userID, got, err, tt.want)
+		}
+	}
+}
+
+func TestErrorMessageKey(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	tests := []struct {
+		name     string
+		err      error
+		wantKey  string
+		wantSend bool
+	}{
+		{"database busy", busy, "error_try_later", true},
+		{"wrapped database error", fmt.Errorf("saving completion: %w", busy), "error_try_later", true},
+		{"bad query", sqlite3.Error{Code: sqlite3.ErrError}, "error_unexpected", true},
+		{"other error", errors.New("boom"), "error_unexpected", true},
+		{"explained error", withUserMessage("error_marking_yesterday", busy), "error_marking_yesterday", true},
+		{"telegram error", &tgbotapi.Error{Code: 400, Message: "Bad Request"}, "", false},
+		{"send being retried", fmt.Errorf("%w: %w", errSendRetrying, errors.New("timeout")), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, send := errorMessageKey(tt.err)
+			if key != tt.wantKey || send != tt.wantSend {
+				t.Errorf("errorMessageKey = %q, %v; want %q, %v", key, send, tt.wantKey, tt.wantSend)
+			}
+		})
+	}
+}
+
+func TestReportDBError(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	b.reportError(7, fmt.Errorf("saving completion: %w", sqlite3.Error{Code: sqlite3.ErrLocked}))
+
+	if texts := tg.textsTo(7); len(texts) != 1 || texts[0] != Messages["error_try_later"] {
+		t.Errorf("got %q, want %q", texts, Messages["error_try_later"])
+	}
+}
+
+func TestStreakCapInListsOnly(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 7, chatID, "Anna")
+	addCompletions(t, b, 7, lastDays(12)...)
+	if err := b.setChatSetting(chatID, "display_streak_cap", "5"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := b.buildParticipantsList(chatID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capped := "5+ " + GetDayWord(5); !strings.Contains(list, capped) || strings.Contains(list, "12 "+GetDayWord(12)) {
+		t.Errorf("list = %q, want the streak shown as %q", list, capped)
+	}
+
+	if err := b.handleStats(command(7, chatID, "/stats")); err != nil {
+		t.Fatal(err)
+	}
+	texts := tg.textsTo(chatID)
+	if trueStreak := fmt.Sprintf(Messages["stats_streak"], 12, GetDayWord(12)); len(texts) != 1 || !strings.Contains(texts[0], trueStreak) {
+		t.Errorf("stats = %q, want the true streak %q", texts, trueStreak)
+	}
+}
+
+func TestClapButtonUpdatesTally(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 1, chatID, "Anna")
+	addParticipant(t, b, 2, chatID, "Boris")
+	addParticipant(t, b, 3, chatID, "Vera")
+	today := daysAgo(0)
+	addCompletions(t, b, 1, today)
+	data := fmt.Sprintf("clap:1:%s", today)
+
+	// Boris twice, Vera once, and Anna for herself
+	for _, reactorID := range []int64{2, 2, 3, 1} {
+		if err := b.handleClapCallback(callback(reactorID, chatID, data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := countRows(t, b, `SELECT 1 FROM completion_reactions WHERE user_id = 1 AND completed_at = ?`, today); n != 2 {
+		t.Errorf("stored %d claps, want 2", n)
+	}
+	edits := tg.sent("editMessageReplyMarkup")
+	if len(edits) != 2 {
+		t.Fatalf("got %d button updates, want one per counted clap", len(edits))
+	}
+	if markup := edits[1].params["reply_markup"]; !strings.Contains(markup, "👏 2") {
+		t.Errorf("button = %s, want the tally 2", markup)
+	}
+}
+
+func TestPersonalGoal(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	addParticipant(t, b, 7, chatID, "Anna")
+	addCompletions(t, b, 7, daysAgo(1), daysAgo(2), daysAgo(3), daysAgo(4))
+
+	if err := b.handleGoal(command(7, chatID, "/goal 5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleGoal(command(7, chatID, "/goal")); err != nil {
+		t.Fatal(err)
+	}
+	progress := fmt.Sprintf(Messages["goal_progress"], 4, 5, progressBar(4, 5))
+	texts := tg.textsTo(chatID)
+	if len(texts) != 2 || !strings.HasSuffix(texts[0], progress) || texts[1] != progress {
+		t.Errorf("got %q, want the progress %q", texts, progress)
+	}
+
+	if err := b.completeChallenge(callback(7, chatID, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.checkPersonalGoal(7, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	reached := fmt.Sprintf(Messages["goal_reached"], 5, GetDayWord(5))
+	if dms := tg.textsTo(7); len(dms) != 1 || dms[0] != reached {
+		t.Errorf("got DMs %q, want the goal congrats once", dms)
+	}
+}
+
+func TestSetUserStreakWritesAudit(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 7, -100, "Anna")
+	addCompletions(t, b, 7, lastDays(3)...)
+
+	if err := b.SetUserStreak(1, 7, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"1 set_streak 7 3 10"}
+	got := dumpTable(t, b.db, `SELECT admin_id, action, target_user_id, old_value, new_value FROM admin_audit`)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("audit = %q, want %q", got, want)
+	}
+	if streak, err := b.getIndividualStreak(7); err != nil || streak != 10 {
+		t.Errorf("streak = %d, %v; want 10", streak, err)
+	}
+}
+
+// reminderWindow is a scheduler tick around today's reminder at hour:00 in loc
+func reminderWindow(loc *time.Location, hour int) (from, to time.Time) {
+	now := time.Now().In(loc)
+	at := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, loc)
+	return at.Add(-30 * time.Second), at.Add(30 * time.Second)
+}
+
+func TestSkipTodaySuppressesReminder(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	// Private chats, so each reminder is one user's
+	addParticipant(t, b, 1, 1, "Anna")
+	addParticipant(t, b, 2, 2, "Boris")
+	if err := b.handleSkipToday(command(1, 1, "/skiptoday")); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := b.buildParticipantsList(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(list, StatusIcons["skipped"]+" Anna") {
+		t.Errorf("list = %q, want Anna shown as skipping", list)
+	}
+
+	tg.reset()
+	from, to := reminderWindow(challengeLocation, reminderHour)
+	for _, chatID := range []int64{1, 2} {
+		if err := b.sendDailyReminders(chatID, from, to, challengeLocation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if texts := tg.textsTo(1); len(texts) != 0 {
+		t.Errorf("skipping user got %d reminders, want none", len(texts))
+	}
+	if texts := tg.textsTo(2); len(texts) != 1 {
+		t.Errorf("other user got %d reminders, want 1", len(texts))
+	}
+}
+
+// inPool reports whether message is one of pool
+func inPool(pool []string, message string) bool {
+	for _, candidate := range pool {
+		if candidate == message {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCongratsPoolForTodayAndYesterday(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 7, 7, "Anna")
+	if err := b.handleMarkYesterday(command(7, 7, "/markyesterday")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.completeChallenge(callback(7, 7, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	congrats := func(date string) string {
+		var message string
+		if err := b.db.QueryRow(`SELECT congrats_message FROM daily_completions WHERE user_id = 7 AND completed_at = ?`, date).Scan(&message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	if message := congrats(daysAgo(1)); !inPool(CatchUpCongratsMessages, message) {
+		t.Errorf("yesterday's congrats %q isn't a catch-up message", message)
+	}
+	if message := congrats(daysAgo(0)); !inPool(CongratsMessages, message) {
+		t.Errorf("today's congrats %q isn't a normal message", message)
+	}
+}
+
+func TestStatusReportsPendingFlows(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	if err := b.handleStatus(command(7, 7, "/status")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(7); len(texts) != 1 || texts[0] != Messages["status_idle"] {
+		t.Errorf("got %q with nothing pending, want %q", texts, Messages["status_idle"])
+	}
+
+	if err := b.handleJoinChallenge(callback(7, 7, "join_challenge")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.db.Exec(`INSERT INTO bot_state (user_id, chat_id, state) VALUES (7, 7, 'waiting_custom_streak')`); err != nil {
+		t.Fatal(err)
+	}
+	tg.reset()
+	if err := b.handleStatus(command(7, 7, "/status")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Messages["status_header"] + "\n" +
+		"  • " + Messages["status_pending_join"] + "\n" +
+		"  • " + Messages["status_waiting_streak"] + "\n"
+	if texts := tg.textsTo(7); len(texts) != 1 || texts[0] != want {
+		t.Errorf("got %q, want %q", texts, want)
+	}
+}
+
+func TestPanickingUpdateDoesNotStopTheLoop(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	broken := command(7, 7, "/start")
+	// A message without a sender makes the handler dereference nil
+	broken.From = nil
+	updates := []tgbotapi.Update{
+		{UpdateID: 1, Message: broken},
+		{UpdateID: 2, Message: command(7, 7, "/help")},
+	}
+
+	for _, update := range updates {
+		b.processUpdate(update)
+	}
+
+	if texts := tg.textsTo(7); len(texts) != 1 {
+		t.Errorf("got %d replies, want the /help reply after the panic", len(texts))
+	}
+}
+
+func TestAnonymousAdminCreatesNothing(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	anonymous := func(text string) *tgbotapi.Message {
+		message := reply(groupAnonymousBotID, chatID, text)
+		message.SenderChat = &tgbotapi.Chat{ID: chatID, Type: "supergroup"}
+		return message
+	}
+	start := anonymous("/start")
+	start.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/start")}}
+
+	for _, message := range []*tgbotapi.Message{start, anonymous("Сделать зарядочку"), anonymous("привет")} {
+		b.processUpdate(tgbotapi.Update{Message: message})
+	}
+
+	if n := countRows(t, b, `SELECT 1 FROM participants`); n != 0 {
+		t.Errorf("got %d participants, want none", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 0 {
+		t.Errorf("got %d completions, want none", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM start_offers`); n != 0 {
+		t.Errorf("got %d join offers, want none", n)
+	}
+	// The command and the button are explained, the chatter is ignored
+	if texts := tg.textsTo(chatID); len(texts) != 2 || texts[0] != Messages["anonymous_not_supported"] {
+		t.Errorf("got %q, want two explanations", texts)
+	}
+}
+
+func TestFormatStreak(t *testing.T) {
+	tests := []struct {
+		name    string
+		streak  int
+		display streakDisplay
+		want    string
+	}{
+		{"plain", 3, streakDisplay{}, "3 дня"},
+		{"below the minimum", 2, streakDisplay{Min: 3}, "—"},
+		{"at the minimum", 3, streakDisplay{Min: 3}, "3 дня"},
+		{"above the minimum", 21, streakDisplay{Min: 3}, "21 день"},
+		{"over the cap", 120, streakDisplay{Cap: 100}, "100+ дней"},
+		{"at the cap", 100, streakDisplay{Cap: 100}, "100 дней"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatStreak("ru", tt.streak, tt.display); got != tt.want {
+				t.Errorf("formatStreak(%d) = %q, want %q", tt.streak, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyboardRefreshTargetsEachParticipantOnce(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addParticipant(t, b, 3, 3, "Vera")
+	addParticipant(t, b, 4, -100, "Gleb")
+	addParticipant(t, b, 5, -100, "Dina")
+	addCompletions(t, b, 2, daysAgo(0))
+	if _, err := b.db.Exec(`UPDATE participants SET left_at = CURRENT_TIMESTAMP WHERE user_id = 4`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.db.Exec(`UPDATE participants SET inactive_at = CURRENT_TIMESTAMP WHERE user_id = 5`); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := b.keyboardRefreshTargets(-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int64]int)
+	for _, target := range targets {
+		userID := target.Entities[0].User.ID
+		seen[userID]++
+		if target.ChatID != -100 {
+			t.Errorf("refresh of %d goes to chat %d, want -100", userID, target.ChatID)
+		}
+
+		keyboard := target.ReplyMarkup.(tgbotapi.ReplyKeyboardMarkup)
+		if want := buildMainKeyboard(userID == 2); fmt.Sprint(keyboard) != fmt.Sprint(want) {
+			t.Errorf("keyboard of %d = %v, want %v", userID, keyboard, want)
+		}
+	}
+	if len(targets) != 2 || seen[1] != 1 || seen[2] != 1 {
+		t.Errorf("targets = %v, want each active participant of the chat once", seen)
+	}
+}
+
+func TestMarkAllCompletesOnlyPendingUsers(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addParticipant(t, b, 3, -100, "Vera")
+	addParticipant(t, b, 4, -200, "Gleb")
+	addCompletions(t, b, 2, daysAgo(0))
+
+	marked, err := b.markAllToday(99, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(marked) != "[1 3]" {
+		t.Errorf("marked = %v, want [1 3]", marked)
+	}
+
+	again, err := b.markAllToday(99, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Errorf("second run marked %v, want nobody", again)
+	}
+
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE source = 'admin'`); n != 2 {
+		t.Errorf("admin completions = %d, want 2", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 2`); n != 1 {
+		t.Errorf("completions of the user already done = %d, want 1", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 4`); n != 0 {
+		t.Errorf("completions of another chat's user = %d, want 0", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM admin_audit WHERE action = 'mark_all' AND admin_id = 99`); n != 2 {
+		t.Errorf("mark_all audit entries = %d, want 2", n)
+	}
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	calls := 0
+	flaky := func() (*tgbotapi.BotAPI, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("network is unreachable")
+		}
+		return &tgbotapi.BotAPI{}, nil
+	}
+
+	api, err := connectWithRetry(flaky, 5, time.Millisecond)
+	if err != nil || api == nil {
+		t.Fatalf("connectWithRetry = %v, %v, want the bot API", api, err)
+	}
+	if calls != 3 {
+		t.Errorf("attempts = %d, want 3", calls)
+	}
+
+	calls = 0
+	if _, err := connectWithRetry(flaky, 2, time.Millisecond); err == nil {
+		t.Error("connectWithRetry succeeded, want an error after running out of attempts")
+	}
+	if calls != 2 {
+		t.Errorf("attempts = %d, want 2", calls)
+	}
+}
+
+func TestCertificateOnlyForEarnedMilestones(t *testing.T) {
+	b, tg := newTestBot(t)
+	const userID = 5
+
+	addParticipant(t, b, userID, userID, "Anna")
+	addCompletions(t, b, userID, lastDays(10)...)
+	if _, err := b.db.Exec(`INSERT INTO achievements (user_id, achievement_type, achieved_at) VALUES (?, '7_days', '2024-03-07')`, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleCertificate(command(userID, userID, "/certificate")); err != nil {
+		t.Fatal(err)
+	}
+	texts := tg.textsTo(userID)
+	if len(texts) != 1 {
+		t.Fatalf("sent %q, want one certificate", texts)
+	}
+	for _, want := range []string{
+		"Anna",
+		fmt.Sprintf(Messages["certificate_milestone"], 7, GetDayWord(7)),
+		fmt.Sprintf(Messages["certificate_date"], "07.03.2024"),
+		fmt.Sprintf(Messages["certificate_total"], 10),
+	} {
+		if !strings.Contains(texts[0], want) {
+			t.Errorf("certificate %q does not contain %q", texts[0], want)
+		}
+	}
+
+	tg.reset()
+	if err := b.handleCertificate(command(userID, userID, "/certificate 30")); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(Messages["certificate_not_earned"], 30, GetDayWord(30))
+	if texts := tg.textsTo(userID); len(texts) != 1 || texts[0] != want {
+		t.Errorf("unearned certificate sent %q, want %q", texts, want)
+	}
+}
+
+func TestStalePendingJoinIsIgnoredAndRemoved(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	_, err := b.db.Exec(`
+		INSERT INTO pending_joins (user_id, chat_id, created_at) VALUES
+			(1, -100, datetime('now', '-2 hours')),
+			(2, -100, datetime('now', '-2 hours')),
+			(3, -100, datetime('now', '-5 minutes'))
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := b.hasPendingJoin(1, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale {
+		t.Error("hasPendingJoin reported a join older than the TTL")
+	}
+	if n := countRows(t, b, `SELECT 1 FROM pending_joins WHERE user_id = 1`); n != 0 {
+		t.Error("stale join read by hasPendingJoin was not removed")
+	}
+
+	if err := b.cleanupStalePendingJoins(); err != nil {
+		t.Fatal(err)
+	}
+	if got := dumpTable(t, b.db, `SELECT user_id FROM pending_joins`); fmt.Sprint(got) != "[3]" {
+		t.Errorf("pending joins after cleanup = %v, want only the fresh one", got)
+	}
+
+	fresh, err := b.hasPendingJoin(3, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fresh {
+		t.Error("hasPendingJoin ignored a fresh join")
+	}
+}
+
+func TestTeamStatsSumsMemberChats(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addParticipant(t, b, 3, -200, "Vera")
+	addParticipant(t, b, 4, -300, "Gleb")
+	addCompletions(t, b, 1, lastDays(3)...)
+	addCompletions(t, b, 2, lastDays(2)...)
+	addCompletions(t, b, 3, daysAgo(0), daysAgo(2), daysAgo(3), daysAgo(4))
+	addCompletions(t, b, 4, lastDays(5)...)
+
+	_, err := b.db.Exec(`
+		INSERT INTO teams (id, name) VALUES (1, 'North');
+		INSERT INTO team_members (chat_id, team_id, chat_title) VALUES (-100, 1, 'Office'), (-200, 1, 'Remote');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := b.getTeamStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []teamChatStats{
+		{ChatID: -200, Title: "Remote", Completions: 4, Streaks: 1},
+		{ChatID: -100, Title: "Office", Completions: 5, Streaks: 5},
+	}
+	if fmt.Sprint(stats) != fmt.Sprint(want) {
+		t.Errorf("getTeamStats = %+v, want %+v", stats, want)
+	}
+
+	if err := b.handleTeamStats(command(1, -100, "/teamstats")); err != nil {
+		t.Fatal(err)
+	}
+	total := fmt.Sprintf(Messages["team_stats_total"], 9, 6)
+	if texts := tg.textsTo(-100); len(texts) != 1 || !strings.Contains(texts[0], total) {
+		t.Errorf("/teamstats sent %q, want it to contain %q", texts, total)
+	}
+}
+
+func TestImportStreakFillsCappedDays(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -200, "Boris")
+	if err := b.setChatSetting(-100, "import_max_days", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleImportStreak(command(2, -200, "/importstreak 5")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-200); len(texts) != 1 || texts[0] != Messages["import_disabled"] {
+		t.Errorf("import in a chat without the flag sent %q, want %q", texts, Messages["import_disabled"])
+	}
+
+	if err := b.handleImportStreak(command(1, -100, "/importstreak 11")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 0 {
+		t.Errorf("import over the cap stored %d completions, want none", n)
+	}
+
+	// Today is already done, so the imported days extend a streak of 1
+	addCompletions(t, b, 1, daysAgo(0))
+	if err := b.handleImportStreak(command(1, -100, "/importstreak 5")); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpTable(t, b.db, `SELECT date(completed_at), source FROM daily_completions WHERE user_id = 1 AND source = 'imported' ORDER BY completed_at DESC`)
+	var want []string
+	for i := 1; i <= 5; i++ {
+		want = append(want, daysAgo(i)+" imported")
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("imported completions = %v, want %v", got, want)
+	}
+	if got := dumpTable(t, b.db, `SELECT admin_id, action, target_user_id, old_value, new_value FROM admin_audit`); fmt.Sprint(got) != "[1 import_streak 1 1 6]" {
+		t.Errorf("audit = %v, want the import logged with the resulting streak", got)
+	}
+
+	tg.reset()
+	if err := b.handleImportStreak(command(1, -100, "/importstreak 3")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] != Messages["import_already_done"] {
+		t.Errorf("second import sent %q, want %q", texts, Messages["import_already_done"])
+	}
+}
+
+func TestSinceLastVisitListsWhatIsNew(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	_, err := b.db.Exec(`
+		INSERT INTO participants (user_id, chat_id, username, display_name, joined_at, last_seen) VALUES
+			(1, -100, 'Anna', 'Anna', '2024-02-01 09:00:00', '2024-03-01 10:00:00'),
+			(2, -100, 'Boris', 'Boris', '2024-02-20 09:00:00', NULL),
+			(3, -100, 'Vera', 'Vera', '2024-03-05 09:00:00', NULL);
+		INSERT INTO achievements (user_id, achievement_type, achieved_at) VALUES
+			(2, '7_days', '2024-02-27'),
+			(3, '7_days', '2024-03-06');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.sinceLastVisit(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Messages["since_header"] + "\n\n" +
+		fmt.Sprintf(Messages["since_new_members"], "Vera") + "\n" +
+		fmt.Sprintf(Messages["since_new_achievements"], "Vera (7_days)")
+	if got != want {
+		t.Errorf("sinceLastVisit = %q, want %q", got, want)
+	}
+
+	if got, err := b.sinceLastVisit(2); err != nil || got != "" {
+		t.Errorf("sinceLastVisit without last_seen = %q, %v, want nothing", got, err)
+	}
+}
+
+func TestMilestoneAnnouncementGetsReaction(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, lastDays(7)...)
+	if err := b.setChatSetting(-100, "milestone_highlight", milestoneHighlightReact); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.checkAndRecordAchievements(1, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	var announcementID int
+	for _, call := range tg.sent("sendMessage") {
+		if call.params["chat_id"] == "-100" && call.params["text"] == Messages["achievement_7_congrats"] {
+			announcementID = call.messageID
+		}
+	}
+	if announcementID == 0 {
+		t.Fatal("the milestone was not announced in the group")
+	}
+
+	reactions := tg.sent("setMessageReaction")
+	if len(reactions) != 1 {
+		t.Fatalf("sent %d reactions, want 1", len(reactions))
+	}
+	if got := reactions[0].params; got["chat_id"] != "-100" || got["message_id"] != strconv.Itoa(announcementID) {
+		t.Errorf("reaction went to chat %s message %s, want the announcement %d", got["chat_id"], got["message_id"], announcementID)
+	}
+}
+
+func TestWalkOfFameSetting(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	if _, err := b.db.Exec(`INSERT INTO achievements (user_id, achievement_type, achieved_at) VALUES (1, '100_days', '2024-03-01')`); err != nil {
+		t.Fatal(err)
+	}
+	fame, err := b.getWalkOfFame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := formatWalkOfFame(DefaultLang, fame)
+	if !strings.Contains(section, "Anna") {
+		t.Fatalf("walk of fame %q does not list the participant", section)
+	}
+
+	list, err := b.buildParticipantsList(-100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(list, section) {
+		t.Errorf("scoreboard %q does not contain the walk of fame %q", list, section)
+	}
+
+	if err := b.setChatSetting(-100, "show_walk_of_fame", "off"); err != nil {
+		t.Fatal(err)
+	}
+	list, err = b.buildParticipantsList(-100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(list, section) || strings.Contains(list, Messages["hall_of_fame_separator"]) {
+		t.Errorf("scoreboard %q still shows the walk of fame", list)
+	}
+
+	if err := b.handleFame(command(1, -100, "/fame")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] != section {
+		t.Errorf("/fame sent %q, want %q", texts, section)
+	}
+}
+
+func TestParticipantsCache(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addParticipant(t, b, 3, -200, "Vera")
+
+	completed := func(chatID int64) []bool {
+		t.Helper()
+		participants, err := b.cachedParticipantsList(chatID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var done []bool
+		for _, p := range participants {
+			done = append(done, p.Completed)
+		}
+		return done
+	}
+
+	if got := completed(-100); fmt.Sprint(got) != "[false false]" {
+		t.Fatalf("completed = %v, want nobody", got)
+	}
+	if got := completed(-200); fmt.Sprint(got) != "[false]" {
+		t.Fatalf("completed in the other chat = %v, want nobody", got)
+	}
+
+	// Written behind the bot's back, so only a fresh query would see them
+	addCompletions(t, b, 2, daysAgo(0))
+	addCompletions(t, b, 3, daysAgo(0))
+	if got := completed(-100); fmt.Sprint(got) != "[false false]" {
+		t.Errorf("completed within the TTL = %v, want the cached list", got)
+	}
+
+	if err := b.completeChallenge(callback(1, -100, "complete"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := completed(-100); fmt.Sprint(got) != "[true true]" {
+		t.Errorf("completed after a completion = %v, want a fresh list", got)
+	}
+	if got := completed(-200); fmt.Sprint(got) != "[false]" {
+		t.Errorf("completed in the other chat = %v, want its cached list", got)
+	}
+
+	b.invalidateUserParticipantsCache(3)
+	if got := completed(-200); fmt.Sprint(got) != "[true]" {
+		t.Errorf("completed in the other chat after invalidating it = %v, want a fresh list", got)
+	}
+}
+
+func TestResolveAppEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		dbOverride string
+		want       appEnv
+		wantErr    bool
+	}{
+		{"default is prod", "", "", appEnv{appEnvProd, "BOT_TOKEN", dbPath}, false},
+		{"staging", appEnvStaging, "", appEnv{appEnvStaging, "STAGING_BOT_TOKEN", dataDir + "/staging.db"}, false},
+		{"dev", appEnvDev, "", appEnv{appEnvDev, "DEV_BOT_TOKEN", dataDir + "/dev.db"}, false},
+		{"dev with its own database", appEnvDev, "/tmp/local.db", appEnv{appEnvDev, "DEV_BOT_TOKEN", "/tmp/local.db"}, false},
+		{"dev against prod database", appEnvDev, dbPath, appEnv{}, true},
+		{"dev against unclean prod path", appEnvDev, dataDir + "/./" + filepath.Base(dbPath), appEnv{}, true},
+		{"unknown", "qa", "", appEnv{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveAppEnv(tt.env, tt.dbOverride)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveAppEnv(%q, %q) error = %v, wantErr %v", tt.env, tt.dbOverride, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveAppEnv(%q, %q) = %+v, want %+v", tt.env, tt.dbOverride, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClearAchievement(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.admins[99] = true
+
+	addParticipant(t, b, 1, -100, "Anna")
+	_, err := b.db.Exec(`
+		INSERT INTO achievements (user_id, achievement_type, achieved_at) VALUES
+			(1, '7_days', '2024-03-01'),
+			(1, '30_days', '2024-03-24')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleClearAchievement(command(99, 99, "/clearachievement 1 30_days")); err != nil {
+		t.Fatal(err)
+	}
+	if got := dumpTable(t, b.db, `SELECT achievement_type FROM achievements WHERE user_id = 1`); fmt.Sprint(got) != "[7_days]" {
+		t.Errorf("achievements = %v, want only 7_days left", got)
+	}
+	if got := dumpTable(t, b.db, `SELECT admin_id, action, target_user_id, old_value, new_value FROM admin_audit`); fmt.Sprint(got) != "[99 clear_30_days 1 30 0]" {
+		t.Errorf("audit = %v, want the clearing logged", got)
+	}
+
+	if err := b.handleClearAchievement(command(99, 99, "/clearachievement 1 3_days")); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM admin_audit`); n != 1 {
+		t.Errorf("audit entries after an unknown type = %d, want 1", n)
+	}
+}
+
+func TestQuoteOfTheDay(t *testing.T) {
+	quotes := []string{"first", "second", "third"}
+	morning := time.Date(2024, 3, 10, 6, 0, 0, 0, challengeLocation)
+	evening := time.Date(2024, 3, 10, 23, 59, 0, 0, challengeLocation)
+
+	if a, b := quoteOfTheDay(quotes, morning), quoteOfTheDay(quotes, evening); a != b {
+		t.Errorf("quote changed within a day: %q in the morning, %q in the evening", a, b)
+	}
+
+	seen := make(map[string]bool)
+	previous := ""
+	for day := 0; day < len(quotes); day++ {
+		quote := quoteOfTheDay(quotes, morning.AddDate(0, 0, day))
+		if quote == previous {
+			t.Errorf("day %d repeats the previous day's quote %q", day, quote)
+		}
+		seen[quote] = true
+		previous = quote
+	}
+	if len(seen) != len(quotes) {
+		t.Errorf("%d days showed %d different quotes, want every quote once", len(quotes), len(seen))
+	}
+}
+
+func TestRenameButtonOnlyForItsOwner(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+
+	if err := b.handleRenameCallback(callback(2, -100, "rename:1")); err != nil {
+		t.Fatal(err)
+	}
+	answers := tg.sent("answerCallbackQuery")
+	if len(answers) != 1 || answers[0].params["text"] != Messages["button_not_yours"] {
+		t.Errorf("answered %v, want %q", answers, Messages["button_not_yours"])
+	}
+	if n := countRows(t, b, `SELECT 1 FROM bot_state`); n != 0 {
+		t.Errorf("someone else's tap started %d renames, want none", n)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 0 {
+		t.Errorf("someone else's tap sent %q, want nothing", texts)
+	}
+
+	tg.reset()
+	if err := b.handleRenameCallback(callback(1, -100, "rename:1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := dumpTable(t, b.db, `SELECT user_id, state FROM bot_state`); fmt.Sprint(got) != "[1 waiting_rename]" {
+		t.Errorf("bot state = %v, want the owner waiting for a new name", got)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] != Messages["enter_new_name"] {
+		t.Errorf("owner's tap sent %q, want the name prompt", texts)
+	}
+}
+
+func TestGroupCountdownEditsAsStreakAdvances(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	if _, err := b.db.Exec(`UPDATE participants SET joined_at = ?`, daysAgo(40)); err != nil {
+		t.Fatal(err)
+	}
+	addCompletions(t, b, 1, datesBetween(daysAgo(25), daysAgo(1))...)
+	if err := b.setChatSetting(-100, "group_countdown", "on"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.updateGroupCountdown(-100); err != nil {
+		t.Fatal(err)
+	}
+	posted := tg.sent("sendMessage")
+	if want := fmt.Sprintf(Messages["countdown"], 5, DayWord("ru", 5), 30); len(posted) != 1 || posted[0].params["text"] != want {
+		t.Fatalf("posted %v, want the countdown %q", posted, want)
+	}
+	if pins := tg.sent("pinChatMessage"); len(pins) != 1 || pins[0].params["message_id"] != strconv.Itoa(posted[0].messageID) {
+		t.Errorf("pinned %v, want the countdown message %d", pins, posted[0].messageID)
+	}
+
+	addCompletions(t, b, 1, daysAgo(0))
+	if err := b.updateGroupCountdown(-100); err != nil {
+		t.Fatal(err)
+	}
+	edits := tg.sent("editMessageText")
+	if len(edits) != 1 {
+		t.Fatalf("sent %d edits, want 1", len(edits))
+	}
+	if want := fmt.Sprintf(Messages["countdown"], 4, DayWord("ru", 4), 30); edits[0].params["text"] != want || edits[0].params["message_id"] != strconv.Itoa(posted[0].messageID) {
+		t.Errorf("edited message %s to %q, want message %d to read %q", edits[0].params["message_id"], edits[0].params["text"], posted[0].messageID, want)
+	}
+
+	// Nothing changed, so nothing to edit
+	if err := b.updateGroupCountdown(-100); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tg.sent("editMessageText")); n != 1 {
+		t.Errorf("sent %d edits after an unchanged streak, want still 1", n)
+	}
+	if n := len(tg.sent("sendMessage")); n != 1 {
+		t.Errorf("sent %d messages, want the countdown only", n)
+	}
+}
+
+func TestGroupFeaturesUseChatLanguage(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	if _, err := b.db.Exec(`UPDATE participants SET joined_at = ?`, daysAgo(40)); err != nil {
+		t.Fatal(err)
+	}
+	addCompletions(t, b, 1, datesBetween(daysAgo(25), daysAgo(1))...)
+	for key, value := range map[string]string{"lang": "en", "group_countdown": "on"} {
+		if err := b.setChatSetting(-100, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The user's own language only applies in private
+	if err := b.setUserSetting(1, "lang", "ru"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.updateGroupCountdown(-100); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleFreeze(command(1, -100, "/freeze")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleStats(command(1, -100, "/stats")); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := tg.textsTo(-100)
+	for _, want := range []string{
+		fmt.Sprintf(MessagesEn["countdown"], 5, "days", 30),
+		fmt.Sprintf(MessagesEn["freeze_done"], challengeNow().Format("02.01.2006"), maxFreezesPerMonth-1, pluralize(maxFreezesPerMonth-1, "freeze", "en")),
+		fmt.Sprintf(MessagesEn["stats_streak"], 25, "days"),
+		RelativeDateLabels["en"]["yesterday"],
+	} {
+		if !strings.Contains(strings.Join(texts, "\n"), want) {
+			t.Errorf("group got %q, want it to contain %q", texts, want)
+		}
+	}
+}
+
+func TestIsUnreachableUser(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"blocked", &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}, true},
+		{"deleted account", &tgbotapi.Error{Code: 403, Message: "Forbidden: user is deactivated"}, true},
+		{"wrapped", fmt.Errorf("send: %w", &tgbotapi.Error{Code: 403, Message: "Forbidden: user is deactivated"}), true},
+		{"kicked from group", &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was kicked from the supergroup chat"}, false},
+		{"bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request: message text is empty"}, false},
+		{"network", errors.New("connection reset by peer"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnreachableUser(tt.err); got != tt.want {
+				t.Errorf("isUnreachableUser(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeletedAccountIsDeactivated(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, 1, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	tg.failures = map[string]*tgbotapi.Error{
+		"sendMessage": {Code: 403, Message: "Forbidden: user is deactivated"},
+	}
+
+	if _, err := b.sendMessage(tgbotapi.NewMessage(1, "reminder")); err == nil {
+		t.Fatal("sendMessage succeeded, want the Telegram error")
+	}
+	if _, err := b.sendMessage(tgbotapi.NewMessage(-100, "reminder")); err == nil {
+		t.Fatal("sendMessage succeeded, want the Telegram error")
+	}
+
+	if got := dumpTable(t, b.db, `SELECT user_id FROM participants WHERE inactive_at IS NOT NULL`); fmt.Sprint(got) != "[1]" {
+		t.Errorf("inactive participants = %v, want only the deleted account", got)
+	}
+}
+
+func TestGroupDayKept(t *testing.T) {
+	tests := []struct {
+		completed, total, threshold int
+		want                        bool
+	}{
+		{19, 20, 90, true},
+		{18, 20, 90, true},
+		{17, 20, 90, false},
+		{19, 20, 100, false},
+		{20, 20, 100, true},
+		{0, 0, 90, false},
+	}
+
+	for _, tt := range tests {
+		if got := groupDayKept(tt.completed, tt.total, tt.threshold); got != tt.want {
+			t.Errorf("groupDayKept(%d, %d, %d) = %v, want %v", tt.completed, tt.total, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestGroupStreakThreshold(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	for userID := int64(1); userID <= 20; userID++ {
+		addParticipant(t, b, userID, -100, fmt.Sprintf("user%d", userID))
+		if userID < 20 {
+			addCompletions(t, b, userID, daysAgo(1), daysAgo(0))
+		}
+	}
+	if _, err := b.db.Exec(`UPDATE participants SET joined_at = ?`, daysAgo(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	streak, err := b.getConsecutiveCompletionDays(-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streak != 0 {
+		t.Errorf("group streak with 95%% done and no threshold = %d, want 0", streak)
+	}
+
+	if err := b.setChatSetting(-100, "group_streak_threshold", "90"); err != nil {
+		t.Fatal(err)
+	}
+	streak, err = b.getConsecutiveCompletionDays(-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streak != 2 {
+		t.Errorf("group streak with 95%% done and a 90%% threshold = %d, want 2", streak)
+	}
+}
+
+func TestEveryMenuCommandIsRouted(t *testing.T) {
+	b, tg := newTestBot(t)
+	const userID = 5
+
+	addParticipant(t, b, userID, userID, "Anna")
+	addCompletions(t, b, userID, lastDays(3)...)
+
+	// An unknown command gets no answer, so any call means the command was routed
+	b.processUpdate(tgbotapi.Update{Message: command(userID, userID, "/nosuchcommand")})
+	if len(tg.calls) != 0 {
+		t.Fatalf("unknown command made %d calls, want none", len(tg.calls))
+	}
+
+	for _, c := range BotCommands {
+		tg.reset()
+		b.processUpdate(tgbotapi.Update{Message: command(userID, userID, "/"+c.Command)})
+		if len(tg.sent("sendMessage"))+len(tg.sent("sendPhoto"))+len(tg.sent("sendDocument")) == 0 {
+			t.Errorf("/%s got no answer, is it routed in processUpdate?", c.Command)
+		}
+	}
+}
+
+func TestCompletionBeforeSendSuppressesReminder(t *testing.T) {
+	b, tg := newTestBot(t)
+	today := daysAgo(0)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+
+	candidates, err := b.reminderCandidates(-100, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(candidates) != "[1 2]" {
+		t.Fatalf("candidates = %v, want both participants", candidates)
+	}
+
+	// Anna completes while the loop is still working through the list
+	addCompletions(t, b, 1, today)
+	for _, userID := range candidates {
+		b.remindIfPending(-100, userID, today, "reminder")
+	}
+
+	if texts := tg.textsTo(-100); len(texts) != 1 {
+		t.Errorf("sent %d reminders, want only Boris's", len(texts))
+	}
+}
+
+func TestRemindersFollowChatTimezone(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -200, "Boris")
+	if err := b.setChatSetting(-100, "timezone", "Europe/Moscow"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setChatSetting(-200, "timezone", "Asia/Tokyo"); err != nil {
+		t.Fatal(err)
+	}
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Noon in Tokyo is early morning in Moscow
+	b.runDueReminders(reminderWindow(tokyo, reminderHour))
+	if got := len(tg.textsTo(-200)); got != 1 {
+		t.Errorf("Tokyo chat got %d reminders at its noon, want 1", got)
+	}
+	if got := len(tg.textsTo(-100)); got != 0 {
+		t.Errorf("Moscow chat got %d reminders at Tokyo's noon, want none", got)
+	}
+
+	tg.reset()
+	b.runDueReminders(reminderWindow(moscow, reminderHour))
+	if got := len(tg.textsTo(-100)); got != 1 {
+		t.Errorf("Moscow chat got %d reminders at its noon, want 1", got)
+	}
+	if got := len(tg.textsTo(-200)); got != 0 {
+		t.Errorf("Tokyo chat got %d reminders at Moscow's noon, want none", got)
+	}
+}
+
+func TestComboIntensity(t *testing.T) {
+	tests := []struct {
+		streak int
+		want   int
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 1},
+		{4, 1},
+		{5, 2},
+		{10, 3},
+		{29, 3},
+		{30, 4},
+		{100, 5},
+		{365, 5},
+	}
+
+	for _, tt := range tests {
+		if got := comboIntensity(tt.streak); got != tt.want {
+			t.Errorf("comboIntensity(%d) = %d, want %d", tt.streak, got, tt.want)
+		}
+	}
+
+	if got := comboLine("ru", 2); got != "" {
+		t.Errorf("comboLine(2) = %q, want nothing below the first threshold", got)
+	}
+	if got, want := comboLine("ru", 5), fmt.Sprintf(Messages["group_combo"], "🔥🔥", 5, DayWord("ru", 5)); got != want {
+		t.Errorf("comboLine(5) = %q, want %q", got, want)
+	}
+}
+
+func TestFailedReminderIsRetriedOnNextTick(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	tg.failures = map[string]*tgbotapi.Error{
+		"sendMessage": {Code: 502, Message: "Bad Gateway"},
+	}
+
+	b.runDueReminders(reminderWindow(challengeLocation, reminderHour))
+	if got := dumpTable(t, b.db, `SELECT chat_id, attempts FROM failed_reminders`); fmt.Sprint(got) != "[-100 1]" {
+		t.Fatalf("failed reminders = %v, want the reminder queued", got)
+	}
+
+	// Still down on the next tick: the attempt is counted
+	b.runDueReminders(reminderWindow(challengeLocation, 3))
+	if got := dumpTable(t, b.db, `SELECT chat_id, attempts FROM failed_reminders`); fmt.Sprint(got) != "[-100 2]" {
+		t.Fatalf("failed reminders = %v, want the second attempt counted", got)
+	}
+
+	tg.failures = nil
+	tg.reset()
+	b.runDueReminders(reminderWindow(challengeLocation, 3))
+	if texts := tg.textsTo(-100); len(texts) != 1 {
+		t.Errorf("retry sent %q, want the reminder", texts)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM failed_reminders`); n != 0 {
+		t.Errorf("%d reminders still queued after delivery, want none", n)
+	}
+}
+
+func TestKeyboardCompleteWhenAlreadyDone(t *testing.T) {
+	b, tg := newTestBot(t)
+	var logs strings.Builder
+	b.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, daysAgo(0))
+
+	b.processUpdate(tgbotapi.Update{Message: reply(1, -100, ButtonLabels["do_exercise"])})
+
+	texts := tg.textsTo(-100)
+	if len(texts) != 2 || texts[0] != Messages["already_completed"] {
+		t.Fatalf("sent %q, want the already completed message and the list", texts)
+	}
+	if !strings.Contains(texts[1], "Anna") {
+		t.Errorf("second message %q is not the participants list", texts[1])
+	}
+	if n := len(tg.sent("answerCallbackQuery")); n != 0 {
+		t.Errorf("answered %d callbacks, want none for a keyboard button", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 1 {
+		t.Errorf("completions = %d, want still 1", n)
+	}
+	if !strings.Contains(logs.String(), `msg="sent message" chat_id=-100 text=`+strconv.Quote(Messages["already_completed"])) {
+		t.Errorf("the already completed message was not logged:\n%s", logs.String())
+	}
+}
+
+func TestTierIsRecordedAndCountsForStreak(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, daysAgo(2), daysAgo(1))
+	if _, err := b.db.Exec(`UPDATE daily_completions SET tier = 'easy' WHERE completed_at = ?`, daysAgo(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setChatSetting(-100, "tiers", "on"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleCompleteChallenge(callback(1, -100, "complete_challenge")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] != Messages["tier_pick"] {
+		t.Fatalf("sent %q, want the tier choice", texts)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE completed_at = ?`, daysAgo(0)); n != 0 {
+		t.Fatal("completed before a tier was picked")
+	}
+
+	if err := b.handleTierCallback(callback(1, -100, "tier:hard")); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpTable(t, b.db, `SELECT date(completed_at), COALESCE(tier, '-') FROM daily_completions ORDER BY completed_at`)
+	want := []string{daysAgo(2) + " easy", daysAgo(1) + " -", daysAgo(0) + " hard"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("completions = %v, want %v", got, want)
+	}
+
+	streak, err := b.getIndividualStreak(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streak != 3 {
+		t.Errorf("streak = %d, want 3 whatever the tiers", streak)
+	}
+}
+
+func TestIsDBError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"wrapped disk full", fmt.Errorf("insert: %w", sqlite3.Error{Code: sqlite3.ErrFull}), true},
+		{"connection done", sql.ErrConnDone, true},
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"telegram", &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDBError(tt.err); got != tt.want {
+				t.Errorf("isDBError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBBreaker(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	for i := 1; i < dbBreakerThreshold; i++ {
+		if b.recordDBFailure() {
+			t.Fatalf("breaker opened after %d failures, want %d", i, dbBreakerThreshold)
+		}
+	}
+	if !b.dbAvailable() {
+		t.Fatal("database unavailable below the threshold")
+	}
+	if !b.recordDBFailure() {
+		t.Fatalf("breaker stayed closed after %d failures", dbBreakerThreshold)
+	}
+	if b.recordDBFailure() {
+		t.Error("an open breaker reported opening again")
+	}
+	if b.dbAvailable() {
+		t.Error("database available before the next probe")
+	}
+
+	b.notifyOutage(-100)
+	b.notifyOutage(-100)
+	b.notifyOutage(-200)
+	if n := len(tg.textsTo(-100)); n != 1 {
+		t.Errorf("chat got %d outage notices, want 1", n)
+	}
+	if n := len(tg.textsTo(-200)); n != 1 {
+		t.Errorf("second chat got %d outage notices, want 1", n)
+	}
+
+	b.breaker.nextProbe = time.Now().Add(-time.Second)
+	if !b.dbAvailable() {
+		t.Fatal("a working database did not close the breaker")
+	}
+	if b.breaker.open || b.breaker.failures != 0 {
+		t.Errorf("breaker open = %v with %d failures after recovery, want closed and reset", b.breaker.open, b.breaker.failures)
+	}
+}
+
+func TestReminderSettingsOverview(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	if err := b.setUserSetting(1, "reminders_muted", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setUserSetting(2, "remind_at", "08:30"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleReminderSettings(command(1, 1, "/remindersettings")); err != nil {
+		t.Fatal(err)
+	}
+	muted := tg.textsTo(1)
+	if len(muted) != 1 || !strings.Contains(muted[0], Messages["reminder_muted"]) {
+		t.Errorf("muted user's overview = %q, want it to say reminders are off", muted)
+	}
+
+	if err := b.handleReminderSettings(command(2, 2, "/remindersettings")); err != nil {
+		t.Fatal(err)
+	}
+	custom := tg.textsTo(2)
+	next := fmt.Sprintf(Messages["reminder_next_noon"], nextReminderAt(time.Now(), challengeLocation, 8, 30).Format("02.01 15:04"))
+	if len(custom) != 1 || !strings.Contains(custom[0], Messages["reminder_active"]) || !strings.Contains(custom[0], next) {
+		t.Fatalf("overview with a custom time = %q, want it active with %q", custom, next)
+	}
+	if strings.Contains(custom[0], Messages["reminder_muted"]) {
+		t.Errorf("overview of a user who isn't muted says reminders are off: %q", custom[0])
+	}
+}
+
+func TestChannelPostMatchesScoreboard(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.channelID = -1001
+	b.channelSourceChatID = -100
+	b.channelPostHour = 22
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addCompletions(t, b, 1, lastDays(4)...)
+
+	b.runDueChannelPost(reminderWindow(challengeLocation, reminderHour))
+	if n := len(tg.textsTo(-1001)); n != 0 {
+		t.Fatalf("posted %d times outside the post hour, want none", n)
+	}
+
+	b.runDueChannelPost(reminderWindow(challengeLocation, 22))
+	posts := tg.sent("sendMessage")
+	if len(posts) != 1 || posts[0].params["chat_id"] != "-1001" {
+		t.Fatalf("sent %v, want one channel post", posts)
+	}
+
+	scoreboard, err := b.buildParticipantsList(-100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posts[0].params["text"] != scoreboard {
+		t.Errorf("channel post = %q, want the chat's scoreboard %q", posts[0].params["text"], scoreboard)
+	}
+	if !strings.Contains(scoreboard, "Anna") || !strings.Contains(scoreboard, "Boris") {
+		t.Errorf("scoreboard %q does not list the chat's participants", scoreboard)
+	}
+	if posts[0].params["reply_markup"] != "" {
+		t.Errorf("channel post has a keyboard %s, channels can't show one", posts[0].params["reply_markup"])
+	}
+}
+
+func TestRenderCalendar(t *testing.T) {
+	// March 2024 starts on a Friday and spans five weeks
+	completed := map[int]bool{1: true, 2: true, 10: true}
+	today := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	data, err := renderCalendar("ru", 2024, time.March, completed, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("calendar is not a valid PNG: %v", err)
+	}
+
+	bounds := img.Bounds()
+	wantWidth := 2*calendarPadding + 7*calendarCell
+	wantHeight := 2*calendarPadding + calendarHeader + calendarDayRow + 5*calendarCell
+	if bounds.Dx() != wantWidth || bounds.Dy() != wantHeight {
+		t.Errorf("calendar is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), wantWidth, wantHeight)
+	}
+
+	// fillOf samples a day's cell near its corner, away from the number
+	fillOf := func(day int) color.RGBA {
+		cell := 4 + day - 1
+		x := calendarPadding + (cell%7)*calendarCell + 4
+		y := calendarPadding + calendarHeader + calendarDayRow + (cell/7)*calendarCell + 4
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	for _, tt := range []struct {
+		day  int
+		want color.RGBA
+	}{
+		{1, calendarCompleted},
+		{10, calendarCompleted},
+		{3, calendarMissed},
+		{15, calendarMissed},
+		{16, calendarFuture},
+		{31, calendarFuture},
+	} {
+		if got := fillOf(tt.day); got != tt.want {
+			t.Errorf("day %d is %v, want %v", tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestLinkedPartnerIsCompletedToo(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addParticipant(t, b, 3, -100, "Vera")
+	if _, err := b.db.Exec(`INSERT INTO linked_users (user_id, partner_id) VALUES (1, 2), (2, 1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.completeChallenge(callback(1, -100, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got := dumpTable(t, b.db, `SELECT user_id, date(completed_at), COALESCE(source, '-') FROM daily_completions ORDER BY user_id`)
+	want := []string{"1 " + daysAgo(0) + " -", "2 " + daysAgo(0) + " linked"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("completions = %v, want %v", got, want)
+	}
+
+	congrats := tg.textsTo(-100)
+	if len(congrats) == 0 || !strings.Contains(congrats[0], fmt.Sprintf(Messages["link_completed"], "Boris")) {
+		t.Errorf("congrats %q do not mention the partner", congrats)
+	}
+}
+
+func TestGroupDayCounts(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	addParticipant(t, b, 3, -100, "Vera")
+	addParticipant(t, b, 4, -100, "Gleb")
+	_, err := b.db.Exec(`
+		UPDATE participants SET joined_at = CASE user_id
+			WHEN 1 THEN ? WHEN 2 THEN ? WHEN 3 THEN ? ELSE ? END;
+		UPDATE participants SET inactive_at = CURRENT_TIMESTAMP WHERE user_id = 4;
+	`, daysAgo(10)+" 08:00:00", daysAgo(10)+" 21:00:00", daysAgo(3)+" 12:00:00", daysAgo(10)+" 08:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addCompletions(t, b, 1, daysAgo(10), daysAgo(5), daysAgo(1))
+	addCompletions(t, b, 2, daysAgo(10), daysAgo(1))
+	// Vera's imported history predates her joining
+	addCompletions(t, b, 3, daysAgo(5), daysAgo(3), daysAgo(1))
+	addCompletions(t, b, 4, daysAgo(5), daysAgo(1))
+	// Another chat's participant counts for that chat only
+	addParticipant(t, b, 5, -200, "Dina")
+	addCompletions(t, b, 5, daysAgo(1))
+
+	tests := []struct {
+		name             string
+		date             string
+		completed, total int
+	}{
+		{"joined that evening still counts", daysAgo(10), 2, 2},
+		{"before anyone joined", daysAgo(11), 0, 0},
+		{"completion before joining is ignored", daysAgo(5), 1, 2},
+		{"joining day", daysAgo(3), 1, 3},
+		{"everyone", daysAgo(1), 3, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completed, total, err := b.groupDayCounts(-100, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if completed != tt.completed || total != tt.total {
+				t.Errorf("groupDayCounts(%s) = %d of %d, want %d of %d", tt.date, completed, total, tt.completed, tt.total)
+			}
+		})
+	}
+}
+
+func TestImportedHistoryKeepsGroupStreak(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	if _, err := b.db.Exec(`UPDATE participants SET joined_at = ? WHERE user_id = 1`, daysAgo(40)); err != nil {
+		t.Fatal(err)
+	}
+	addCompletions(t, b, 1, lastDays(10)...)
+	addCompletions(t, b, 2, lastDays(30)...)
+
+	streak, err := b.getConsecutiveCompletionDays(-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streak != 10 {
+		t.Errorf("group streak = %d, want 10: days before Boris joined were Anna's alone", streak)
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []int
+		want   float64
+	}{
+		{nil, 0},
+		{[]int{7}, 7},
+		{[]int{5, 1, 3}, 3},
+		{[]int{0, 5, 2, 1}, 1.5},
+		{[]int{4, 4, 10, 4}, 4},
+	}
+
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateDailyStats(t *testing.T) {
+	b, _ := newTestBot(t)
+	yesterday := daysAgo(1)
+
+	for userID := int64(1); userID <= 6; userID++ {
+		addParticipant(t, b, userID, -100, fmt.Sprintf("user%d", userID))
+	}
+	_, err := b.db.Exec(`
+		UPDATE participants SET joined_at = ?;
+		UPDATE participants SET inactive_at = CURRENT_TIMESTAMP WHERE user_id = 5;
+	`, daysAgo(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.db.Exec(`UPDATE participants SET left_at = CURRENT_TIMESTAMP WHERE user_id = 6`); err != nil {
+		t.Fatal(err)
+	}
+	addCompletions(t, b, 1, datesBetween(daysAgo(5), yesterday)...)
+	addCompletions(t, b, 2, daysAgo(2), yesterday)
+	addCompletions(t, b, 3, yesterday)
+	addCompletions(t, b, 5, yesterday)
+	addCompletions(t, b, 6, yesterday)
+
+	if err := b.aggregateDailyStats(yesterday); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpTable(t, b.db, `SELECT date(date), active_users, completed_users, completion_rate, median_streak FROM stats_daily`)
+	if want := []string{yesterday + " 4 3 0.75 1.5"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("stats_daily = %v, want %v", got, want)
+	}
+
+	// An aggregated day is kept as it was
+	addCompletions(t, b, 4, yesterday)
+	if err := b.aggregateDailyStats(yesterday); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM stats_daily WHERE completed_users = 3`); n != 1 {
+		t.Error("re-aggregating a day changed it")
+	}
+}
+
+func TestRejoinWithinGracePeriod(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	for userID, name := range map[int64]string{1: "Anna", 2: "Boris"} {
+		addParticipant(t, b, userID, -100, name)
+		addCompletions(t, b, userID, lastDays(3)...)
+		if _, err := b.db.Exec(`INSERT INTO achievements (user_id, achievement_type, achieved_at) VALUES (?, '7_days', '2024-03-01')`, userID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.db.Exec(`INSERT INTO streak_freezes (user_id, frozen_on) VALUES (?, '2024-03-02')`, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := b.db.Exec(`
+		UPDATE participants SET left_at = datetime('now', '-2 days'), inactive_at = datetime('now', '-2 days') WHERE user_id = 1;
+		UPDATE participants SET left_at = datetime('now', '-10 days'), inactive_at = datetime('now', '-10 days') WHERE user_id = 2;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleRejoin(command(1, -100, "/rejoin")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-100); len(texts) == 0 || texts[0] != fmt.Sprintf(Messages["rejoin_done"], 3) {
+		t.Errorf("rejoin sent %q, want the streak of 3 restored", texts)
+	}
+
+	tg.reset()
+	if err := b.handleRejoin(command(2, -100, "/rejoin")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] != Messages["rejoin_expired"] {
+		t.Errorf("late rejoin sent %q, want %q", texts, Messages["rejoin_expired"])
+	}
+
+	if err := b.purgeLeftParticipants(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"participants", "daily_completions", "achievements", "streak_freezes"} {
+		if got := dumpTable(t, b.db, `SELECT DISTINCT user_id FROM `+table); fmt.Sprint(got) != "[1]" {
+			t.Errorf("%s after the purge has users %v, want only the one who rejoined", table, got)
+		}
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 1`); n != 3 {
+		t.Errorf("rejoined participant has %d completions, want 3", n)
+	}
+}
+
+func TestDuplicateCallback(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	retap := callback(1, -100, "complete_challenge")
+	retap.ID = "another-id"
+
+	steps := []struct {
+		name  string
+		query *tgbotapi.CallbackQuery
+		want  bool
+	}{
+		{"first", callback(1, -100, "complete_challenge"), false},
+		{"same ID again", callback(1, -100, "complete_challenge"), true},
+		{"same tap with a new ID", retap, true},
+		{"other button", callback(1, -100, "update_list"), false},
+		{"other user", callback(2, -100, "complete_challenge"), false},
+	}
+
+	for _, step := range steps {
+		if got := b.duplicateCallback(step.query); got != step.want {
+			t.Errorf("%s: duplicateCallback = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// Once the TTL has passed the callback is handled again
+	for key := range b.recentCallbacks {
+		b.recentCallbacks[key] = time.Now().Add(-callbackDedupTTL)
+	}
+	if b.duplicateCallback(callback(1, -100, "complete_challenge")) {
+		t.Error("a callback older than the TTL was still a duplicate")
+	}
+}
+
+func TestDuplicateCallbackActsOnce(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+
+	update := tgbotapi.Update{CallbackQuery: callback(1, -100, "complete_challenge")}
+	b.processUpdate(update)
+	congrats := len(tg.textsTo(-100))
+	answers := len(tg.sent("answerCallbackQuery"))
+	b.processUpdate(update)
+
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 1 {
+		t.Errorf("completions = %d, want 1", n)
+	}
+	if n := len(tg.textsTo(-100)); n != congrats {
+		t.Errorf("the duplicate sent %d more messages, want none", n-congrats)
+	}
+	if n := len(tg.sent("answerCallbackQuery")); n != answers+1 {
+		t.Errorf("the duplicate got %d answers, want 1 so the spinner stops", n-answers)
+	}
+}
+
+func TestSetUserStreakOverCapWritesNothing(t *testing.T) {
+	b, _ := newTestBot(t)
+	b.maxStreakDays = 10
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, lastDays(2)...)
+
+	err := b.SetUserStreak(99, 1, 11)
+	if !errors.Is(err, errStreakTooLong) {
+		t.Fatalf("SetUserStreak over the cap = %v, want errStreakTooLong", err)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 2 {
+		t.Errorf("completions = %d, want the 2 there were", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM admin_audit`); n != 0 {
+		t.Errorf("audit entries = %d, want none", n)
+	}
+
+	if err := b.SetUserStreak(99, 1, 10); err != nil {
+		t.Fatalf("SetUserStreak at the cap = %v", err)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 10 {
+		t.Errorf("completions at the cap = %d, want 10", n)
+	}
+}
+
+func TestRecordAchievements(t *testing.T) {
+	db := newTestDB(t)
+
+	tests := []struct {
+		name   string
+		userID int64
+		streak int
+		want   []string
+	}{
+		{"too short", 1, 6, nil},
+		{"first milestone", 1, 7, []string{"7_days"}},
+		{"already earned", 1, 8, nil},
+		{"several at once", 2, 120, []string{"7_days", "30_days", "100_days"}},
+		{"next one only", 2, 200, []string{"200_days"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			earned, err := recordAchievements(db, tt.userID, tt.streak, daysAgo(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range earned {
+				got = append(got, m.Type)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("recordAchievements(%d) = %v, want %v", tt.streak, got, tt.want)
+			}
+		})
+	}
+
+	got := dumpTable(t, db, `SELECT user_id, achievement_type FROM achievements ORDER BY user_id, rowid`)
+	want := []string{"1 7_days", "2 7_days", "2 30_days", "2 100_days", "2 200_days"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("achievements = %v, want %v", got, want)
+	}
+}
+
+func TestCompletionRollsBackWhenAchievementFails(t *testing.T) {
+	b, _ := newTestBot(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addCompletions(t, b, 1, datesBetween(daysAgo(29), daysAgo(1))...)
+
+	// The 7-day milestone is written, then the 30-day one fails
+	_, err := b.db.Exec(`
+		CREATE TRIGGER fail_30_days BEFORE INSERT ON achievements
+		WHEN NEW.achievement_type = '30_days'
+		BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.completeChallenge(callback(1, -100, "complete_challenge"), ""); err == nil {
+		t.Fatal("completeChallenge succeeded, want the achievement error")
+	}
+
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE completed_at = ?`, daysAgo(0)); n != 0 {
+		t.Error("today's completion was kept without its achievements")
+	}
+	if n := countRows(t, b, `SELECT 1 FROM achievements`); n != 0 {
+		t.Errorf("%d achievements were kept from the failed completion, want none", n)
+	}
+}
+
+// zoneNearMidnight returns an Etc zone where it's now the hour just before or just after
+// midnight and the date differs from challengeLocation's, along with that hour
+func zoneNearMidnight(t *testing.T) (*time.Location, int) {
+	t.Helper()
+
+	now := time.Now()
+	for offset := -12; offset <= 14; offset++ {
+		// Etc zones count the other way round: Etc/GMT-5 is UTC+5
+		loc, err := time.LoadLocation(fmt.Sprintf("Etc/GMT%+d", -offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		local := now.In(loc)
+		if (local.Hour() == 23 || local.Hour() == 0) && local.Format("2006-01-02") != daysAgo(0) {
+			return loc, local.Hour()
+		}
+	}
+	t.Fatal("no zone near midnight on another date")
+	return nil, 0
+}
+
+func TestCompletionAndReminderAgreeNearMidnight(t *testing.T) {
+	b, tg := newTestBot(t)
+	loc, hour := zoneNearMidnight(t)
+
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	if err := b.setChatSetting(-100, "timezone", loc.String()); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []int64{1, 2} {
+		if err := b.setUserSetting(userID, "remind_at", fmt.Sprintf("%02d:00", hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.completeChallenge(callback(1, -100, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+	localToday := time.Now().In(loc).Format("2006-01-02")
+	if got := dumpTable(t, b.db, `SELECT date(completed_at) FROM daily_completions`); fmt.Sprint(got) != "["+localToday+"]" {
+		t.Fatalf("completion stored for %v, want the chat's date %s", got, localToday)
+	}
+
+	tg.reset()
+	b.runDueReminders(reminderWindow(loc, hour))
+	if texts := tg.textsTo(-100); len(texts) != 1 {
+		t.Errorf("sent %d reminders near midnight, want only Boris's", len(texts))
+	}
+
+	// The scoreboard and the streaks read the completion on the same date
+	participants, err := b.getParticipantsList(-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]string)
+	for _, p := range participants {
+		statuses[p.Name] = fmt.Sprintf("%v %d", p.Completed, p.Streak)
+	}
+	if want := map[string]string{"Anna": "true 1", "Boris": "false 0"}; fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("list = %v, want %v", statuses, want)
+	}
+	list, err := b.buildParticipantsList(-100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if localDate := time.Now().In(loc).Format("02.01.2006"); !strings.Contains(list, localDate) {
+		t.Errorf("scoreboard isn't dated %s:\n%s", localDate, list)
+	}
+
+	if _, err := b.db.Exec(`UPDATE participants SET joined_at = ?`, daysAgo(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.completeChallenge(callback(2, -100, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if streak, err := b.getConsecutiveCompletionDays(-100); err != nil || streak != 1 {
+		t.Errorf("group streak = %d, %v; want the day everyone completed", streak, err)
+	}
+
+	// Past the deadline a completion counts for the chat's tomorrow, which is a valid date
+	if err := b.setChatSetting(-100, "deadline", "00:00"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setChatSetting(-100, "deadline_policy", deadlinePolicyNextDay); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.completeChallenge(callback(1, -100, "complete_challenge"), ""); err != nil {
+		t.Fatal(err)
+	}
+	localTomorrow := time.Now().In(loc).AddDate(0, 0, 1).Format("2006-01-02")
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 1 AND completed_at = ?`, localTomorrow); n != 1 {
+		t.Errorf("no completion for the chat's tomorrow %s after the deadline", localTomorrow)
+	}
+}
+
+func TestSetUserStreakValidatesBeforeTouchingDB(t *testing.T) {
+	db := newTestDB(t)
+	db.Close()
+	b := &Bot{db: db, maxStreakDays: 3650}
+
+	tests := []struct {
+		days int
+		want error
+	}{
+		{-1, errNegativeStreak},
+		{3651, errStreakTooLong},
+		{100000, errStreakTooLong},
+	}
+
+	for _, tt := range tests {
+		// The database is closed, so any query would fail with another error
+		if err := b.SetUserStreak(99, 1, tt.days); !errors.Is(err, tt.want) {
+			t.Errorf("SetUserStreak(%d) = %v, want %v", tt.days, err, tt.want)
+		}
+	}
+}
+
+func TestCustomStreakInputRefusesHugeValue(t *testing.T) {
+	b, tg := newTestBot(t)
+	b.admins[99] = true
+
+	addParticipant(t, b, 1, -100, "Anna")
+	_, err := b.db.Exec(`INSERT INTO bot_state (user_id, chat_id, state, context) VALUES (99, 99, 'waiting_custom_streak', '1')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleCustomStreakInput(reply(99, 99, "100000")); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(Messages["streak_too_long"], b.maxStreakDays, GetDayWord(b.maxStreakDays))
+	if texts := tg.textsTo(99); len(texts) != 1 || texts[0] != want {
+		t.Errorf("sent %q, want %q", texts, want)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions`); n != 0 {
+		t.Errorf("completions = %d, want none", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM bot_state WHERE state = 'waiting_custom_streak'`); n != 1 {
+		t.Error("the prompt stopped waiting, want the admin to be able to send a smaller number")
+	}
+}
+
+func TestGetEnvIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []int64
+	}{
+		{"unset", "", nil},
+		{"single", "42", []int64{42}},
+		{"list", "1,2,3", []int64{1, 2, 3}},
+		{"spaces and empty parts", " 7 , ,8,", []int64{7, 8}},
+		{"invalid entries skipped", "5,abc,6.5,9", []int64{5, 9}},
+		{"negative", "-100", []int64{-100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_ADMIN_IDS", tt.value)
+			got := getEnvIDs("TEST_ADMIN_IDS")
+			if len(got) != len(tt.want) {
+				t.Fatalf("getEnvIDs(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			for _, id := range tt.want {
+				if !got[id] {
+					t.Errorf("getEnvIDs(%q) = %v, missing %d", tt.value, got, id)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminCommandsRefuseNonAdmins(t *testing.T) {
+	b, tg := newTestBot(t)
+	addParticipant(t, b, 1, 1, "Anna")
+
+	if err := b.handleListUserIDs(command(1, 1, "/listuserids")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handleAdjustStreak(command(1, 1, "/adjuststreak")); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := tg.textsTo(1)
+	if len(texts) != 2 || texts[0] != Messages["not_allowed"] || texts[1] != Messages["not_allowed"] {
+		t.Errorf("sent %q, want two refusals", texts)
+	}
+}
+
+func TestMilestoneStreaksAwardAchievements(t *testing.T) {
+	tests := []struct {
+		streak  int
+		want    []string
+		message string
+	}{
+		{100, []string{"7_days", "30_days", "100_days"}, "achievement_100_congrats"},
+		{365, []string{"7_days", "30_days", "100_days", "200_days", "365_days"}, "achievement_365_congrats"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.streak), func(t *testing.T) {
+			b, tg := newTestBot(t)
+			addParticipant(t, b, 1, -100, "Anna")
+
+			if err := b.checkAndRecordAchievements(1, tt.streak); err != nil {
+				t.Fatal(err)
+			}
+
+			got := dumpTable(t, b.db, `SELECT achievement_type FROM achievements WHERE user_id = 1 ORDER BY rowid`)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("achievements = %v, want %v", got, tt.want)
+			}
+			// Only the highest milestone is announced
+			if texts := tg.textsTo(-100); len(texts) != 1 || texts[0] != Messages[tt.message] {
+				t.Errorf("announced %q, want %q", texts, Messages[tt.message])
+			}
+
+			// Reaching the same streak again awards nothing new
+			tg.reset()
+			if err := b.checkAndRecordAchievements(1, tt.streak); err != nil {
+				t.Fatal(err)
+			}
+			if n := countRows(t, b, `SELECT 1 FROM achievements`); n != len(tt.want) {
+				t.Errorf("achievements after repeat = %d, want %d", n, len(tt.want))
+			}
+			if texts := tg.textsTo(-100); len(texts) != 0 {
+				t.Errorf("announced again: %q", texts)
+			}
+		})
+	}
+}
+
+func TestWhitespaceNameAsksAgainThenUsesUsername(t *testing.T) {
+	b, tg := newTestBot(t)
+
+	const chatID = -100
+	if _, err := b.db.Exec(`INSERT INTO pending_joins (user_id, chat_id) VALUES (7, ?)`, chatID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.handleNameResponse(reply(7, chatID, "  \t\n ")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(chatID); len(texts) != 1 || texts[0] != Messages["enter_name_empty"] {
+		t.Errorf("sent %q, want the name prompt again", texts)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM participants`); n != 0 {
+		t.Errorf("participants = %d, want none after a blank name", n)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM pending_joins WHERE user_id = 7`); n != 1 {
+		t.Error("pending join was removed, want it kept for the retry")
+	}
+
+	if err := b.handleNameResponse(reply(7, chatID, " ")); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpTable(t, b.db, `SELECT user_id, display_name FROM participants`)
+	if want := []string{"7 user7"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("participants = %v, want %v", got, want)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM pending_joins`); n != 0 {
+		t.Errorf("pending joins = %d, want none after joining", n)
+	}
+}
+
+func TestConcurrentCompletionsInsertOnce(t *testing.T) {
+	b, _ := newTestBot(t)
+	addParticipant(t, b, 1, -100, "Anna")
+	today := daysAgo(0)
+
+	const taps = 8
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		added = make(chan bool, taps)
+		errs  = make(chan error, taps)
+	)
+	for i := 0; i < taps; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			ok, err := b.insertCompletion(b.db, 1, today, "", "")
+			if err != nil {
+				errs <- err
+				return
+			}
+			added <- ok
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(added)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("insertCompletion: %v", err)
+	}
+	var inserted int
+	for ok := range added {
+		if ok {
+			inserted++
+		}
+	}
+	if inserted != 1 {
+		t.Errorf("%d taps reported inserting, want 1", inserted)
+	}
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 1`); n != 1 {
+		t.Errorf("completions = %d, want 1", n)
+	}
+}
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestSendRetryDelay(t *testing.T) {
+	const backoff = 2 * time.Second
+
+	tests := []struct {
+		name      string
+		err       error
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{"too many requests with retry_after", &tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}, 7 * time.Second, true},
+		{"too many requests without retry_after", &tgbotapi.Error{Code: 429}, backoff, true},
+		{"server error", &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, backoff, true},
+		{"blocked by the user", &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}, 0, false},
+		{"bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}, 0, false},
+		{"network error", &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: errors.New("connection reset by peer")}, backoff, true},
+		{"wrapped network error", fmt.Errorf("send: %w", &url.Error{Op: "Post", Err: errors.New("EOF")}), backoff, true},
+		{"timeout", &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: timeoutError{}}, 0, false},
+		{"other error", errors.New("json: cannot unmarshal"), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := sendRetryDelay(tt.err, backoff)
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("sendRetryDelay() = %v, %v, want %v, %v", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestMutedUserIsNotReminded(t *testing.T) {
+	b, tg := newTestBot(t)
+	addParticipant(t, b, 1, -100, "Anna")
+	addParticipant(t, b, 2, -100, "Boris")
+	today := daysAgo(0)
+
+	if err := b.handleMute(command(1, 1, "/mute")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(1); len(texts) != 1 || texts[0] != Messages["reminders_muted"] {
+		t.Errorf("sent %q, want the mute confirmation", texts)
+	}
+
+	got, err := b.reminderCandidates(-100, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{2}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("reminded %v while 1 is muted, want %v", got, want)
+	}
+
+	// A muted user still completes as usual
+	addCompletions(t, b, 1, today)
+	if n := countRows(t, b, `SELECT 1 FROM daily_completions WHERE user_id = 1`); n != 1 {
+		t.Errorf("completions of the muted user = %d, want 1", n)
+	}
+
+	tg.reset()
+	if err := b.handleUnmute(command(1, 1, "/unmute")); err != nil {
+		t.Fatal(err)
+	}
+	if texts := tg.textsTo(1); len(texts) != 1 || texts[0] != Messages["reminders_unmuted"] {
+		t.Errorf("sent %q, want the unmute confirmation", texts)
+	}
+	got, err = b.reminderCandidates(-100, daysAgo(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 2}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("reminded %v after unmute, want %v", got, want)
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"Anna", "Anna"},
+		{"=HYPERLINK(\"x\")", "'=HYPERLINK(\"x\")"},
+		{"+7 999", "'+7 999"},
+		{"-1", "'-1"},
+		{"@boris", "'@boris"},
+		{"a=b", "a=b"},
+	}
+
+	for _, tt := range tests {
+		if got := csvSafe(tt.value); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBuildExportCSV(t *testing.T) {
+	b, _ := newTestBot(t)
+	addParticipant(t, b, 1, -100, "Анна")
+	addParticipant(t, b, 2, -100, "=1+1")
+	addCompletions(t, b, 1, daysAgo(1), daysAgo(0))
+
+	data, err := b.buildExportCSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("\uFEFF")) {
+		t.Error("export has no byte order mark, spreadsheets would garble Cyrillic names")
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\uFEFF")))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"user_id", "display_name", "completed_at"},
+		{"1", "Анна", daysAgo(1)},
+		{"1", "Анна", daysAgo(0)},
+		{"2", "'=1+1", ""},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("export = %q, want %q", records, want)
+	}
+}
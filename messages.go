@@ -1,27 +1,526 @@
 package main
 
+import (
+	"fmt"
+	"time"
+)
+
 var Messages = map[string]string{
 	"want_to_join":                "Здесь ежедневно кайфуют от зарядочки. Тоже хочешь?",
+	"join_offer_shown":            "Нажми «Хочу 💪» в сообщении выше ☝️",
+	"bot_added":                   "Всем привет! Я слежу за ежедневной зарядочкой: отмечайтесь каждый день и держите серию 💪",
 	"enter_name":                  "Как к тебе обращаться?",
+	"enter_name_empty":            "Имя не может быть пустым. Как к тебе обращаться?",
 	"already_completed":           "Ты уже отметился, не суетись :)",
 	"no_completion_today":         "У тебя нет отметки о выполнении за сегодня",
 	"completion_cancelled":        "Отметка о выполнении отменена",
+	"undo_confirm":                "Точно отменить сегодняшнюю отметку? Серия может прерваться",
+	"undo_kept":                   "Отметка остаётся 💪",
+	"undo_expired":                "Этот день уже прошёл, отметку за него здесь не отменить",
 	"reminder":                    "Не забудь сделать зарядочку сегодня! 💪",
 	"last_chance":                 "Последний шанс!",
 	"hall_of_fame":                "Аллея славы",
 	"hall_of_fame_separator":      "--------------------------------------",
 	"achievement_100":             "🌟 100 дней:",
+	"achievement_200":             "⭐ 200 дней:",
 	"achievement_365":             "👑 365 дней:",
+	"achievement_500":             "💎 500 дней:",
+	"achievement_1000":            "🚀 1000 дней:",
 	"achievement_reached":         "достиг",
 	"no_achievements":             "–",
+	"achievement_7_congrats":      "🏅 Неделя подряд! Привычка начинает складываться — так держать!",
+	"achievement_30_congrats":     "🥉 30 дней подряд! Целый месяц зарядочек — это уже не случайность, а привычка",
 	"achievement_100_congrats":    "🏆 100 дней подряд? Это серьезное достижение! Твоя дисциплина и настойчивость заслуживают места в Аллее Славы",
+	"achievement_200_congrats":    "🔥 200 дней подряд! Вдвое больше сотни — дисциплина на зависть всем",
 	"achievement_365_congrats":    "🏆🏆🏆 Невероятное достижение! Целый год ежедневных зарядок — это настоящий подвиг силы воли и дисциплины. Ты официально вошел в историю и заслуженно занимаешь почетное место в Аллее Славы!",
+	"achievement_500_congrats":    "💎 500 дней подряд! Полтысячи дней без единого пропуска — таких в клубе единицы",
+	"achievement_1000_congrats":   "🚀 1000 дней подряд! Это уже легенда клуба. Снимаем шляпу!",
 	"error_try_later":             "Произошла ошибка. Попробуйте позже.",
 	"already_completed_yesterday": "Отметка за вчера уже стоит.",
+	"db_outage":                   "⚠️ Временные технические неполадки. Бот вернётся, как только всё починим, отметки пока не сохраняются",
+	"error_unexpected":            "Что-то пошло не так. Попробуй ещё раз",
 	"error_marking_yesterday":     "Произошла ошибка при отметке вчерашнего дня.",
 	"yesterday_marked_success":    "Вчерашний день успешно отмечен!",
+	"markdate_pick":               "За какой день отметить зарядочку?",
+	"markdate_none":               "За последние %d %s все дни уже отмечены 💪",
+	"markdate_out_of_range":       "Отметить можно только один из последних %d %s",
+	"markdate_already":            "За %s зарядочка уже отмечена",
+	"markdate_done":               "✅ Зарядочка за %s отмечена!",
 	"backfill_done":               "Готово. Проставил пропущенные дни до сегодняшнего дня. Вставлено отметок: %d",
 	"backfill_none":               "Пропущенных дней не обнаружено. Все в порядке ✨",
+	"risk_reminder":               "⚠️ %s, твоя серия %d %s под угрозой! Не забудь сделать зарядочку сегодня 💪",
+	"risk_remind_done":            "Напомнил участникам, у которых серия под угрозой: %d",
+	"stats_header":                "📊 Статистика %s",
+	"stats_streak":                "🔥 Текущая серия: %d %s",
+	"stats_total":                 "✅ Всего зарядочек: %d",
+	"stats_claps":                 "👏 Аплодисментов от участников: %d",
+	"stats_recent":                "🗓 Последние отметки:",
+	"stats_no_completions":        "Отметок пока нет",
+	"mark_cooldown":               "Не так быстро! Изменить отметку можно будет через %d сек.",
+	"streak_record":               "🏆 Рекорд клуба: %d %s — %s (%s – %s)",
+	"no_streak_record":            "Рекорда клуба пока нет. Стань первым! 💪",
+	"new_streak_record":           "🎉 Новый рекорд клуба! %s — %d %s подряд!",
+	"not_allowed":                 "Эта команда доступна только администраторам бота",
+	"chat_managers_only":          "Настройки чата могут менять только его администраторы",
+	"backup_caption":              "💾 Резервная копия базы от %s",
+	"export_caption":              "📊 Участники и их зарядочки на %s",
+	"deadline_passed":             "⏰ Дедлайн на сегодня уже прошёл, отметка не засчитана",
+	"deadline_next_day":           "⏰ Дедлайн на сегодня прошёл, поэтому отметка засчитана на завтра",
+	"deadline_set_reject":         "Дедлайн установлен на %s. Отметки после него не засчитываются",
+	"deadline_set_nextday":        "Дедлайн установлен на %s. Отметки после него засчитываются на следующий день",
+	"deadline_off":                "Дедлайн отключен",
+	"deadline_usage":              "Использование: /setdeadline ЧЧ:ММ [reject|nextday] или /setdeadline off",
+	"digest":                      "📬 Твоя неделя\n\nЗарядочек за 7 дней: %d из 7\nТекущая серия: %d %s\n\n%s",
+	"digest_encourage_perfect":    "Идеальная неделя! Так держать 🏆",
+	"digest_encourage_good":       "Отличный темп, ещё чуть-чуть до идеальной недели 💪",
+	"digest_encourage_low":        "Каждый новый день — шанс начать заново. Ты справишься! 🌱",
+	"digest_on":                   "Личная сводка будет приходить в личные сообщения: %s, %d:00",
+	"digest_off":                  "Личная сводка отключена",
+	"digest_usage":                "Использование: /digest пн 9 (день недели и час) или /digest off\nЧтобы сводка дошла, напиши боту в личку хотя бы раз",
+	"rules_header":                "📜 Правила челленджа",
+	"default_rules":               "Каждый день делай зарядочку и отмечайся кнопкой «Сделать зарядочку». Любая зарядка засчитывается — главное, регулярность 💪",
+	"rules_saved":                 "Правила челленджа сохранены ✅",
+	"rules_reset":                 "Правила челленджа сброшены на стандартные",
+	"participants_header":         "Участники:",
+	"group_streak":                "🔥 Совместных дней подряд: %d",
+	"weekly_summary_header":       "📅 Итоги недели %s — %s",
+	"weekly_summary_line":         "- %s: %d из %d",
+	"weekly_summary_perfect":      "🏆 Идеальная неделя: %s",
+	"weekly_summary_no_perfect":   "Идеальной недели в этот раз ни у кого, но новая уже началась 💪",
+	"group_combo":                 "%s %d %s все вместе!",
+	"all_completed_today":         "🎉 Все сделали зарядку сегодня!",
+	"all_completed_yesterday":     "🎉 Вчера зарядку сделали все!",
+	"lang_chat_set":               "Язык чата: %s",
+	"lang_user_set":               "Язык личных сообщений: %s",
+	"lang_usage":                  "Использование: /setlang ru|en. В группе меняет язык чата, в личке — твой язык",
+	"compare_this_week":           "📈 Эта неделя: %.0f%% выполненных зарядочек",
+	"compare_last_week":           "Прошлая неделя: %.0f%%",
+	"compare_delta":               "%s %+.0f п.п. к прошлой неделе",
+	"compare_first_week":          "Прошлой недели для сравнения пока нет — это только начало!",
+	"compare_no_data":             "На этой неделе ещё нет участников для сравнения",
+	"compare_usage":               "Использование: /compare week",
+	"dump_header":                 "🗂 Отметки пользователя %d (всего %d), страница %d/%d:",
+	"dump_empty":                  "У пользователя %d нет ни одной отметки",
+	"dump_usage":                  "Использование: /dump ID (ID можно узнать через /listuserids)",
+	"silent_reminders_always":     "🔕 Напоминания в этом чате будут приходить без звука",
+	"silent_reminders_hours":      "🔕 Напоминания будут приходить без звука с %d:00 до %d:00",
+	"silent_reminders_off":        "🔔 Напоминания снова приходят со звуком",
+	"silent_reminders_usage":      "Использование: /silentreminders always, /silentreminders 22-8 (часы без звука) или /silentreminders off",
+	"streak_mode_strict":          "Серии считаются по дням подряд",
+	"streak_mode_rolling":         "Серия сохраняется, пока за любые 7 дней есть хотя бы %d зарядочек",
+	"streak_mode_rolling_label":   "Серия: не меньше %d из 7 дней",
+	"streak_mode_usage":           "Использование: /streakmode strict или /streakmode rolling N (N от 1 до 7)",
+	"shoutout":                    "📣 %s передаёт респект %s (@%s):\n\n«%s»",
+	"shoutout_usage":              "Использование: /shoutout @username текст",
+	"shoutout_not_member":         "@%s не участвует в челлендже",
+	"shoutout_self":               "Себя похвалить можно и без бота 😉",
+	"shoutout_cooldown":           "Следующий шаут-аут можно отправить через %d мин.",
+	"leave_confirm":               "Точно выйти из челленджа? Напоминания прекратятся, а отметки и серия будут храниться ещё %d дней",
+	"leave_cancelled":             "Остаёмся в строю 💪",
+	"leave_goodbye":               "👋 Спасибо, что занимались с нами!",
+	"leave_done":                  "Вы вышли из челленджа. Ваши отметки и серия хранятся ещё %d дней — передумали, пишите /rejoin",
+	"leave_rejoin_hint":           "Вы вышли из челленджа. Вернуться со всей историей: /rejoin",
+	"rejoin_not_left":             "Вы и так в челлендже 💪",
+	"rejoin_expired":              "Вернуть историю уже нельзя. Присоединиться заново: /start",
+	"rejoin_done":                 "С возвращением! Ваша серия: %d",
+	"link_usage":                  "Использование: /link @username — зарядочка одного будет засчитываться обоим",
+	"link_self":                   "Связать себя с собой не получится 😉",
+	"link_already":                "Кто-то из вас уже связан с другим участником. Сначала /unlink",
+	"link_request":                "%s, %s предлагает делать зарядочку вместе: отметка одного будет засчитываться обоим. Согласны?",
+	"link_not_yours":              "Это приглашение не для вас",
+	"link_accepted":               "🤝 Готово! Теперь зарядочка одного засчитывается обоим. Отвязаться: /unlink",
+	"link_declined":               "Приглашение отклонено",
+	"link_none":                   "Вы ни с кем не связаны",
+	"link_removed":                "Связь удалена, теперь каждый отмечается сам",
+	"link_completed":              "🤝 Засчитано и для %s",
+	"achievement_scope_group":     "🏆 Поздравления с достижениями будут приходить в этот чат",
+	"achievement_scope_dm":        "🏆 Поздравления с достижениями будут приходить только в личные сообщения",
+	"achievement_scope_both":      "🏆 Поздравления с достижениями будут приходить и в этот чат, и в личные сообщения",
+	"achievement_broadcast":       "🏆 %s — %d %s зарядочек подряд! Давайте поздравим!",
+	"achievement_scope_usage":     "Использование: /achievementscope group|dm|both\nЧтобы поздравление дошло в личку, напиши боту хотя бы раз",
+	"reconcile_ok":                "Достижения совпадают с историей серий ✅",
+	"reconcile_header":            "🔎 Сверка достижений с историей серий",
+	"reconcile_granted":           "Выдано недостающих достижений:",
+	"reconcile_orphaned":          "Не подтверждаются историей (оставлены как есть):",
+	"reconcile_longest":           "самая длинная серия",
+	"vacation_on":                 "🏖 Хорошего отдыха! Пропуски во время отпуска не прервут серию, напоминания приходить не будут. Вернёшься — /vacation off",
+	"vacation_off":                "С возвращением! Серия сохранена, продолжаем 💪",
+	"vacation_already_on":         "Ты уже в отпуске. Чтобы вернуться, набери /vacation off",
+	"vacation_already_off":        "Ты и так участвуешь. Чтобы уйти в отпуск, набери /vacation on",
+	"vacation_usage":              "Использование: /vacation on или /vacation off",
+	"streak_cap_set":              "В списках серии длиннее %d дней будут показаны как «%[1]d+»",
+	"streak_cap_off":              "Серии в списках снова показываются полностью",
+	"streak_cap_usage":            "Использование: /streakcap N (например, 500) или /streakcap off",
+	"clap_added":                  "👏",
+	"clap_not_counted":            "Можно похлопать один раз и только другим",
+	"goal_set":                    "🎯 Личная цель: %d %s подряд",
+	"goal_progress":               "Прогресс: %d/%d %s",
+	"goal_none":                   "Личной цели пока нет. Поставь её командой /goal N, например /goal 50",
+	"goal_off":                    "Личная цель убрана",
+	"goal_too_small":              "Твоя серия уже %d %s — поставь цель побольше 😉",
+	"goal_usage":                  "Использование: /goal N (дней подряд), /goal — прогресс, /goal off — убрать цель",
+	"goal_reached":                "🎯 Личная цель достигнута: %d %s подряд! Горжусь тобой 💪\n\nКуда дальше? Поставь новую цель командой /goal N",
+	"clear_achievement_usage":     "Использование: /clearachievement userID или /clearachievement userID тип",
+	"clear_achievement_list":      "Достижения участника %d:",
+	"clear_achievement_none":      "У участника %d нет достижений",
+	"clear_achievement_unknown":   "Нет такого достижения. Доступные: %s",
+	"clear_achievement_missing":   "У участника нет достижения %s",
+	"clear_achievement_done":      "Достижение %s участника %d удалено и записано в журнал",
+	"streak_too_long":             "❌ Слишком длинная серия. Максимум — %d %s, введите число поменьше",
+	"audit_header":                "🧾 Последние изменения администраторов:",
+	"audit_line":                  "%s — админ %d, %s для %s: %d → %d",
+	"audit_empty":                 "Администраторы пока ничего не меняли",
+	"global_stats_header":         "📊 Статистика по всем чатам:",
+	"global_stats_line":           "%s — активных %d, сделали %d (%.0f%%), медиана серии %.1f",
+	"global_stats_empty":          "Статистики пока нет. Включите STATS_AGGREGATION=1, она считается раз в сутки",
+	"skip_today_break":            "😴 Отдыхай! Сегодня напоминаний не будет. Отметка отдыха не засчитывается как зарядочка, и серия прервётся",
+	"skip_today_keep":             "😴 Отдыхай! Сегодня напоминаний не будет, а серия не прервётся",
+	"skip_already_completed":      "Ты сегодня уже сделал зарядочку — отдыхать можно со спокойной совестью 😉",
+	"freeze_usage":                "Использование: /freeze — заморозить сегодня, /freeze 25.12.2024 — другой день (не дальше %d дней от сегодня)",
+	"freeze_done":                 "❄️ %s заморожен: серия не прервётся. В этом месяце осталось %d %s",
+	"freeze_limit":                "В этом месяце уже использованы все %d %s",
+	"freeze_already":              "Этот день уже заморожен ❄️",
+	"freeze_already_completed":    "В этот день уже есть зарядочка, замораживать нечего 💪",
+	"today_usage":                 "Использование: /today — поздравление за сегодня, /today 25.12.2024 — за другой день",
+	"today_congrats":              "🎉 Поздравление за %s:\n\n%s",
+	"today_none":                  "За %s зарядочки нет",
+	"today_no_message":            "За %s зарядочка есть, но поздравление не сохранилось",
+	"skip_mode_break":             "Дни отдыха прерывают серию, как обычный пропуск",
+	"skip_mode_keep":              "Дни отдыха не прерывают серию",
+	"skip_mode_usage":             "Использование: /skipmode break (отдых прерывает серию) или /skipmode keep (не прерывает)",
+	"status_header":               "⏳ Ты сейчас в процессе:",
+	"status_pending_join":         "вступление в челлендж — жду, как к тебе обращаться",
+	"status_waiting_streak":       "установка серии — жду число дней",
+	"status_idle":                 "Ничего не ждёт ответа, всё в порядке ✨",
+	"status_waiting_rename":       "смена имени — жду новое имя",
+	"enter_new_name":              "Как тебя теперь называть?",
+	"rename_done":                 "Готово, теперь ты %s ✏️",
+	"rename_too_long":             "Слишком длинное имя, максимум %d символов. Попробуй покороче",
+	"button_not_yours":            "Эта кнопка для другого участника",
+	"cancel_done":                 "Отменено ✅",
+	"anonymous_not_supported":     "Анонимные сообщения и сообщения от имени канала не засчитываются. Отключи анонимность администратора, чтобы участвовать",
+	"streak_min_set":              "В списках серии короче %d %s будут скрыты за «—»",
+	"streak_min_off":              "Серии в списках снова показываются с первого дня",
+	"streak_min_usage":            "Использование: /streakmin N (например, 3) или /streakmin off",
+	"keyboard_refreshed":          "%s, кнопки снова на месте 👇",
+	"refresh_keyboards_started":   "Возвращаю кнопки участникам: %d. Сообщу, когда закончу",
+	"refresh_keyboards_done":      "Кнопки возвращены: %d из %d",
+	"title_novice":                "Новичок",
+	"title_fighter":               "Боец",
+	"title_veteran":               "Ветеран",
+	"title_champion":              "Чемпион",
+	"title_legend":                "Легенда",
+	"walk_of_fame_on":             "🏆 Аллея славы снова показывается в списке участников",
+	"walk_of_fame_off":            "Аллея славы скрыта из списка участников, её можно посмотреть командой /fame",
+	"walk_of_fame_usage":          "Использование: /walkoffame on или /walkoffame off",
+	"fame_empty":                  "На аллее славы пока никого нет",
+	"quote_of_the_day":            "💬 Цитата дня: %s",
+	"quote_on":                    "💬 Полуденное напоминание теперь приходит с цитатой дня",
+	"quote_off":                   "Цитата дня больше не добавляется к напоминанию",
+	"quote_usage":                 "Использование: /quote on или /quote off",
+	"countdown":                   "⏳ %d %s до %d совместных!",
+	"countdown_reached":           "🎉 %d %s совместной серии! Так держать!",
+	"countdown_on":                "⏳ Когда до рубежа совместной серии останется %d дней или меньше, бот закрепит обратный отсчёт",
+	"countdown_off":               "Обратный отсчёт до рубежа совместной серии выключен",
+	"countdown_usage":             "Использование: /countdown on или /countdown off",
+	"group_threshold_set":         "Совместная серия продолжается, если зарядку сделали хотя бы %d%% участников",
+	"group_threshold_off":         "Совместная серия снова требует, чтобы зарядку сделали все участники",
+	"group_threshold_usage":       "Использование: /groupthreshold 90 (процент участников от 1 до 100) или /groupthreshold off",
+	"help_header":                 "Что умеет бот:",
+	"timezone_current":            "Часовой пояс чата: %s",
+	"timezone_set":                "🕛 Часовой пояс чата: %s. Отметки засчитываются за день по этому поясу, напоминания придут в %d:00 и %d:00 по местному времени",
+	"timezone_usage":              "Использование: /timezone Europe/Moscow (название пояса из базы IANA)",
+	"tiers_on":                    "Теперь при отметке нужно выбрать уровень: лёгкий, обычный или сложный. Серию продлевает любой уровень",
+	"tiers_off":                   "Уровни сложности выключены, отметка снова в одно нажатие",
+	"tiers_usage":                 "Использование: /tiers on или /tiers off",
+	"tier_pick":                   "Какой уровень сегодня?",
+	"stats_tiers":                 "🎚 Отметки по уровням:",
+	"reminder_header":             "⏰ Твои напоминания",
+	"reminder_active":             "Статус: напоминания приходят",
+	"reminder_vacation":           "Статус: ты в отпуске, напоминаний нет (/vacation off, чтобы вернуться)",
+	"reminder_muted":              "Статус: напоминания выключены (/unmute, чтобы вернуть)",
+	"reminder_inactive":           "Статус: напоминания приостановлены — бот не смог написать тебе. Любое сообщение боту их вернёт",
+	"reminder_done_today":         "Статус: сегодня зарядка уже отмечена, до завтра напоминаний не будет",
+	"reminder_skipped_today":      "Статус: сегодня день отдыха, до завтра напоминаний не будет",
+	"reminder_timezone":           "Часовой пояс чата: %s",
+	"reminder_next_noon":          "Напоминание: %s",
+	"remind_at_usage":             "Использование: /remindat ЧЧ:ММ, например /remindat 08:30. /remindat off вернёт время по умолчанию",
+	"remind_at_set":               "⏰ Буду напоминать в %02d:%02d по времени чата",
+	"remind_at_reset":             "⏰ Буду напоминать как все, в %d:00 по времени чата",
+	"remind_at_current":           "⏰ Напоминание приходит в %02d:%02d по времени чата. Изменить: /remindat ЧЧ:ММ",
+	"reminders_muted":             "🔕 Напоминания выключены. Отмечаться можно как обычно, вернуть напоминания: /unmute",
+	"reminders_unmuted":           "🔔 Напоминания снова включены",
+	"reminder_last_chance":        "Последний шанс: %s",
+	"reminder_silent":             "(без звука)",
+	"reminder_quiet":              "Тихие часы чата: %s",
+	"reminder_quiet_none":         "Тихие часы чата: нет",
+	"reminder_digest":             "Личная сводка: %s, %d:00",
+	"reminder_digest_none":        "Личная сводка: не подключена (/digest)",
+	"streak_best":                 ", рекорд %d",
+	"streak_total":                ", всего %d",
+	"leaderboard_header":          "🏅 Рейтинг: %s %d",
+	"leaderboard_line":            "%s %s — %d %s, серия %d",
+	"leaderboard_empty":           "В этом месяце отметок пока нет",
+	"calendar_caption":            "🗓 Зарядочек в этом месяце: %d",
+	"titles_on":                   "🎖 Рядом с именами теперь видны звания по длине серии",
+	"titles_off":                  "Звания рядом с именами скрыты",
+	"titles_usage":                "Использование: /titles on или /titles off",
+	"week_grid_on":                "🟩 Под именами теперь видны последние 7 дней",
+	"week_grid_off":               "Сетка последних 7 дней скрыта",
+	"week_grid_usage":             "Использование: /weekgrid on или /weekgrid off",
+	"markall_congrats":            "Зарядочка всей командой 🤝",
+	"markall_done":                "🤝 Отметил совместную зарядочку. Новых отметок: %d",
+	"certificate_title":           "СЕРТИФИКАТ",
+	"certificate_milestone":       "%d %s зарядочки подряд",
+	"certificate_date":            "Достигнуто: %s",
+	"certificate_total":           "Всего зарядочек: %d",
+	"certificate_none":            "Сертификат выдаётся за достижения: 7, 30, 100, 200, 365, 500 или 1000 дней подряд. Ещё немного! 💪",
+	"certificate_not_earned":      "Достижение «%d %s подряд» пока не получено, сертификат выдать не могу",
+	"certificate_usage":           "Использование: /certificate или /certificate 100",
+	"team_joined":                 "🤝 Чат теперь в команде «%s». Общий счёт — /teamstats",
+	"team_left":                   "Чат больше не участвует в командном зачёте",
+	"team_none":                   "Этот чат не состоит в команде. Администратор может добавить его командой /team join название",
+	"team_usage":                  "Использование: /team join название или /team leave",
+	"team_stats_header":           "🏟 Команда «%s»",
+	"team_stats_chat":             "• %s: зарядочек %d, сумма серий %d",
+	"team_stats_total":            "Итого: зарядочек %d, сумма серий %d",
+	"import_congrats":             "Перенесено из другого приложения 📥",
+	"import_done":                 "📥 Перенёс %d %s из другого приложения. Текущая серия: %d %s",
+	"import_disabled":             "Перенос серии в этом чате не включён. Попроси администратора",
+	"import_already_done":         "Серию можно перенести только один раз",
+	"import_too_many":             "Можно перенести не больше %d %s",
+	"import_usage":                "Использование: /importstreak N — число дней подряд до сегодняшнего (не больше %d)",
+	"import_on":                   "📥 Участники могут один раз перенести серию из другого приложения, до %d %s",
+	"import_off":                  "Перенос серий выключен",
+	"allow_import_usage":          "Использование: /allowimport N (наибольшее число дней) или /allowimport off",
+	"since_header":                "👋 С возвращением! Пока тебя не было:",
+	"since_new_members":           "• Новые участники: %s",
+	"since_new_achievements":      "• Новые достижения: %s",
+	"since_group_streak":          "• Совместная серия: было %d, стало %d",
+	"milestone_highlight_react":   "Бот будет ставить %s на объявления о достижениях",
+	"milestone_highlight_pin":     "📌 Объявления о достижениях будут закрепляться на сутки",
+	"milestone_highlight_off":     "Объявления о достижениях больше не выделяются",
+	"milestone_highlight_usage":   "Использование: /milestonehighlight react, /milestonehighlight pin или /milestonehighlight off",
+	"not_participant":             "Ты пока не участвуешь. Нажми /start, чтобы присоединиться",
+}
+
+// DefaultLang is used when no translation exists for the requested language
+const DefaultLang = "ru"
+
+// MessagesEn holds English translations. Missing keys fall back to Messages.
+var MessagesEn = map[string]string{
+	"reminder":                  "Don't forget to do your workout today! 💪",
+	"last_chance":               "Last chance!",
+	"hall_of_fame":              "Hall of fame",
+	"hall_of_fame_separator":    "--------------------------------------",
+	"fame_empty":                "Nobody is in the hall of fame yet",
+	"quote_of_the_day":          "💬 Quote of the day: %s",
+	"streak_best":               ", best %d",
+	"streak_total":              ", total %d",
+	"leaderboard_header":        "🏅 Leaderboard: %s %d",
+	"leaderboard_line":          "%s %s — %d %s, streak %d",
+	"leaderboard_empty":         "No completions this month yet",
+	"calendar_caption":          "🗓 Workouts this month: %d",
+	"not_participant":           "You aren't taking part yet. Send /start to join",
+	"button_not_yours":          "This button is for another member",
+	"db_outage":                 "⚠️ Temporary technical issues. The bot will be back as soon as they're fixed, completions aren't saved for now",
+	"markdate_pick":             "Which day do you want to mark?",
+	"markdate_none":             "All days of the last %d %s are already marked 💪",
+	"markdate_out_of_range":     "You can only mark one of the last %d %s",
+	"markdate_already":          "%s is already marked",
+	"markdate_done":             "✅ Workout for %s marked!",
+	"achievement_100":           "🌟 100 days:",
+	"achievement_200":           "⭐ 200 days:",
+	"achievement_365":           "👑 365 days:",
+	"achievement_500":           "💎 500 days:",
+	"achievement_1000":          "🚀 1000 days:",
+	"achievement_reached":       "reached",
+	"achievement_broadcast":     "🏆 %s — %d %s of workouts in a row! Let's congratulate them!",
+	"no_achievements":           "–",
+	"risk_reminder":             "⚠️ %s, your %d %s streak is at risk! Don't forget to work out today 💪",
+	"risk_remind_done":          "Reminded members whose streak is at risk: %d",
+	"digest":                    "📬 Your week\n\nWorkouts in the last 7 days: %d of 7\nCurrent streak: %d %s\n\n%s",
+	"digest_encourage_perfect":  "A perfect week! Keep it up 🏆",
+	"digest_encourage_good":     "Great pace, a perfect week is within reach 💪",
+	"digest_encourage_low":      "Every day is a new chance to start again. You've got this! 🌱",
+	"participants_header":       "Members:",
+	"group_streak":              "🔥 Days in a row together: %d",
+	"weekly_summary_header":     "📅 Week of %s — %s",
+	"weekly_summary_line":       "- %s: %d of %d",
+	"weekly_summary_perfect":    "🏆 Perfect week: %s",
+	"weekly_summary_no_perfect": "No perfect weeks this time, but a new one has just started 💪",
+	"group_combo":               "%s %d %s all together!",
+	"all_completed_today":       "🎉 Everyone did their workout today!",
+	"all_completed_yesterday":   "🎉 Everyone did their workout yesterday!",
+	"streak_mode_rolling_label": "Streak: at least %d of 7 days",
+	"lang_chat_set":             "Chat language: %s",
+	"lang_user_set":             "Your DM language: %s",
+	"lang_usage":                "Usage: /setlang ru|en. In a group it sets the chat language, in private — yours",
+	"title_novice":              "Rookie",
+	"title_fighter":             "Fighter",
+	"title_veteran":             "Veteran",
+	"title_champion":            "Champion",
+	"title_legend":              "Legend",
+	"stats_header":              "📊 %s's stats",
+	"stats_streak":              "🔥 Current streak: %d %s",
+	"stats_total":               "✅ Workouts in total: %d",
+	"stats_claps":               "👏 Claps from members: %d",
+	"stats_tiers":               "🎚 Completions by level:",
+	"stats_recent":              "🗓 Latest completions:",
+	"stats_no_completions":      "No completions yet",
+	"freeze_usage":              "Usage: /freeze — freeze today, /freeze 25.12.2024 — another day (at most %d days from today)",
+	"freeze_done":               "❄️ %s is frozen: your streak won't break. %d %s left this month",
+	"freeze_limit":              "All %d %s of this month are already used",
+	"freeze_already":            "This day is already frozen ❄️",
+	"freeze_already_completed":  "You already worked out that day, nothing to freeze 💪",
+	"streak_min_set":            "Streaks shorter than %d %s will be shown as «—» in lists",
+	"streak_min_off":            "Streaks are shown in lists from the first day again",
+	"streak_min_usage":          "Usage: /streakmin N (e.g. 3) or /streakmin off",
+	"countdown":                 "⏳ %d %s to %d together!",
+	"countdown_reached":         "🎉 %d %s of the group streak! Keep it up!",
+	"import_done":               "📥 Imported %d %s from another app. Current streak: %d %s",
+	"import_disabled":           "Streak import isn't enabled in this chat. Ask an admin",
+	"import_already_done":       "A streak can only be imported once",
+	"import_too_many":           "You can import at most %d %s",
+	"import_usage":              "Usage: /importstreak N — the number of days in a row up to today (at most %d)",
+	"import_on":                 "📥 Members can import their streak from another app once, up to %d %s",
+	"import_off":                "Streak import is off",
+	"allow_import_usage":        "Usage: /allowimport N (the most days) or /allowimport off",
+	"not_allowed":               "This command is only available to the bot's admins",
+}
+
+var Translations = map[string]map[string]string{
+	DefaultLang: Messages,
+	"en":        MessagesEn,
+}
+
+// t returns the message for key in lang, falling back to the default language
+func t(lang, key string) string {
+	if msg, ok := Translations[lang][key]; ok {
+		return msg
+	}
+	return Messages[key]
+}
+
+// PluralForms are the forms of the nouns printed next to counts. Russian has three:
+// for 1 ("день"), for 2-4 ("дня") and for 5-20 ("дней"); English has two.
+var PluralForms = map[string]map[string][]string{
+	"day": {
+		"ru": {"день", "дня", "дней"},
+		"en": {"day", "days"},
+	},
+	"time": {
+		"ru": {"раз", "раза", "раз"},
+		"en": {"time", "times"},
+	},
+	"freeze": {
+		"ru": {"заморозка", "заморозки", "заморозок"},
+		"en": {"freeze", "freezes"},
+	},
+}
+
+// pluralize returns the form of noun (a PluralForms key) that goes with n in lang
+func pluralize(n int, noun, lang string) string {
+	forms, ok := PluralForms[noun][lang]
+	if !ok {
+		forms = PluralForms[noun][DefaultLang]
+	}
+	if n < 0 {
+		n = -n
+	}
+
+	if len(forms) == 2 {
+		if n == 1 {
+			return forms[0]
+		}
+		return forms[1]
+	}
+
+	if n%100 >= 11 && n%100 <= 19 {
+		return forms[2]
+	}
+	switch n % 10 {
+	case 1:
+		return forms[0]
+	case 2, 3, 4:
+		return forms[1]
+	default:
+		return forms[2]
+	}
+}
+
+// DayWord returns the localized form of "day" for count
+func DayWord(lang string, days int) string {
+	return pluralize(days, "day", lang)
+}
+
+// WeekdayName returns the localized weekday name
+func WeekdayName(lang string, weekday time.Weekday) string {
+	if lang == "en" {
+		return weekday.String()
+	}
+	return WeekdayNames[weekday.String()]
+}
+
+// MonthNames are the localized month names, January first
+var MonthNames = map[string][]string{
+	"ru": {"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь", "Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь"},
+	"en": {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+}
+
+// CalendarWeekdays are the weekday column headers of the calendar, Monday first
+var CalendarWeekdays = map[string][]string{
+	"ru": {"пн", "вт", "ср", "чт", "пт", "сб", "вс"},
+	"en": {"Mo", "Tu", "We", "Th", "Fr", "Sa", "Su"},
+}
+
+// langOrDefault returns lang if there are translations for it, DefaultLang otherwise
+func langOrDefault(lang string) string {
+	if _, ok := MonthNames[lang]; ok {
+		return lang
+	}
+	return DefaultLang
+}
+
+// MonthName returns the localized month name
+func MonthName(lang string, month time.Month) string {
+	return MonthNames[langOrDefault(lang)][month-1]
+}
+
+// StreakTitles maps streak lengths to title message keys, longest first.
+// A participant gets the first title whose minimum their streak reaches.
+var StreakTitles = []struct {
+	MinDays int
+	Key     string
+}{
+	{365, "title_legend"},
+	{100, "title_champion"},
+	{30, "title_veteran"},
+	{7, "title_fighter"},
+	{0, "title_novice"},
+}
+
+// StreakTitle returns the localized title for a streak length
+func StreakTitle(lang string, streak int) string {
+	for _, title := range StreakTitles {
+		if streak >= title.MinDays {
+			return t(lang, title.Key)
+		}
+	}
+	return t(lang, StreakTitles[len(StreakTitles)-1].Key)
+}
+
+var RelativeDateLabels = map[string]map[string]string{
+	"ru": {
+		"today":     "сегодня",
+		"yesterday": "вчера",
+		"days_ago":  "%d %s назад",
+	},
+	"en": {
+		"today":     "today",
+		"yesterday": "yesterday",
+		"days_ago":  "%d %s ago",
+	},
 }
 
 var CongratsMessages = []string{
@@ -73,6 +572,34 @@ var CongratsMessages = []string{
 	"Лень сегодня получила ушла в отпуск! 📜",
 }
 
+// Quotes are the built-in quotes of the day, QUOTES_FILE replaces them
+var Quotes = []string{
+	"Движение — это жизнь.",
+	"Путь в тысячу ли начинается с первого шага. (Лао-цзы)",
+	"Маленькие шаги каждый день складываются в большие результаты.",
+	"Лучшая зарядка — та, которую ты сделал.",
+	"Не жди мотивации, начни — и она догонит.",
+	"Сегодняшняя зарядка — подарок себе завтрашнему.",
+	"Дисциплина — это помнить, чего ты хочешь.",
+}
+
+// CatchUpCongratsMessages congratulate on marking yesterday after the fact
+var CatchUpCongratsMessages = []string{
+	"Наверстал вчерашний день! 💪",
+	"Вчерашняя зарядочка засчитана — серия спасена! 🛟",
+	"Лучше поздно, чем никогда! Вчерашний день на месте ✅",
+	"Вчера ты тоже был молодцом, просто забыл рассказать 😉",
+	"Отметка задним числом принята, серия продолжается! 🔥",
+}
+
+// PastDayCongratsMessages congratulate on marking an earlier day after the fact, without naming it
+var PastDayCongratsMessages = []string{
+	"Пропущенный день наверстан! 💪",
+	"Отметка задним числом принята, серия на месте! 🔥",
+	"Лучше поздно, чем никогда! День засчитан ✅",
+	"Ты и тогда был молодцом, просто забыл рассказать 😉",
+}
+
 var WeekdayNames = map[string]string{
 	"Monday":    "Понедельник ;)",
 	"Tuesday":   "Вторник",
@@ -83,30 +610,121 @@ var WeekdayNames = map[string]string{
 	"Sunday":    "Воскресенье",
 }
 
+var WeekdayShortNames = map[string]time.Weekday{
+	"пн": time.Monday,
+	"вт": time.Tuesday,
+	"ср": time.Wednesday,
+	"чт": time.Thursday,
+	"пт": time.Friday,
+	"сб": time.Saturday,
+	"вс": time.Sunday,
+}
+
 var ButtonLabels = map[string]string{
 	"update":         "Обновить",
 	"do_exercise":    "Сделать зарядочку",
 	"join_challenge": "Хочу 💪",
 	"mark_yesterday": "Отметить за вчера",
+	"undo_complete":  "Отменить зарядочку",
+	"cancel":         "Отменить",
+	"rename":         "✏️ Имя",
+	"undo_confirm":   "Да, отменить",
+	"undo_cancel":    "Нет",
+	"leave_confirm":  "Да, выйти",
+	"leave_cancel":   "Остаться",
+	"link_accept":    "🤝 Согласен",
+	"link_decline":   "Нет",
+}
+
+// BotCommands are the commands shown in Telegram's "/" menu and in /help.
+// Admin and chat settings commands are left out on purpose.
+var BotCommands = []struct {
+	Command     string
+	Description string
+}{
+	{"start", "Начать и вступить в челлендж"},
+	{"refresh", "Показать список участников"},
+	{"stats", "Личная статистика"},
+	{"calendar", "Календарь зарядочек за месяц"},
+	{"history", "Календарь месяца текстом"},
+	{"rename", "Сменить своё имя в списке"},
+	{"markdate", "Отметить зарядочку за один из прошлых 7 дней"},
+	{"leaderboard", "Рейтинг участников за месяц"},
+	{"rules", "Правила челленджа"},
+	{"skiptoday", "Осознанно отдохнуть сегодня"},
+	{"freeze", "Заморозить день, чтобы не прервать серию"},
+	{"today", "Поздравление за сегодня или другой день"},
+	{"vacation", "Уйти в отпуск или вернуться: on|off"},
+	{"goal", "Поставить личную цель по серии"},
+	{"digest", "Еженедельная сводка в личку"},
+	{"compare", "Сравнить эту неделю с прошлой"},
+	{"shoutout", "Подбодрить другого участника"},
+	{"leave", "Выйти из челленджа"},
+	{"rejoin", "Вернуться в челлендж после выхода"},
+	{"link", "Делать зарядочку вдвоём с другим участником"},
+	{"unlink", "Отменить совместную зарядочку"},
+	{"longeststreakever", "Рекорд клуба по длине серии"},
+	{"fame", "Аллея славы"},
+	{"certificate", "Сертификат о достижении"},
+	{"teamstats", "Командный зачёт"},
+	{"importstreak", "Перенести серию из другого приложения"},
+	{"setlang", "Сменить язык: ru|en"},
+	{"remindersettings", "Когда придут мои напоминания"},
+	{"remindat", "Выбрать время напоминания"},
+	{"mute", "Выключить свои напоминания"},
+	{"unmute", "Включить свои напоминания"},
+	{"status", "Незавершённые действия"},
+	{"cancel", "Отменить незавершённые действия"},
+	{"help", "Список команд"},
+}
+
+// TierLabels name the completion tiers
+var TierLabels = map[string]string{
+	"easy":   "🟢 Лёгкий",
+	"normal": "🟡 Обычный",
+	"hard":   "🔴 Сложный",
 }
 
 var StatusIcons = map[string]string{
 	"pending":   "⏳",
 	"completed": "✅",
+	"skipped":   "😴",
+	"frozen":    "❄️",
+}
+
+// GridIcons are the squares of the 7-day grid in the participants list
+var GridIcons = map[string]string{
+	"completed":  "🟩",
+	"missed":     "⬜",
+	"not_joined": "▫️",
 }
 
 // GetDayWord returns the correct form of "день/дня/дней" based on count
 func GetDayWord(days int) string {
-	if days%100 >= 11 && days%100 <= 19 {
-		return "дней"
+	return pluralize(days, "day", DefaultLang)
+}
+
+// humanizeDate returns a relative label like "сегодня", "вчера" or "3 дня назад"
+// for date compared to now. Future dates are shown as a plain date.
+func humanizeDate(date, now time.Time, lang string) string {
+	labels, ok := RelativeDateLabels[lang]
+	if !ok {
+		labels = RelativeDateLabels[DefaultLang]
 	}
 
-	switch days % 10 {
-	case 1:
-		return "день"
-	case 2, 3, 4:
-		return "дня"
+	// Compare calendar days only, ignoring time of day and DST shifts
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daysAgo := int(today.Sub(day).Hours() / 24)
+
+	switch {
+	case daysAgo < 0:
+		return date.Format("02.01.2006")
+	case daysAgo == 0:
+		return labels["today"]
+	case daysAgo == 1:
+		return labels["yesterday"]
 	default:
-		return "дней"
+		return fmt.Sprintf(labels["days_ago"], daysAgo, DayWord(lang, daysAgo))
 	}
 }
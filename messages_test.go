@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanizeDate(t *testing.T) {
+	now := time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		date time.Time
+		lang string
+		want string
+	}{
+		{"today", time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC), "ru", "сегодня"},
+		{"yesterday", time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), "ru", "вчера"},
+		{"two days ago", time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC), "ru", "2 дня назад"},
+		{"five days ago", time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC), "ru", "5 дней назад"},
+		{"across a month", time.Date(2024, 2, 9, 12, 0, 0, 0, time.UTC), "ru", "30 дней назад"},
+		{"english", time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), "en", "yesterday"},
+		{"english days ago", time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC), "en", "3 days ago"},
+		{"unknown language", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), "de", "сегодня"},
+		{"future", time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), "ru", "11.03.2024"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := humanizeDate(tt.date, now, tt.lang); got != tt.want {
+				t.Errorf("humanizeDate(%s) = %q, want %q", tt.date.Format("2006-01-02"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreakTitle(t *testing.T) {
+	tests := []struct {
+		lang   string
+		streak int
+		want   string
+	}{
+		{"ru", 0, "Новичок"},
+		{"ru", 6, "Новичок"},
+		{"ru", 7, "Боец"},
+		{"ru", 29, "Боец"},
+		{"ru", 30, "Ветеран"},
+		{"ru", 99, "Ветеран"},
+		{"ru", 100, "Чемпион"},
+		{"ru", 365, "Легенда"},
+		{"ru", 1000, "Легенда"},
+		{"en", 3, "Rookie"},
+		{"en", 45, "Veteran"},
+		{"en", 400, "Legend"},
+		{"de", 10, "Боец"},
+	}
+
+	for _, tt := range tests {
+		if got := StreakTitle(tt.lang, tt.streak); got != tt.want {
+			t.Errorf("StreakTitle(%q, %d) = %q, want %q", tt.lang, tt.streak, got, tt.want)
+		}
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	counts := []int{1, 2, 5, 11, 21, 111}
+	tests := []struct {
+		noun string
+		lang string
+		want []string
+	}{
+		{"day", "ru", []string{"день", "дня", "дней", "дней", "день", "дней"}},
+		{"time", "ru", []string{"раз", "раза", "раз", "раз", "раз", "раз"}},
+		{"freeze", "ru", []string{"заморозка", "заморозки", "заморозок", "заморозок", "заморозка", "заморозок"}},
+		{"day", "en", []string{"day", "days", "days", "days", "days", "days"}},
+		{"time", "en", []string{"time", "times", "times", "times", "times", "times"}},
+		{"freeze", "en", []string{"freeze", "freezes", "freezes", "freezes", "freezes", "freezes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.noun+"/"+tt.lang, func(t *testing.T) {
+			for i, n := range counts {
+				if got := pluralize(n, tt.noun, tt.lang); got != tt.want[i] {
+					t.Errorf("pluralize(%d) = %q, want %q", n, got, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if got := pluralize(-3, "day", "ru"); got != "дня" {
+		t.Errorf("pluralize(-3) = %q, want %q", got, "дня")
+	}
+	if got := pluralize(22, "day", "de"); got != "дня" {
+		t.Errorf("pluralize in an unknown language = %q, want the default %q", got, "дня")
+	}
+}